@@ -0,0 +1,163 @@
+package httpcache
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// KeyFunc returns the cache key of a request.
+// Requests with the same cache key share a stored response, so a KeyFunc must include everything that selects the response except the Vary header field.
+type KeyFunc func(req *http.Request) string
+
+// KeyOption is an option for NewKeyFunc.
+type KeyOption func(*keyConfig) error
+
+type keyConfig struct {
+	ignoreQueries []string
+	headers       []string
+	cookies       []string
+}
+
+// IgnoreQuery ignores the query parameters with the names in the cache key.
+// A name ending with "*" matches the parameters with the prefix, such as "utm_*".
+func IgnoreQuery(names ...string) KeyOption {
+	return func(c *keyConfig) error {
+		for _, n := range names {
+			if n == "" || n == "*" {
+				return errors.New("invalid query parameter name: " + n)
+			}
+		}
+		c.ignoreQueries = append(c.ignoreQueries, names...)
+		return nil
+	}
+}
+
+// IncludeHeaders includes the values of the request header fields in the cache key.
+// The cache keys stored for the same URI with different values are recorded, so that invalidation by unsafe requests deletes all of them.
+func IncludeHeaders(names ...string) KeyOption {
+	return func(c *keyConfig) error {
+		for _, n := range names {
+			c.headers = append(c.headers, http.CanonicalHeaderKey(n))
+		}
+		return nil
+	}
+}
+
+// IncludeCookies includes the values of the cookies in the cache key.
+// As with IncludeHeaders, invalidation by unsafe requests deletes the stored responses for the same URI with all the cookie values.
+func IncludeCookies(names ...string) KeyOption {
+	return func(c *keyConfig) error {
+		c.cookies = append(c.cookies, names...)
+		return nil
+	}
+}
+
+// DefaultKey returns the normalized target URI of the request as the cache key.
+// The scheme and host are lowercased, the default port is removed, an empty path is replaced with "/", and the query parameters are sorted by name.
+// The target URI of a request received by a server is reconstructed from the Host header field and the connection.
+func DefaultKey(req *http.Request) string {
+	return normalizeURL(req, nil)
+}
+
+// NewKeyFunc returns a KeyFunc that extends DefaultKey with the options.
+func NewKeyFunc(opts ...KeyOption) (KeyFunc, error) {
+	c := &keyConfig{}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	return func(req *http.Request) string {
+		var b strings.Builder
+		b.WriteString(normalizeURL(req, c.ignoreQueries))
+		for _, h := range c.headers {
+			b.WriteString("\n" + h + ":" + strings.Join(req.Header.Values(h), ","))
+		}
+		for _, n := range c.cookies {
+			b.WriteString("\ncookie " + n)
+			if ck, err := req.Cookie(n); err == nil {
+				b.WriteString("=" + ck.Value)
+			}
+		}
+		return b.String()
+	}, nil
+}
+
+func normalizeURL(req *http.Request, ignoreQueries []string) string {
+	u := *req.URL
+	u.User = nil
+	u.Fragment, u.RawFragment = "", ""
+	if u.Host == "" {
+		// The request received by a server has the target URI reconstructed from the Host header field.
+		u.Host = req.Host
+	}
+	if u.Scheme == "" {
+		u.Scheme = "http"
+		if req.TLS != nil {
+			u.Scheme = "https"
+		}
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if host, port, err := net.SplitHostPort(u.Host); err == nil {
+		if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") || port == "" {
+			u.Host = host
+			if strings.Contains(host, ":") {
+				u.Host = "[" + host + "]"
+			}
+		}
+	}
+	if u.Path == "" && u.Opaque == "" {
+		u.Path, u.RawPath = "/", ""
+	}
+	u.RawQuery = normalizeQuery(u.RawQuery, ignoreQueries)
+	u.ForceQuery = false
+	return u.String()
+}
+
+// normalizeQuery sorts the query parameters by name, keeping the order of the values of each parameter.
+func normalizeQuery(rawQuery string, ignoreQueries []string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	params := strings.Split(rawQuery, "&")
+	kept := params[:0]
+	for _, p := range params {
+		if p == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(p, "=")
+		if n, err := url.QueryUnescape(name); err == nil {
+			name = n
+		}
+		if ignoredQuery(name, ignoreQueries) {
+			continue
+		}
+		kept = append(kept, p)
+	}
+	sort.SliceStable(kept, func(i, j int) bool {
+		ni, _, _ := strings.Cut(kept[i], "=")
+		nj, _, _ := strings.Cut(kept[j], "=")
+		return ni < nj
+	})
+	return strings.Join(kept, "&")
+}
+
+func ignoredQuery(name string, ignoreQueries []string) bool {
+	for _, q := range ignoreQueries {
+		if prefix, ok := strings.CutSuffix(q, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+			continue
+		}
+		if name == q {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,213 @@
+package httpcache_test
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/k1LoW/httpcache"
+	"github.com/k1LoW/httpcache/rfc9111"
+	"github.com/k1LoW/httpcache/storage/memory"
+)
+
+func TestDefaultKey(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want bool
+	}{
+		{"query order", "https://example.com/?a=1&b=2", "https://example.com/?b=2&a=1", true},
+		{"order of values", "https://example.com/?a=1&a=2", "https://example.com/?a=2&a=1", false},
+		{"host case", "https://Example.COM/path", "https://example.com/path", true},
+		{"scheme case", "HTTPS://example.com/", "https://example.com/", true},
+		{"default port", "https://example.com:443/", "https://example.com/", true},
+		{"non default port", "https://example.com:8443/", "https://example.com/", false},
+		{"empty path", "https://example.com", "https://example.com/", true},
+		{"path case", "https://example.com/Path", "https://example.com/path", false},
+		{"fragment", "https://example.com/#a", "https://example.com/", true},
+		{"empty query", "https://example.com/?", "https://example.com/", true},
+		{"scheme", "http://example.com/", "https://example.com/", false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			reqA, err := http.NewRequest(http.MethodGet, tt.a, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reqB, err := http.NewRequest(http.MethodGet, tt.b, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a := httpcache.DefaultKey(reqA)
+			b := httpcache.DefaultKey(reqB)
+			if got := a == b; got != tt.want {
+				t.Errorf("DefaultKey() got %q and %q", a, b)
+			}
+		})
+	}
+
+	// The target URI of a request received by a server is reconstructed.
+	req := httptest.NewRequest(http.MethodGet, "/path?b=2&a=1", nil)
+	req.Host = "Example.com:443"
+	req.TLS = &tls.ConnectionState{}
+	if got, want := httpcache.DefaultKey(req), "https://example.com/path?a=1&b=2"; got != want {
+		t.Errorf("DefaultKey() = %q, want %q", got, want)
+	}
+}
+
+func TestNewKeyFunc(t *testing.T) {
+	key, err := httpcache.NewKeyFunc(
+		httpcache.IgnoreQuery("utm_*", "fbclid"),
+		httpcache.IncludeHeaders("x-tenant"),
+		httpcache.IncludeCookies("lang"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newReq := func(target, tenant, lang string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if tenant != "" {
+			req.Header.Set("X-Tenant", tenant)
+		}
+		if lang != "" {
+			req.AddCookie(&http.Cookie{Name: "lang", Value: lang})
+		}
+		req.AddCookie(&http.Cookie{Name: "session", Value: target})
+		return req
+	}
+	tests := []struct {
+		name string
+		a    *http.Request
+		b    *http.Request
+		want bool
+	}{
+		{"ignored query", newReq("https://example.com/?id=1&utm_source=a&fbclid=x", "", ""), newReq("https://example.com/?utm_medium=b&id=1", "", ""), true},
+		{"not ignored query", newReq("https://example.com/?id=1", "", ""), newReq("https://example.com/?id=2", "", ""), false},
+		{"same header", newReq("https://example.com/", "a", ""), newReq("https://example.com/", "a", ""), true},
+		{"different header", newReq("https://example.com/", "a", ""), newReq("https://example.com/", "b", ""), false},
+		{"different cookie", newReq("https://example.com/", "", "en"), newReq("https://example.com/", "", "ja"), false},
+		{"absent cookie", newReq("https://example.com/", "", ""), newReq("https://example.com/", "", "ja"), false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := key(tt.a) == key(tt.b); got != tt.want {
+				t.Errorf("KeyFunc() got %q and %q", key(tt.a), key(tt.b))
+			}
+		})
+	}
+
+	if _, err := httpcache.NewKeyFunc(httpcache.IgnoreQuery("*")); err == nil {
+		t.Error("NewKeyFunc() want error")
+	}
+}
+
+func TestTransport_CacheKey(t *testing.T) {
+	var mu sync.Mutex
+	hits := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits++
+		mu.Unlock()
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte("hello"))
+	}))
+	t.Cleanup(ts.Close)
+
+	key, err := httpcache.NewKeyFunc(httpcache.IgnoreQuery("utm_*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The cache key is passed to the handler, which does not need to be configured with it.
+	s, err := rfc9111.NewShared()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := memory.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: httpcache.NewTransport(s, store, nil, httpcache.CacheKey(key))}
+	for _, q := range []string{"?a=1&b=2", "?b=2&a=1", "?a=1&utm_source=x&b=2"} {
+		res, err := client.Get(ts.URL + q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(io.Discard, res.Body)
+		_ = res.Body.Close()
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if hits != 1 {
+		t.Errorf("got %d hits, want %d", hits, 1)
+	}
+}
+
+func TestTransport_InvalidateIncludedHeaders(t *testing.T) {
+	var mu sync.Mutex
+	hits := map[string]int{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.Method+" "+r.Header.Get("X-Tenant")]++
+		mu.Unlock()
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte(r.Header.Get("X-Tenant")))
+	}))
+	t.Cleanup(ts.Close)
+
+	key, err := httpcache.NewKeyFunc(httpcache.IncludeHeaders("X-Tenant"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := rfc9111.NewShared()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := memory.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: httpcache.NewTransport(s, store, nil, httpcache.CacheKey(key))}
+	do := func(method, tenant string) {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Tenant", tenant)
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(io.Discard, res.Body)
+		_ = res.Body.Close()
+	}
+	do(http.MethodGet, "a")
+	do(http.MethodGet, "b")
+	do(http.MethodGet, "a")
+	do(http.MethodGet, "b")
+	// The unsafe request invalidates the stored responses for the target URI with all the header field values.
+	do(http.MethodPost, "c")
+	do(http.MethodGet, "a")
+	do(http.MethodGet, "b")
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := map[string]int{"GET a": 2, "GET b": 2, "POST c": 1}
+	for k, v := range want {
+		if hits[k] != v {
+			t.Errorf("got %d hits for %s, want %d", hits[k], k, v)
+		}
+	}
+	// The keys of the stored responses, including the storable response to POST, are listed for the target URI.
+	if got := store.Len(); got != 4 {
+		t.Errorf("got %d stored entries, want the entries for the three keys and the list of them", got)
+	}
+}
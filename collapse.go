@@ -0,0 +1,189 @@
+package httpcache
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CollapsedForwarding collapses concurrent requests forwarded by Transport or the middleware for the same cache key into a single request, and shares its response.
+// A waiting request is forwarded on its own if the response is not shared within timeout.
+// If timeout is zero or negative, a waiting request waits until the response is shared or its context is done.
+// The shared response body is read into memory, and the response is shared only if it is storable or is a 304 (Not Modified) response to the same conditional request,
+// and its body is not larger than the limit set by CollapsedForwardingMaxBodySize.
+func CollapsedForwarding(timeout time.Duration) Option {
+	return func(c *config) {
+		c.collapseTimeout = timeout
+		c.collapse = true
+	}
+}
+
+// defaultCollapsedMaxBodySize is the default maximum size of a response body shared with collapsed requests.
+const defaultCollapsedMaxBodySize = 1 << 20
+
+// CollapsedForwardingMaxBodySize sets the maximum size in bytes of a response body read into memory to be shared with collapsed requests (see CollapsedForwarding).
+// A larger response is streamed to the request that forwarded it without being shared, and the collapsed requests are forwarded on their own.
+// The default is 1 MiB. If n is negative, the default is used.
+func CollapsedForwardingMaxBodySize(n int64) Option {
+	return func(c *config) {
+		if n < 0 {
+			n = defaultCollapsedMaxBodySize
+		}
+		c.collapseMaxBodySize = n
+	}
+}
+
+// collapseHeaders are the request header fields that must be the same for requests to be collapsed.
+var collapseHeaders = []string{
+	"Authorization",
+	"If-Modified-Since",
+	"If-None-Match",
+	"Range",
+}
+
+// testHookCollapsed is called with the cache key when a request is collapsed with the concurrent request for the same key. It is set by tests.
+var testHookCollapsed func(key string)
+
+// flightGroup collapses concurrent requests with the same key.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+	// maxBodySize is the maximum size of a response body shared with the collapsed requests.
+	maxBodySize int64
+}
+
+// flight is a request forwarded on behalf of the collapsed requests.
+type flight struct {
+	done   chan struct{}
+	req    *http.Request
+	res    *http.Response
+	body   []byte
+	shared bool
+}
+
+// collapseKey returns the key of the requests that can be collapsed with the request.
+// Only GET and HEAD requests can be collapsed.
+func collapseKey(key string, req *http.Request) (string, bool) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return "", false
+	}
+	k := key + "\x00" + req.Method
+	for _, h := range collapseHeaders {
+		k += "\x00" + req.Header.Get(h)
+	}
+	return k, true
+}
+
+// forward forwards the request with fetch, collapsing it with the concurrent requests for the same key if g is not nil.
+// A response is shared if it is storable, or if it is a 304 (Not Modified) response to the same conditional request.
+func forward(g *flightGroup, timeout time.Duration, h HandlerV2, key string, req *http.Request, fetch func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	if g == nil {
+		return fetch(req)
+	}
+	return g.do(key, req, timeout, fetch, func(req *http.Request, res *http.Response) bool {
+		if res.StatusCode == http.StatusNotModified {
+			return true
+		}
+		ok, _ := h.Storable(req.Context(), req, res, time.Now())
+		return ok
+	})
+}
+
+// do forwards the request with fetch, or waits for the response to the concurrent request with the same key.
+// shareable reports whether the response can be shared with the waiting requests.
+func (g *flightGroup) do(key string, req *http.Request, timeout time.Duration, fetch func(*http.Request) (*http.Response, error), shareable func(*http.Request, *http.Response) bool) (*http.Response, error) {
+	ck, ok := collapseKey(key, req)
+	if !ok {
+		return fetch(req)
+	}
+	g.mu.Lock()
+	if g.flights == nil {
+		g.flights = map[string]*flight{}
+	}
+	if f, ok := g.flights[ck]; ok {
+		g.mu.Unlock()
+		if testHookCollapsed != nil {
+			testHookCollapsed(key)
+		}
+		return f.wait(req, timeout, fetch)
+	}
+	f := &flight{done: make(chan struct{}), req: req}
+	g.flights[ck] = f
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.flights, ck)
+		g.mu.Unlock()
+		close(f.done)
+	}()
+	res, err := fetch(req)
+	if err != nil {
+		return nil, err
+	}
+	if !shareable(req, res) || res.ContentLength > g.maxBodySize {
+		return res, nil
+	}
+	// The body of unknown length is read up to the limit, and streamed without being shared if it is larger.
+	b, err := io.ReadAll(io.LimitReader(res.Body, g.maxBodySize+1))
+	if err != nil {
+		_ = res.Body.Close()
+		return nil, err
+	}
+	if int64(len(b)) > g.maxBodySize {
+		res.Body = &prefixedBody{Reader: io.MultiReader(bytes.NewReader(b), res.Body), Closer: res.Body}
+		return res, nil
+	}
+	if err := res.Body.Close(); err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(b))
+	// The header is copied because the caller may modify the response.
+	shared := *res
+	shared.Header = res.Header.Clone()
+	f.res, f.body, f.shared = &shared, b, true
+	return res, nil
+}
+
+// wait waits for the response to the flight and returns a copy of it.
+// If the response is not shared within timeout, the request is forwarded with fetch. A zero or negative timeout never expires.
+func (f *flight) wait(req *http.Request, timeout time.Duration, fetch func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-f.done:
+	case <-expired:
+		return fetch(req)
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	if !f.shared {
+		return fetch(req)
+	}
+	// The shared response must be selected for the request by the Vary header field (https://www.rfc-editor.org/rfc/rfc9111#section-4.1).
+	if vary := f.res.Header.Values("Vary"); len(vary) != 0 {
+		k, ok := VaryKey(vary, req.Header)
+		fk, _ := VaryKey(vary, f.req.Header)
+		if !ok || k != fk {
+			return fetch(req)
+		}
+	}
+	res := *f.res
+	res.Header = f.res.Header.Clone()
+	res.Header.Set(HeaderCollapsed, "1")
+	res.Body = io.NopCloser(bytes.NewReader(f.body))
+	res.Request = req
+	return &res, nil
+}
+
+// prefixedBody is a response body whose first bytes have already been read from it.
+type prefixedBody struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,145 @@
+package httpcache_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/k1LoW/httpcache"
+	"github.com/k1LoW/httpcache/rfc9111"
+	"github.com/k1LoW/httpcache/storage/memory"
+)
+
+func TestCollapsedForwarding(t *testing.T) {
+	const requests = 5
+	tests := []struct {
+		name         string
+		cacheControl string
+		opts         []httpcache.Option
+		// waitHits is the number of requests the origin receives before it responds to the first one it receives.
+		waitHits int
+		wantHits int
+	}{
+		{"storable response is shared", "max-age=60", []httpcache.Option{httpcache.CollapsedForwarding(time.Minute)}, 1, 1},
+		{"response not storable is not shared", "no-store", []httpcache.Option{httpcache.CollapsedForwarding(time.Minute)}, 1, requests},
+		{"timeout", "max-age=60", []httpcache.Option{httpcache.CollapsedForwarding(time.Millisecond)}, requests, requests},
+		{"zero timeout never expires", "max-age=60", []httpcache.Option{httpcache.CollapsedForwarding(0)}, 1, 1},
+		{"response larger than the limit is not shared", "max-age=60", []httpcache.Option{httpcache.CollapsedForwarding(time.Minute), httpcache.CollapsedForwardingMaxBodySize(4)}, 1, requests},
+	}
+	// The hook is called for the requests to all the servers, and calls the function registered for the server.
+	var collapsedHooks sync.Map
+	t.Cleanup(httpcache.SetTestHookCollapsed(func(key string) {
+		collapsedHooks.Range(func(prefix, f any) bool {
+			if strings.HasPrefix(key, prefix.(string)) {
+				f.(func())()
+			}
+			return true
+		})
+	}))
+	for _, tt := range tests {
+		tt := tt
+		for _, middleware := range []bool{false, true} {
+			middleware := middleware
+			name := tt.name + " Transport"
+			if middleware {
+				name = tt.name + " middleware"
+			}
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				var (
+					mu        sync.Mutex
+					hits      int
+					collapsed int
+				)
+				cond := sync.NewCond(&mu)
+				h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					mu.Lock()
+					hits++
+					first := hits == 1
+					cond.Broadcast()
+					// No request is responded to before all the other requests are collapsed with the first one.
+					for collapsed < requests-1 || (first && hits < tt.waitHits) {
+						cond.Wait()
+					}
+					mu.Unlock()
+					w.Header().Set("Cache-Control", tt.cacheControl)
+					_, _ = w.Write([]byte("hello"))
+				})
+				s, err := rfc9111.NewShared(rfc9111.CacheStatus("test"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				store, err := memory.New()
+				if err != nil {
+					t.Fatal(err)
+				}
+				var (
+					ts     *httptest.Server
+					client *http.Client
+				)
+				if middleware {
+					ts = httptest.NewServer(httpcache.NewMiddleware(s, store, tt.opts...)(h))
+					client = http.DefaultClient
+				} else {
+					ts = httptest.NewServer(h)
+					client = &http.Client{Transport: httpcache.NewTransport(s, store, nil, tt.opts...)}
+				}
+				t.Cleanup(ts.Close)
+				collapsedHooks.Store(ts.URL+"/", func() {
+					mu.Lock()
+					defer mu.Unlock()
+					collapsed++
+					cond.Broadcast()
+				})
+
+				wg := &sync.WaitGroup{}
+				statuses := make(chan string, requests)
+				for i := 0; i < requests; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						res, err := client.Get(ts.URL)
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						defer res.Body.Close()
+						b, err := io.ReadAll(res.Body)
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						if string(b) != "hello" {
+							t.Errorf("got body %q, want %q", string(b), "hello")
+						}
+						if res.Header.Get(httpcache.HeaderCollapsed) != "" {
+							t.Errorf("got %s %q", httpcache.HeaderCollapsed, res.Header.Get(httpcache.HeaderCollapsed))
+						}
+						statuses <- res.Header.Get("Cache-Status")
+					}()
+				}
+				wg.Wait()
+				close(statuses)
+
+				mu.Lock()
+				defer mu.Unlock()
+				if hits != tt.wantHits {
+					t.Errorf("got %d hits, want %d", hits, tt.wantHits)
+				}
+				shared := 0
+				for st := range statuses {
+					if strings.HasSuffix(st, "; collapsed") {
+						shared++
+					}
+				}
+				if want := requests - tt.wantHits; shared != want {
+					t.Errorf("got %d collapsed responses, want %d", shared, want)
+				}
+			})
+		}
+	}
+}
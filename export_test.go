@@ -0,0 +1,11 @@
+package httpcache
+
+// SetTestHookCollapsed sets the function called with the cache key when a request is collapsed with the concurrent request for the same key,
+// and returns the function to restore it.
+func SetTestHookCollapsed(f func(key string)) (restore func()) {
+	prev := testHookCollapsed
+	testHookCollapsed = f
+	return func() {
+		testHookCollapsed = prev
+	}
+}
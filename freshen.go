@@ -0,0 +1,46 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+// freshen freshens or invalidates the stored response with the response to the request if the Handler is a Freshener,
+// and returns true if the stored response is affected, in which case the response to the request is not stored.
+// The stored response must not have been used for the request, so that its body is stored again.
+// If the stored response is affected, its body is closed.
+func freshen(h HandlerV2, s Storage, key string, req *http.Request, res *http.Response, cachedReq *http.Request, cachedRes *http.Response, reqTime, resTime time.Time) (bool, error) {
+	f, ok := unwrap(h).(Freshener)
+	if !ok || cachedReq == nil || cachedRes == nil {
+		return false, nil
+	}
+	affected, freshened := f.Freshen(req, res, cachedReq, cachedRes)
+	if !affected {
+		return false, nil
+	}
+	ok, expires := false, time.Time{}
+	if freshened != nil {
+		ok, expires = h.Storable(req.Context(), cachedReq, freshened, resTime)
+	}
+	if !ok {
+		_ = cachedRes.Body.Close()
+		if vk, ok := variantKey(key, cachedReq, cachedRes); ok {
+			return true, s.Delete(vk)
+		}
+		return true, s.Delete(key)
+	}
+	k, err := entryKey(s, key, cachedReq, freshened, expires)
+	if err != nil {
+		_ = cachedRes.Body.Close()
+		return true, err
+	}
+	stored := *freshened
+	stored.Header = storedHeader(h, cachedReq, freshened, reqTime, resTime)
+	body := newStoreBody(s, k, cachedReq, &stored, expires, bodyLength(cachedReq, freshened), nil)
+	if _, err := io.Copy(io.Discard, body); err != nil {
+		_ = body.Close()
+		return true, err
+	}
+	return true, body.Close()
+}
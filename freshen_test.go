@@ -0,0 +1,175 @@
+package httpcache_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/k1LoW/httpcache"
+	"github.com/k1LoW/httpcache/rfc9111"
+	"github.com/k1LoW/httpcache/storage/memory"
+)
+
+func TestTransport_Head(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		hits    = map[string]int{}
+		etag    = `"v1"`
+		version = "1"
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		hits[r.Method]++
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", etag)
+		w.Header().Set("X-Version", version)
+		_, _ = w.Write([]byte("hello"))
+	}))
+	t.Cleanup(ts.Close)
+
+	s, err := rfc9111.NewShared()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := memory.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: httpcache.NewTransport(s, store, nil)}
+	get := func(method string, header http.Header) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+		if method == http.MethodHead && len(b) != 0 {
+			t.Errorf("got body %q in the response to HEAD", string(b))
+		}
+		return res
+	}
+	stored := func() *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cachedReq, cachedRes, err := store.Get(httpcache.DefaultKey(req))
+		if err != nil {
+			return nil
+		}
+		if cachedReq.Method != http.MethodGet {
+			t.Errorf("got stored method %s", cachedReq.Method)
+		}
+		b, err := io.ReadAll(cachedRes.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != "hello" {
+			t.Errorf("got stored body %q", string(b))
+		}
+		return cachedRes
+	}
+
+	_ = get(http.MethodGet, nil)
+
+	// HEAD is served from the stored response to GET.
+	res := get(http.MethodHead, nil)
+	if res.Header.Get("Age") == "" {
+		t.Error("the response to HEAD is not served from the cache")
+	}
+	if hits[http.MethodHead] != 0 {
+		t.Errorf("got %d HEAD hits, want 0", hits[http.MethodHead])
+	}
+
+	// The response to HEAD with matching validators freshens the stored response.
+	mu.Lock()
+	version = "2"
+	mu.Unlock()
+	_ = get(http.MethodHead, http.Header{"Cache-Control": []string{"no-cache"}})
+	if hits[http.MethodHead] != 1 {
+		t.Errorf("got %d HEAD hits, want 1", hits[http.MethodHead])
+	}
+	cachedRes := stored()
+	if cachedRes == nil {
+		t.Fatal("the stored response is invalidated")
+	}
+	if got := cachedRes.Header.Get("X-Version"); got != "2" {
+		t.Errorf("got stored X-Version %q, want %q", got, "2")
+	}
+
+	// The response to HEAD with a different validator invalidates the stored response.
+	mu.Lock()
+	etag = `"v2"`
+	mu.Unlock()
+	_ = get(http.MethodHead, http.Header{"Cache-Control": []string{"no-cache"}})
+	if stored() != nil {
+		t.Error("the stored response is not invalidated")
+	}
+	if hits[http.MethodGet] != 1 {
+		t.Errorf("got %d GET hits, want 1", hits[http.MethodGet])
+	}
+}
+
+func TestTransport_HeadError(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		etag = `"v1"`
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", etag)
+		_, _ = w.Write([]byte("hello"))
+	}))
+	t.Cleanup(ts.Close)
+
+	s, err := rfc9111.NewShared()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := memory.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: httpcache.NewTransport(s, &deleteErrorStorage{Storage: store}, nil)}
+	res, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.Copy(io.Discard, res.Body)
+	_ = res.Body.Close()
+
+	// The response to HEAD with a different validator is returned even if the stored response cannot be invalidated.
+	mu.Lock()
+	etag = `"v2"`
+	mu.Unlock()
+	req, err := http.NewRequest(http.MethodHead, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Cache-Control", "no-cache")
+	res, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if got := res.Header.Get("ETag"); got != `"v2"` {
+		t.Errorf("got ETag %q, want %q", got, `"v2"`)
+	}
+}
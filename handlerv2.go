@@ -0,0 +1,145 @@
+package httpcache
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Outcome is how the response to a request is obtained by HandlerV2.
+type Outcome int
+
+const (
+	// OutcomeMiss means that the stored response is not used and the response is obtained by forwarding the request.
+	OutcomeMiss Outcome = iota
+	// OutcomeHit means that the stored response is used without validation.
+	OutcomeHit
+	// OutcomeStale means that the stored response is used stale without successful validation,
+	// such as when it is allowed by the stale-while-revalidate or max-stale directive, or when the forwarded request failed.
+	OutcomeStale
+	// OutcomeRevalidated means that the stored response is used after it is validated and freshened.
+	OutcomeRevalidated
+)
+
+// String returns the name of the outcome.
+func (o Outcome) String() string {
+	switch o {
+	case OutcomeMiss:
+		return "miss"
+	case OutcomeHit:
+		return "hit"
+	case OutcomeStale:
+		return "stale"
+	case OutcomeRevalidated:
+		return "revalidated"
+	default:
+		return "unknown"
+	}
+}
+
+// Decision is the result of handling a request by HandlerV2.
+type Decision struct {
+	// Outcome is how Response is obtained.
+	Outcome Outcome
+	// Response is the response to the request.
+	// If Outcome is OutcomeRevalidated, it is the stored response freshened with the 304 (Not Modified) response, which is stored again.
+	Response *http.Response
+	// Expires is the expiration time of Response to be stored if Outcome is OutcomeMiss or OutcomeRevalidated.
+	// Response is not stored if it is zero.
+	Expires time.Time
+	// UpdateHeader is the header fields the stored response is updated with if Outcome is OutcomeRevalidated,
+	// such as those of the 304 (Not Modified) response. Response has already been updated with them, so Transport and NewMiddleware store Response again instead.
+	UpdateHeader http.Header
+	// Invalidations are the URIs whose stored responses are invalidated by Response.
+	Invalidations []*url.URL
+}
+
+// HandlerV2 is a context-aware Handler that returns its decision as a Decision.
+// Handle may call do in the background after it returns, with a context marked by ContextWithBackground,
+// to refresh the stored response. The caller stores the response to such a request if it is storable, and returns it with an empty body.
+// The caller forwards one such request per cache key at a time, and returns an error for the others.
+// Use AdaptHandler to use a Handler as a HandlerV2.
+type HandlerV2 interface {
+	Handle(ctx context.Context, req *http.Request, cachedReq *http.Request, cachedRes *http.Response, do func(context.Context, *http.Request) (*http.Response, error), now time.Time) (*Decision, error)
+	Storable(ctx context.Context, req *http.Request, res *http.Response, now time.Time) (ok bool, expires time.Time)
+}
+
+var _ HandlerV2 = (*handlerAdapter)(nil)
+
+// handlerAdapter is a HandlerV2 that calls a Handler.
+type handlerAdapter struct {
+	h Handler
+}
+
+// AdaptHandler returns a HandlerV2 that calls h, so that existing Handler implementations can be used as HandlerV2.
+// The optional interfaces implemented by h, such as Invalidator and Sanitizer, are still used by Transport and NewMiddleware.
+// If h has a HandlerV2 method, such as the handlers of the rfc9111 package, AdaptHandler returns the HandlerV2 it returns instead.
+// Otherwise, because a Handler does not report whether the stored response is stale, a stored response used without forwarding the request is reported as OutcomeHit.
+func AdaptHandler(h Handler) HandlerV2 {
+	if v, ok := h.(interface{ HandlerV2() HandlerV2 }); ok {
+		return v.HandlerV2()
+	}
+	return &handlerAdapter{h: h}
+}
+
+// Handle implements HandlerV2.
+func (a *handlerAdapter) Handle(ctx context.Context, req *http.Request, cachedReq *http.Request, cachedRes *http.Response, do func(context.Context, *http.Request) (*http.Response, error), now time.Time) (*Decision, error) {
+	var (
+		forwarded bool
+		validated *http.Response
+		resTime   time.Time
+	)
+	cacheUsed, res, err := a.h.Handle(req.WithContext(ctx), cachedReq, cachedRes, func(r *http.Request) (*http.Response, error) {
+		if IsBackground(r.Context()) {
+			return do(r.Context(), r)
+		}
+		res, err := do(r.Context(), r)
+		forwarded, resTime = true, time.Now()
+		if err == nil && res.StatusCode == http.StatusNotModified {
+			validated = res
+		}
+		return res, err
+	}, now)
+	if err != nil {
+		return nil, err
+	}
+	d := &Decision{Response: res}
+	switch {
+	case !cacheUsed:
+		d.Outcome = OutcomeMiss
+		if i, ok := a.h.(Invalidator); ok {
+			d.Invalidations = i.Invalidate(req, res)
+		}
+	case validated != nil:
+		d.Outcome = OutcomeRevalidated
+		d.UpdateHeader = validated.Header
+	case forwarded:
+		d.Outcome = OutcomeStale
+		return d, nil
+	default:
+		d.Outcome = OutcomeHit
+		return d, nil
+	}
+	if resTime.IsZero() {
+		// The response was not obtained through do.
+		resTime = time.Now()
+	}
+	if ok, expires := a.h.Storable(req, res, resTime); ok {
+		d.Expires = expires
+	}
+	return d, nil
+}
+
+// Storable implements HandlerV2.
+func (a *handlerAdapter) Storable(_ context.Context, req *http.Request, res *http.Response, now time.Time) (bool, time.Time) {
+	return a.h.Storable(req, res, now)
+}
+
+// unwrap returns the Handler adapted by AdaptHandler, or h itself, to find the optional interfaces it implements.
+func unwrap(h HandlerV2) any {
+	if a, ok := h.(*handlerAdapter); ok {
+		return a.h
+	}
+	return h
+}
@@ -0,0 +1,240 @@
+package httpcache_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/k1LoW/httpcache"
+	"github.com/k1LoW/httpcache/rfc9111"
+	"github.com/k1LoW/httpcache/storage/memory"
+)
+
+func TestAdaptHandler(t *testing.T) {
+	// The adapter checks whether the response is storable at the time it is received.
+	now := time.Now()
+	endpoint, err := url.Parse("https://example.com/api/v1/path/to/resource")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name              string
+		method            string
+		reqHeader         http.Header
+		cachedHeader      http.Header
+		originStatus      int
+		originErr         error
+		wantOutcome       httpcache.Outcome
+		wantExpires       bool
+		wantUpdateHeader  bool
+		wantInvalidations []string
+	}{
+		{"miss", http.MethodGet, http.Header{}, nil, http.StatusOK, nil, httpcache.OutcomeMiss, true, false, nil},
+		{"hit", http.MethodGet, http.Header{}, http.Header{"Cache-Control": []string{"max-age=60"}}, http.StatusOK, nil, httpcache.OutcomeHit, false, false, nil},
+		{"revalidated", http.MethodGet, http.Header{}, http.Header{"Cache-Control": []string{"max-age=0, must-revalidate"}, "Etag": []string{`"v1"`}}, http.StatusNotModified, nil, httpcache.OutcomeRevalidated, true, true, nil},
+		{"stale", http.MethodGet, http.Header{"Cache-Control": []string{"max-age=0"}}, http.Header{"Cache-Control": []string{"max-age=0, stale-if-error=60"}}, 0, errors.New("connection refused"), httpcache.OutcomeStale, false, false, nil},
+		{"stale-while-revalidate", http.MethodGet, http.Header{}, http.Header{"Cache-Control": []string{"max-age=0, stale-while-revalidate=60"}}, http.StatusOK, nil, httpcache.OutcomeStale, false, false, nil},
+		{"max-stale", http.MethodGet, http.Header{"Cache-Control": []string{"max-stale=60"}}, http.Header{"Cache-Control": []string{"max-age=0"}}, http.StatusOK, nil, httpcache.OutcomeStale, false, false, nil},
+		{"error", http.MethodGet, http.Header{}, nil, 0, errors.New("connection refused"), httpcache.OutcomeMiss, false, false, nil},
+		{"invalidations", http.MethodPost, http.Header{}, nil, http.StatusOK, nil, httpcache.OutcomeMiss, true, false, []string{"https://example.com/api/v1/path/to/resource"}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			s, err := rfc9111.NewShared()
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := httpcache.AdaptHandler(s)
+			req := &http.Request{Method: tt.method, URL: endpoint, Header: tt.reqHeader}
+			var (
+				cachedReq *http.Request
+				cachedRes *http.Response
+			)
+			if tt.cachedHeader != nil {
+				cachedReq = &http.Request{Method: tt.method, URL: endpoint, Header: http.Header{}}
+				header := tt.cachedHeader.Clone()
+				header.Set("Date", now.Format(http.TimeFormat))
+				cachedRes = &http.Response{StatusCode: http.StatusOK, Header: header, Body: http.NoBody}
+			}
+			do := func(ctx context.Context, req *http.Request) (*http.Response, error) {
+				if tt.originErr != nil {
+					return nil, tt.originErr
+				}
+				header := http.Header{
+					"Cache-Control": []string{"max-age=60"},
+					"Date":          []string{now.Format(http.TimeFormat)},
+				}
+				return &http.Response{StatusCode: tt.originStatus, Header: header, Body: http.NoBody, Request: req}, nil
+			}
+			d, err := h.Handle(context.Background(), req, cachedReq, cachedRes, do, now)
+			if tt.originErr != nil && cachedRes == nil {
+				if !errors.Is(err, tt.originErr) {
+					t.Errorf("got error %v, want %v", err, tt.originErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.Outcome != tt.wantOutcome {
+				t.Errorf("got outcome %v, want %v", d.Outcome, tt.wantOutcome)
+			}
+			if got := !d.Expires.IsZero(); got != tt.wantExpires {
+				t.Errorf("got expires %v", d.Expires)
+			}
+			if got := d.UpdateHeader != nil; got != tt.wantUpdateHeader {
+				t.Errorf("got update header %v", d.UpdateHeader)
+			}
+			var got []string
+			for _, u := range d.Invalidations {
+				got = append(got, u.String())
+			}
+			if diff := cmp.Diff(tt.wantInvalidations, got); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestAdaptHandler_Handler(t *testing.T) {
+	now := time.Now()
+	endpoint, err := url.Parse("https://example.com/api/v1/path/to/resource")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := rfc9111.NewShared()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The Handler without the HandlerV2 method is adapted by handlerAdapter.
+	h := httpcache.AdaptHandler(struct{ httpcache.Handler }{s})
+	req := &http.Request{Method: http.MethodGet, URL: endpoint, Header: http.Header{}}
+	cachedReq := &http.Request{Method: http.MethodGet, URL: endpoint, Header: http.Header{}}
+	cachedRes := &http.Response{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Cache-Control": []string{"max-age=0, must-revalidate"},
+			"Date":          []string{now.Format(http.TimeFormat)},
+			"Etag":          []string{`"v1"`},
+		},
+		Body: http.NoBody,
+	}
+	do := func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		header := http.Header{
+			"Cache-Control": []string{"max-age=60"},
+			"Date":          []string{now.Format(http.TimeFormat)},
+			"Etag":          []string{`"v1"`},
+		}
+		return &http.Response{StatusCode: http.StatusNotModified, Header: header, Body: http.NoBody, Request: req}, nil
+	}
+	d, err := h.Handle(context.Background(), req, cachedReq, cachedRes, do, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Outcome != httpcache.OutcomeRevalidated {
+		t.Errorf("got outcome %v, want %v", d.Outcome, httpcache.OutcomeRevalidated)
+	}
+	if got := d.UpdateHeader.Get("Cache-Control"); got != "max-age=60" {
+		t.Errorf("got update header Cache-Control %q, want %q", got, "max-age=60")
+	}
+}
+
+// staticHandler is a HandlerV2 that always uses the stored response if there is one, and stores every response for a minute.
+type staticHandler struct{}
+
+func (staticHandler) Handle(ctx context.Context, req *http.Request, cachedReq *http.Request, cachedRes *http.Response, do func(context.Context, *http.Request) (*http.Response, error), now time.Time) (*httpcache.Decision, error) {
+	if cachedRes != nil {
+		return &httpcache.Decision{Outcome: httpcache.OutcomeHit, Response: cachedRes}, nil
+	}
+	res, err := do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return &httpcache.Decision{Outcome: httpcache.OutcomeMiss, Response: res, Expires: now.Add(time.Minute)}, nil
+}
+
+func (staticHandler) Storable(ctx context.Context, req *http.Request, res *http.Response, now time.Time) (bool, time.Time) {
+	return true, now.Add(time.Minute)
+}
+
+func TestNewTransportV2(t *testing.T) {
+	hits := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("Cache-Control", "no-store")
+		_, _ = w.Write([]byte("hello"))
+	}))
+	t.Cleanup(ts.Close)
+	store, err := memory.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: httpcache.NewTransportV2(staticHandler{}, store, nil)}
+	for i := 0; i < 2; i++ {
+		res, err := client.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != "hello" {
+			t.Errorf("got body %q", string(b))
+		}
+		for _, k := range []string{httpcache.HeaderRequestTime, httpcache.HeaderResponseTime} {
+			if res.Header.Get(k) != "" {
+				t.Errorf("got %s %q", k, res.Header.Get(k))
+			}
+		}
+	}
+	if hits != 1 {
+		t.Errorf("got %d hits, want 1", hits)
+	}
+}
+
+func TestNewMiddlewareV2(t *testing.T) {
+	hits := 0
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("Cache-Control", "no-store")
+		_, _ = w.Write([]byte("hello"))
+	})
+	store, err := memory.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(httpcache.NewMiddlewareV2(staticHandler{}, store)(h))
+	t.Cleanup(ts.Close)
+	for i := 0; i < 2; i++ {
+		res, err := http.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != "hello" {
+			t.Errorf("got body %q", string(b))
+		}
+		for _, k := range []string{httpcache.HeaderRequestTime, httpcache.HeaderResponseTime} {
+			if res.Header.Get(k) != "" {
+				t.Errorf("got %s %q", k, res.Header.Get(k))
+			}
+		}
+	}
+	if hits != 1 {
+		t.Errorf("got %d hits, want 1", hits)
+	}
+}
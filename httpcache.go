@@ -1,16 +1,52 @@
 package httpcache
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"time"
 )
 
+// Handler decides whether responses are stored and whether stored responses are used.
+// Handle may call do in the background after it returns, with a request whose context is marked by ContextWithBackground,
+// to refresh the stored response. The caller stores the response to such a request if it is storable, and returns it with an empty body.
+// The caller forwards one such request per cache key at a time, and returns an error for the others.
 type Handler interface {
 	Handle(req *http.Request, cachedReq *http.Request, cachedRes *http.Response, do func(*http.Request) (*http.Response, error), now time.Time) (cacheUsed bool, res *http.Response, err error)
 	Storable(req *http.Request, res *http.Response, now time.Time) (ok bool, expires time.Time)
 }
 
+// Invalidator is the interface implemented by a Handler that invalidates stored responses.
+// It is optional, and Transport and NewMiddleware delete the stored responses for the returned URIs.
+type Invalidator interface {
+	// Invalidate returns the URIs whose stored responses are invalidated by the response to the request.
+	Invalidate(req *http.Request, res *http.Response) []*url.URL
+}
+
+// Sanitizer is the interface implemented by a Handler that removes header fields from responses before they are stored.
+// It is optional, and Transport and NewMiddleware store the returned header fields instead of the response's.
+type Sanitizer interface {
+	// Sanitize returns the header fields of the response to be stored. It must not modify res.
+	Sanitize(req *http.Request, res *http.Response) http.Header
+}
+
+// Combiner is the interface implemented by a Handler that combines a stored partial response with a new partial response (https://www.rfc-editor.org/rfc/rfc9111#section-3.4).
+// It is optional, and Transport and NewMiddleware store the combined response instead of the new one if the stored response is not used for the request.
+type Combiner interface {
+	// Combine returns the response combining the stored response with the new response, or false if they cannot be combined.
+	// The body of the combined response reads the body of res, which is read only once, together with the body of cachedRes.
+	Combine(cachedRes *http.Response, res *http.Response) (*http.Response, bool)
+}
+
+// Freshener is the interface implemented by a Handler that freshens stored responses with responses to HEAD requests (https://www.rfc-editor.org/rfc/rfc9111#section-4.3.5).
+// It is optional, and Transport and NewMiddleware call it if the stored response is not used for the request.
+type Freshener interface {
+	// Freshen returns whether the stored response is affected by the response to the request, and the freshened response to be stored instead.
+	// If the stored response is affected, the response to the request is not stored, and the stored response is invalidated if freshened is nil.
+	Freshen(req *http.Request, res *http.Response, cachedReq *http.Request, cachedRes *http.Response) (affected bool, freshened *http.Response)
+}
+
 func HandlerToClientDo(h http.Handler) func(*http.Request) (*http.Response, error) {
 	return func(req *http.Request) (*http.Response, error) {
 		rec := httptest.NewRecorder()
@@ -20,3 +56,66 @@ func HandlerToClientDo(h http.Handler) func(*http.Request) (*http.Response, erro
 		return res, nil
 	}
 }
+
+// Header fields used to record when the stored response was requested and received (request_time and response_time in https://www.rfc-editor.org/rfc/rfc9111#section-4.2.3).
+// They are set on stored responses by Transport and NewMiddleware, and removed from the responses served by them.
+const (
+	HeaderRequestTime  = "X-Httpcache-Request-Time"
+	HeaderResponseTime = "X-Httpcache-Response-Time"
+)
+
+// HeaderCollapsed is the header field set on a response shared with a collapsed request (see CollapsedForwarding).
+// Transport removes it before the response is stored or returned, so Handler reads it in do.
+const HeaderCollapsed = "X-Httpcache-Collapsed"
+
+// HeaderVariants is the header field of the entry stored for the primary cache key of responses that vary.
+// The entry has no content, and the field lists the quoted cache keys of the stored variants, up to MaxVariants, so that they are deleted together.
+// Transport and NewMiddleware select a variant with the Vary header field of the entry, and never pass the entry to Handler.
+// They remove the field from the responses to be stored or served, so that only the entries they store for the primary key have it.
+const HeaderVariants = "X-Httpcache-Variants"
+
+// HeaderKeys is the header field of the entry listing the cache keys stored for a target URI, when the KeyFunc includes the values of request header fields or cookies in them.
+// The entry has no content, and the field lists the quoted cache keys, up to MaxKeysPerURI, so that the stored responses are invalidated together with the target URI.
+// Transport and NewMiddleware remove the field from the responses to be stored or served.
+const HeaderKeys = "X-Httpcache-Keys"
+
+type backgroundKey struct{}
+
+// ContextWithBackground returns a copy of ctx marking the request as made by Handler in the background.
+func ContextWithBackground(ctx context.Context) context.Context {
+	return context.WithValue(ctx, backgroundKey{}, true)
+}
+
+// IsBackground returns true if ctx is marked by ContextWithBackground.
+func IsBackground(ctx context.Context) bool {
+	v, _ := ctx.Value(backgroundKey{}).(bool)
+	return v
+}
+
+type cacheKeyKey struct{}
+
+// ContextWithCacheKey returns a copy of ctx carrying the cache key with which the stored response passed to Handler with the request is looked up.
+// Transport and NewMiddleware pass it to Handler, so that Handler does not need to be configured with the same KeyFunc to compare the target URIs.
+func ContextWithCacheKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, cacheKeyKey{}, key)
+}
+
+// CacheKeyFromContext returns the cache key carried by ctx, and false if ctx carries none.
+func CacheKeyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(cacheKeyKey{}).(string)
+	return key, ok
+}
+
+type varyMissKey struct{}
+
+// ContextWithVaryMiss returns a copy of ctx marking that responses are stored for the cache key of the request, but none of them matches it by the Vary header field.
+// Transport and NewMiddleware pass it to Handler with no stored response, so that Handler can report the reason why no stored response is used.
+func ContextWithVaryMiss(ctx context.Context) context.Context {
+	return context.WithValue(ctx, varyMissKey{}, true)
+}
+
+// IsVaryMiss returns true if ctx is marked by ContextWithVaryMiss.
+func IsVaryMiss(ctx context.Context) bool {
+	v, _ := ctx.Value(varyMissKey{}).(bool)
+	return v
+}
@@ -0,0 +1,133 @@
+package httpcache
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// MaxKeysPerURI is the maximum number of cache keys recorded for a target URI (see HeaderKeys).
+// When a response is stored with a new key for a target URI with MaxKeysPerURI keys, the stored response for the least recently recorded key is deleted.
+const MaxKeysPerURI = 64
+
+// keysSeparator separates the cache key of a target URI from the suffix of the key of the entry listing the cache keys stored for it.
+const keysSeparator = "\x00keys\x00"
+
+// invalidate deletes the stored responses, including all of their variants, for the URIs invalidated by the response to the request.
+// The stored responses for each URI with the values of the header fields or cookies included by IncludeHeaders or IncludeCookies
+// other than those of the request are deleted too, because their cache keys are recorded for the URI.
+func invalidate(store Storage, key KeyFunc, req *http.Request, urls []*url.URL) error {
+	for _, u := range urls {
+		r := req.WithContext(req.Context())
+		r.URL = u
+		if err := deleteEntry(store, key(r)); err != nil {
+			return err
+		}
+		uk := uriKey(key, r)
+		if uk == key(r) {
+			continue
+		}
+		if err := deleteEntry(store, uk); err != nil {
+			return err
+		}
+		if err := deleteKeys(store, uk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uriKey returns the cache key of the target URI of the request, which the KeyFunc returns for the request without header fields and cookies.
+func uriKey(key KeyFunc, req *http.Request) string {
+	r := req.WithContext(req.Context())
+	r.Header = http.Header{}
+	return key(r)
+}
+
+// recordKey adds the cache key to the entry listing the cache keys stored for the target URI of the request, if the key is not the key of the target URI.
+// The entry expires when the last of the stored responses expires, and the keys beyond MaxKeysPerURI are deleted with their stored responses.
+func recordKey(s Storage, keyFn KeyFunc, key string, req *http.Request, expires time.Time) error {
+	uk := uriKey(keyFn, req)
+	if uk == key {
+		return nil
+	}
+	lk := uk + keysSeparator
+	defer indexLocks.lock(lk)()
+	keys := []string{strconv.Quote(key)}
+	_, list, err := s.Get(lk)
+	switch {
+	case err == nil:
+		_ = list.Body.Close()
+		for _, k := range recordedKeys(list) {
+			if k == key {
+				continue
+			}
+			if len(keys) >= MaxKeysPerURI {
+				if err := deleteEntry(s, k); err != nil {
+					return err
+				}
+				continue
+			}
+			keys = append(keys, strconv.Quote(k))
+		}
+		if e, err := http.ParseTime(list.Header.Get("Expires")); err == nil && e.After(expires) {
+			expires = e
+		}
+	case !errors.Is(err, ErrCacheMiss):
+		return err
+	}
+	// The expiration time is rounded up to the resolution of HTTP-date.
+	expires = expires.Truncate(time.Second).Add(time.Second)
+	return s.Set(lk, &http.Request{
+		Method: http.MethodGet,
+		URL:    req.URL,
+		Host:   req.Host,
+		Header: http.Header{},
+	}, &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: http.Header{
+			"Expires":  []string{expires.UTC().Format(http.TimeFormat)},
+			HeaderKeys: keys,
+		},
+		Body: http.NoBody,
+	}, expires)
+}
+
+// recordedKeys returns the cache keys listed in the entry for a target URI.
+func recordedKeys(list *http.Response) []string {
+	var keys []string
+	for _, v := range list.Header.Values(HeaderKeys) {
+		k, err := strconv.Unquote(v)
+		if err != nil {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// deleteKeys deletes the stored responses for the cache keys recorded for the target URI, and the entry listing them.
+func deleteKeys(s Storage, uk string) error {
+	lk := uk + keysSeparator
+	defer indexLocks.lock(lk)()
+	_, list, err := s.Get(lk)
+	if err != nil {
+		if errors.Is(err, ErrCacheMiss) {
+			return nil
+		}
+		return err
+	}
+	_ = list.Body.Close()
+	for _, k := range recordedKeys(list) {
+		if err := deleteEntry(s, k); err != nil {
+			return err
+		}
+	}
+	return s.Delete(lk)
+}
@@ -0,0 +1,281 @@
+package httpcache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// NewMiddleware returns a middleware that caches responses of the wrapped http.Handler using Handler and Storage.
+// Responses are streamed to the client while cacheable ones are recorded, and cache hits are served without invoking the wrapped http.Handler.
+func NewMiddleware(h Handler, store Storage, opts ...Option) func(http.Handler) http.Handler {
+	return NewMiddlewareV2(AdaptHandler(h), store, opts...)
+}
+
+// NewMiddlewareV2 returns a middleware that caches responses of the wrapped http.Handler using HandlerV2 and Storage.
+// Requests that can never be served from the cache are passed to the wrapped http.Handler with the original http.ResponseWriter,
+// so that it can hijack the connection or control deadlines: requests to upgrade the protocol, CONNECT requests, and requests whose methods are not GET or HEAD.
+// The stored responses invalidated by the responses to unsafe requests are deleted if the Handler is an Invalidator.
+func NewMiddlewareV2(h HandlerV2, store Storage, opts ...Option) func(http.Handler) http.Handler {
+	c := newConfig(opts)
+	return func(next http.Handler) http.Handler {
+		refreshes := &refreshGroup{}
+		var flights *flightGroup
+		if c.collapse {
+			flights = &flightGroup{maxBodySize: c.collapseMaxBodySize}
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodConnect || r.Header.Get("Upgrade") != "":
+				next.ServeHTTP(w, r)
+				return
+			case r.Method != http.MethodGet && r.Method != http.MethodHead:
+				passThrough(h, store, c.key, next, w, r)
+				return
+			}
+			key := c.key(r)
+			cachedReq, cachedRes, varyMiss, err := lookup(store, key, r)
+			if err != nil {
+				// Bypass the cache when the storage is not available.
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var (
+				rws              []*pipeResponseWriter
+				reqTime, resTime time.Time
+				// flushed is set when the wrapped http.Handler flushes the response.
+				flushed = &atomic.Bool{}
+			)
+			do := func(ctx context.Context, req *http.Request) (*http.Response, error) {
+				req = req.WithContext(ctx)
+				if IsBackground(ctx) {
+					return refresh(h, store, refreshes, c.key, key, req, func(req *http.Request) (*http.Response, error) {
+						return forward(flights, c.collapseTimeout, h, key, req, func(req *http.Request) (*http.Response, error) {
+							// The response is streamed into the storage as the foreground one is.
+							// It is not written to a client, and a panic of the wrapped http.Handler only means that the response is not stored.
+							rw := newPipeResponseWriter(req, &atomic.Bool{})
+							go rw.serve(next)
+							<-rw.ready
+							return rw.res, nil
+						})
+					})
+				}
+				reqTime = time.Now()
+				res, err := forward(flights, c.collapseTimeout, h, key, req, func(req *http.Request) (*http.Response, error) {
+					rw := newPipeResponseWriter(req, flushed)
+					rws = append(rws, rw)
+					go rw.serve(next)
+					<-rw.ready
+					return rw.res, nil
+				})
+				resTime = time.Now()
+				return res, err
+			}
+			defer func() {
+				for _, rw := range rws {
+					_ = rw.res.Body.Close()
+					<-rw.done
+					if rw.panicked != nil {
+						panic(rw.panicked)
+					}
+				}
+			}()
+
+			// HandlerV2 may set conditional headers on the request.
+			ctx := ContextWithCacheKey(r.Context(), key)
+			if varyMiss {
+				ctx = ContextWithVaryMiss(ctx)
+			}
+			d, err := h.Handle(ctx, r.Clone(ctx), cachedReq, cachedRes, do, time.Now())
+			if err != nil {
+				closeBody(cachedRes)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			res := d.Response
+			defer res.Body.Close()
+			delInternalHeader(res.Header)
+			// The response is streamed to the client while it is stored. Incomplete responses are not stored.
+			res = applyDecision(h, store, c.key, key, r.WithContext(ctx), d, cachedReq, cachedRes, reqTime, resTime)
+			defer res.Body.Close()
+
+			for k, v := range res.Header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(res.StatusCode)
+			copyBody(w, res.Body, flushed)
+		})
+	}
+}
+
+// passThrough serves the request that cannot be served from the cache with the wrapped http.Handler,
+// and deletes the stored responses invalidated by the response if the request method is unsafe (https://www.rfc-editor.org/rfc/rfc9111#section-4.4).
+func passThrough(h HandlerV2, s Storage, keyFn KeyFunc, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	i, ok := unwrap(h).(Invalidator)
+	if !ok || isSafe(r.Method) {
+		next.ServeHTTP(w, r)
+		return
+	}
+	sw := &statusResponseWriter{ResponseWriter: w}
+	next.ServeHTTP(sw, r)
+	if sw.code == 0 {
+		sw.code = http.StatusOK
+	}
+	_ = invalidate(s, keyFn, r, i.Invalidate(r, &http.Response{
+		Status:     strconv.Itoa(sw.code) + " " + http.StatusText(sw.code),
+		StatusCode: sw.code,
+		Header:     w.Header(),
+		Body:       http.NoBody,
+		Request:    r,
+	}))
+}
+
+// isSafe returns true if the request method is defined as safe (https://www.rfc-editor.org/rfc/rfc9110#section-9.2.1).
+func isSafe(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
+
+// statusResponseWriter is an http.ResponseWriter that records the status code of the response.
+// It implements Unwrap, so that http.ResponseController reaches the features of the original http.ResponseWriter.
+type statusResponseWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (w *statusResponseWriter) WriteHeader(code int) {
+	// Informational responses are followed by the final response.
+	if w.code == 0 && (code < 100 || code >= 200 || code == http.StatusSwitchingProtocols) {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write implements http.ResponseWriter.
+func (w *statusResponseWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the original http.ResponseWriter for http.ResponseController.
+func (w *statusResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// copyBody writes the body to w, and flushes w after writing the data that the wrapped http.Handler has flushed.
+func copyBody(w http.ResponseWriter, body io.Reader, flushed *atomic.Bool) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+		if flushed.Swap(false) {
+			_ = http.NewResponseController(w).Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// pipeResponseWriter is an http.ResponseWriter that converts the response written by an http.Handler into an *http.Response whose body is streamed through a pipe.
+type pipeResponseWriter struct {
+	req      *http.Request
+	header   http.Header
+	pw       *io.PipeWriter
+	res      *http.Response
+	once     sync.Once
+	ready    chan struct{}
+	done     chan struct{}
+	flushed  *atomic.Bool
+	panicked any
+}
+
+func newPipeResponseWriter(req *http.Request, flushed *atomic.Bool) *pipeResponseWriter {
+	pr, pw := io.Pipe()
+	return &pipeResponseWriter{
+		req:     req,
+		header:  http.Header{},
+		pw:      pw,
+		flushed: flushed,
+		res: &http.Response{
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Body:       pr,
+			Request:    req,
+		},
+		ready: make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+// serve runs the http.Handler and closes the pipe when it returns.
+func (w *pipeResponseWriter) serve(h http.Handler) {
+	defer close(w.done)
+	defer func() {
+		if p := recover(); p != nil {
+			// The panic is re-raised in the goroutine serving the original request.
+			w.panicked = p
+			w.WriteHeader(http.StatusInternalServerError)
+			_ = w.pw.CloseWithError(http.ErrAbortHandler)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_ = w.pw.Close()
+	}()
+	h.ServeHTTP(w, w.req)
+}
+
+// Header implements http.ResponseWriter.
+func (w *pipeResponseWriter) Header() http.Header {
+	return w.header
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (w *pipeResponseWriter) WriteHeader(code int) {
+	// Informational responses are not forwarded.
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		return
+	}
+	w.once.Do(func() {
+		w.res.StatusCode = code
+		w.res.Status = strconv.Itoa(code) + " " + http.StatusText(code)
+		w.res.Header = w.header.Clone()
+		w.res.ContentLength = -1
+		if cl, err := strconv.ParseInt(w.header.Get("Content-Length"), 10, 64); err == nil {
+			w.res.ContentLength = cl
+		}
+		close(w.ready)
+	})
+}
+
+// Write implements http.ResponseWriter.
+func (w *pipeResponseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.pw.Write(b)
+}
+
+// Flush implements http.Flusher.
+// Written data is passed through the pipe as soon as it is read, and the middleware flushes the outer http.ResponseWriter after writing it.
+// The empty write wakes the reader, so that the data already written is flushed without waiting for more.
+func (w *pipeResponseWriter) Flush() {
+	w.WriteHeader(http.StatusOK)
+	w.flushed.Store(true)
+	_, _ = w.pw.Write(nil)
+}
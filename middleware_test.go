@@ -0,0 +1,262 @@
+package httpcache_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/k1LoW/httpcache"
+	"github.com/k1LoW/httpcache/rfc9111"
+	"github.com/k1LoW/httpcache/storage/memory"
+)
+
+func TestNewMiddleware(t *testing.T) {
+	tests := []struct {
+		name         string
+		cacheControl string
+		wantHits     int
+	}{
+		{"Cache-Control: max-age=60 -> cached", "max-age=60", 1},
+		{"Cache-Control: no-store -> not cached", "no-store", 3},
+		{"Cache-Control: private -> not cached", "private", 3},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var mu sync.Mutex
+			hits := 0
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				hits++
+				mu.Unlock()
+				w.Header().Set("Cache-Control", tt.cacheControl)
+				w.WriteHeader(http.StatusCreated)
+				_, _ = w.Write([]byte("hel"))
+				_, _ = w.Write([]byte("lo"))
+			})
+			s, err := rfc9111.NewShared()
+			if err != nil {
+				t.Fatal(err)
+			}
+			store, err := memory.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ts := httptest.NewServer(httpcache.NewMiddleware(s, store)(h))
+			t.Cleanup(ts.Close)
+
+			for i := 0; i < 3; i++ {
+				res, err := http.Get(ts.URL)
+				if err != nil {
+					t.Fatal(err)
+				}
+				b, err := io.ReadAll(res.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := res.Body.Close(); err != nil {
+					t.Fatal(err)
+				}
+				if res.StatusCode != http.StatusCreated {
+					t.Errorf("got status %d, want %d", res.StatusCode, http.StatusCreated)
+				}
+				if got := res.Header.Get("Cache-Control"); got != tt.cacheControl {
+					t.Errorf("got Cache-Control %q, want %q", got, tt.cacheControl)
+				}
+				if string(b) != "hello" {
+					t.Errorf("got body %q, want %q", string(b), "hello")
+				}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if hits != tt.wantHits {
+				t.Errorf("got %d hits, want %d", hits, tt.wantHits)
+			}
+		})
+	}
+}
+
+func TestNewMiddleware_Flush(t *testing.T) {
+	read := make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte("hel"))
+		http.NewResponseController(w).Flush()
+		// The rest is written after the client reads the flushed data.
+		select {
+		case <-read:
+		case <-time.After(5 * time.Second):
+		}
+		_, _ = w.Write([]byte("lo"))
+	})
+	s, err := rfc9111.NewShared()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := memory.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(httpcache.NewMiddleware(s, store)(h))
+	t.Cleanup(ts.Close)
+
+	start := time.Now()
+	res, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b := make([]byte, 3)
+	if _, err := io.ReadFull(res.Body, b); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("the flushed data is not sent before the handler returns")
+	}
+	close(read)
+	rest, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(b) + string(rest); got != "hello" {
+		t.Errorf("got body %q, want %q", got, "hello")
+	}
+}
+
+func TestNewMiddleware_StaleWhileRevalidate(t *testing.T) {
+	var once sync.Once
+	refreshed := make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer once.Do(func() { close(refreshed) })
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte("ne"))
+		panic("refresh")
+	})
+	s, err := rfc9111.NewShared()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := memory.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(httpcache.NewMiddleware(s, store)(h))
+	t.Cleanup(ts.Close)
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := time.Now().Add(-time.Minute)
+	if err := store.Set(httpcache.DefaultKey(req), req, &http.Response{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Cache-Control": []string{"max-age=10, stale-while-revalidate=600"},
+			"Date":          []string{stale.Format(http.TimeFormat)},
+		},
+		Body: io.NopCloser(strings.NewReader("old")),
+	}, stale.Add(10*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func() string {
+		t.Helper()
+		res, err := http.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	if got := get(); got != "old" {
+		t.Errorf("got body %q, want %q", got, "old")
+	}
+	// The wrapped http.Handler panics in the background refresh, so the incomplete response is not stored.
+	select {
+	case <-refreshed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stale response is not refreshed")
+	}
+	if got := get(); got != "old" {
+		t.Errorf("got body %q, want %q", got, "old")
+	}
+}
+
+func TestNewMiddleware_PassThrough(t *testing.T) {
+	var mu sync.Mutex
+	hits := 0
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		// Requests that can never be served from the cache get the original http.ResponseWriter.
+		if r.Method != http.MethodGet || r.Header.Get("Upgrade") != "" {
+			if err := rc.SetWriteDeadline(time.Now().Add(time.Minute)); err != nil {
+				t.Errorf("%s: SetWriteDeadline() error = %v", r.Method, err)
+			}
+		}
+		if r.Header.Get("Upgrade") != "" {
+			conn, _, err := rc.Hijack()
+			if err != nil {
+				t.Errorf("Hijack() error = %v", err)
+				return
+			}
+			_, _ = conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n"))
+			_ = conn.Close()
+			return
+		}
+		if r.Method == http.MethodGet {
+			mu.Lock()
+			hits++
+			mu.Unlock()
+			w.Header().Set("Cache-Control", "max-age=60")
+		}
+		_, _ = w.Write([]byte("hello"))
+	})
+	s, err := rfc9111.NewShared()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := memory.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(httpcache.NewMiddleware(s, store)(h))
+	t.Cleanup(ts.Close)
+	do := func(method string, header http.Header) int {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header = header
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(io.Discard, res.Body)
+		_ = res.Body.Close()
+		return res.StatusCode
+	}
+
+	if got := do(http.MethodGet, http.Header{"Connection": []string{"Upgrade"}, "Upgrade": []string{"test"}}); got != http.StatusSwitchingProtocols {
+		t.Errorf("got status %d, want %d", got, http.StatusSwitchingProtocols)
+	}
+	do(http.MethodGet, http.Header{})
+	do(http.MethodGet, http.Header{})
+	// The response to the unsafe request invalidates the stored response.
+	do(http.MethodPost, http.Header{})
+	do(http.MethodGet, http.Header{})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if hits != 2 {
+		t.Errorf("got %d hits, want %d", hits, 2)
+	}
+}
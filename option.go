@@ -0,0 +1,30 @@
+package httpcache
+
+import "time"
+
+// Option is an option for NewTransport and NewMiddleware.
+type Option func(*config)
+
+type config struct {
+	key             KeyFunc
+	collapse        bool
+	collapseTimeout time.Duration
+	// collapseMaxBodySize is the maximum size of a response body shared with collapsed requests.
+	collapseMaxBodySize int64
+}
+
+// CacheKey sets the KeyFunc that returns the cache key of a request. The default is DefaultKey.
+// The cache key is passed to Handler with ContextWithCacheKey.
+func CacheKey(f KeyFunc) Option {
+	return func(c *config) {
+		c.key = f
+	}
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{key: DefaultKey, collapseMaxBodySize: defaultCollapsedMaxBodySize}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
@@ -0,0 +1,61 @@
+package httpcache
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// errRefreshing is returned to a background request while the stored response for the same key is being refreshed.
+var errRefreshing = errors.New("stored response is being refreshed")
+
+// refreshGroup tracks the keys whose stored responses are being refreshed in the background,
+// so that a stale response served to many requests is refreshed by one request at a time.
+type refreshGroup struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+// start returns true if no stored response for the key is being refreshed, and marks it as being refreshed.
+func (g *refreshGroup) start(key string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.keys[key]; ok {
+		return false
+	}
+	if g.keys == nil {
+		g.keys = map[string]struct{}{}
+	}
+	g.keys[key] = struct{}{}
+	return true
+}
+
+// done marks the stored response for the key as no longer being refreshed.
+func (g *refreshGroup) done(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.keys, key)
+}
+
+// refresh fetches the response to the request made by HandlerV2 in the background, and stores it if it is storable.
+// The request is not fetched while the stored response for the same key is being refreshed.
+func refresh(h HandlerV2, s Storage, g *refreshGroup, keyFn KeyFunc, key string, req *http.Request, fetch func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	if !g.start(key) {
+		return nil, errRefreshing
+	}
+	defer g.done(key)
+	reqTime := time.Now()
+	res, err := fetch(req)
+	if err != nil {
+		return nil, err
+	}
+	res.Header.Del(HeaderCollapsed)
+	resTime := time.Now()
+	ok, expires := h.Storable(req.Context(), req, res, resTime)
+	if !ok {
+		return drain(res)
+	}
+	storeResponse(h, s, keyFn, key, req, res, nil, expires, reqTime, resTime)
+	return drain(res)
+}
@@ -0,0 +1,134 @@
+package httpcache_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/k1LoW/httpcache"
+	"github.com/k1LoW/httpcache/rfc9111"
+	"github.com/k1LoW/httpcache/storage/memory"
+)
+
+func TestRefreshRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		header http.Header
+	}{
+		{"HEAD", http.MethodHead, http.Header{}},
+		{"Range", http.MethodGet, http.Header{"Range": []string{"bytes=0-1"}}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		for _, middleware := range []bool{false, true} {
+			middleware := middleware
+			name := tt.name + " Transport"
+			if middleware {
+				name = tt.name + " middleware"
+			}
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				var (
+					mu   sync.Mutex
+					hits []string
+				)
+				h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					mu.Lock()
+					hits = append(hits, r.Method+" "+r.Header.Get("Range"))
+					mu.Unlock()
+					w.Header().Set("Cache-Control", "max-age=60")
+					_, _ = w.Write([]byte("0123456789"))
+				})
+				s, err := rfc9111.NewShared()
+				if err != nil {
+					t.Fatal(err)
+				}
+				store, err := memory.New()
+				if err != nil {
+					t.Fatal(err)
+				}
+				var (
+					ts     *httptest.Server
+					client *http.Client
+				)
+				if middleware {
+					ts = httptest.NewServer(httpcache.NewMiddleware(s, store)(h))
+					client = http.DefaultClient
+				} else {
+					ts = httptest.NewServer(h)
+					client = &http.Client{Transport: httpcache.NewTransport(s, store, nil)}
+				}
+				t.Cleanup(ts.Close)
+				req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				key := httpcache.DefaultKey(req)
+				stale := time.Now().Add(-time.Minute)
+				if err := store.Set(key, req, &http.Response{
+					StatusCode: http.StatusOK,
+					Header: http.Header{
+						"Cache-Control":  []string{"max-age=10, stale-while-revalidate=600"},
+						"Content-Length": []string{"10"},
+						"Date":           []string{stale.Format(http.TimeFormat)},
+					},
+					Body: io.NopCloser(strings.NewReader("abcdefghij")),
+				}, stale.Add(10*time.Second)); err != nil {
+					t.Fatal(err)
+				}
+				do := func(method string, header http.Header) {
+					t.Helper()
+					req, err := http.NewRequest(method, ts.URL, nil)
+					if err != nil {
+						t.Fatal(err)
+					}
+					req.Header = header
+					res, err := client.Do(req)
+					if err != nil {
+						t.Fatal(err)
+					}
+					_, _ = io.Copy(io.Discard, res.Body)
+					_ = res.Body.Close()
+				}
+
+				// The stale response is served, and refreshed by an unconditional GET request for the complete response.
+				do(tt.method, tt.header)
+				var (
+					cachedReq *http.Request
+					cachedRes *http.Response
+				)
+				for i := 0; i < 100; i++ {
+					cachedReq, cachedRes, err = store.Get(key)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if cachedRes.Header.Get("Cache-Control") == "max-age=60" {
+						break
+					}
+					_ = cachedRes.Body.Close()
+					time.Sleep(10 * time.Millisecond)
+				}
+				b, err := io.ReadAll(cachedRes.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_ = cachedRes.Body.Close()
+				if cachedReq.Method != http.MethodGet || cachedRes.StatusCode != http.StatusOK || string(b) != "0123456789" {
+					t.Errorf("got stored %s request and %d response with body %q, want the complete response to GET", cachedReq.Method, cachedRes.StatusCode, string(b))
+				}
+				do(http.MethodGet, http.Header{})
+
+				mu.Lock()
+				defer mu.Unlock()
+				if len(hits) != 1 || hits[0] != "GET " {
+					t.Errorf("got requests %q, want one GET request without Range", hits)
+				}
+			})
+		}
+	}
+}
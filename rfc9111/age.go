@@ -0,0 +1,101 @@
+package rfc9111
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/k1LoW/httpcache"
+)
+
+// maxAgeValue is the maximum value of the Age header field (https://www.rfc-editor.org/rfc/rfc9111#section-1.2.2).
+const maxAgeValue = math.MaxInt32 + 1
+
+// CurrentAge calculates the current age of a response (https://www.rfc-editor.org/rfc/rfc9111#section-4.2.3).
+// requestTime and responseTime are the times when the cache made the request and received the response.
+func CurrentAge(header http.Header, requestTime, responseTime, now time.Time) time.Duration {
+	// age_value: The term "age_value" denotes the value of the Age header field (Section 5.1), in a form appropriate for arithmetic operation; or 0, if not available.
+	ageValue := time.Duration(0)
+	if v := header.Get("Age"); v != "" {
+		// If the field value is invalid, the cache SHOULD ignore the field (https://www.rfc-editor.org/rfc/rfc9111#section-5.1).
+		// A value that overflows is not ignored, but is treated as 2147483648 (2^31) (https://www.rfc-editor.org/rfc/rfc9111#section-1.2.2).
+		u64, err := strconv.ParseUint(v, 10, 63)
+		if errors.Is(err, strconv.ErrRange) {
+			u64, err = maxAgeValue, nil
+		}
+		if err == nil {
+			if u64 > maxAgeValue {
+				u64 = maxAgeValue
+			}
+			ageValue = time.Duration(u64) * time.Second
+		}
+	}
+	// date_value: The term "date_value" denotes the value of the Date header field, in a form appropriate for arithmetic operations.
+	dateValue := dateValue(header, responseTime)
+
+	// apparent_age = max(0, response_time - date_value);
+	apparentAge := max(0, responseTime.Sub(dateValue))
+	// response_delay = response_time - request_time;
+	responseDelay := responseTime.Sub(requestTime)
+	// corrected_age_value = age_value + response_delay;
+	correctedAgeValue := ageValue + responseDelay
+	// corrected_initial_age = max(apparent_age, corrected_age_value);
+	correctedInitialAge := max(apparentAge, correctedAgeValue)
+	// resident_time = now - response_time;
+	residentTime := now.Sub(responseTime)
+	// current_age = corrected_initial_age + resident_time;
+	return correctedInitialAge + residentTime
+}
+
+// StoredTimes returns the request_time and response_time recorded in the stored response.
+// If they are not recorded, the value of the Date header field (or now if it is not available) is used for both.
+func StoredTimes(header http.Header, now time.Time) (requestTime, responseTime time.Time) {
+	responseTime = dateValue(header, now)
+	if v := header.Get(httpcache.HeaderResponseTime); v != "" {
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			responseTime = t
+		}
+	}
+	requestTime = responseTime
+	if v := header.Get(httpcache.HeaderRequestTime); v != "" {
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			requestTime = t
+		}
+	}
+	return requestTime, responseTime
+}
+
+// dateValue returns the value of the Date header field.
+// A recipient with a clock that receives a response message without a Date header field MUST record the time it was received (https://www.rfc-editor.org/rfc/rfc9110#section-6.6.1).
+func dateValue(header http.Header, responseTime time.Time) time.Time {
+	if header.Get("Date") != "" {
+		dt, err := http.ParseTime(header.Get("Date"))
+		if err == nil {
+			return dt
+		}
+	}
+	return responseTime
+}
+
+// ageHeaderValue returns the value of the Age header field for the current age.
+func ageHeaderValue(age time.Duration) string {
+	sec := int64(max(0, age) / time.Second)
+	if sec > maxAgeValue {
+		sec = maxAgeValue
+	}
+	return strconv.FormatInt(sec, 10)
+}
+
+// cachedResponse returns a copy of the stored response to be served from the cache.
+// The Age header field is set to the current age, and the internal header fields recording request_time and response_time are removed.
+func cachedResponse(res *http.Response, age time.Duration) *http.Response {
+	// When a cache generates a response from a stored one, the Age header field conveys the current age (https://www.rfc-editor.org/rfc/rfc9111#section-5.1).
+	r := *res
+	r.Header = res.Header.Clone()
+	r.Header.Del(httpcache.HeaderRequestTime)
+	r.Header.Del(httpcache.HeaderResponseTime)
+	r.Header.Set("Age", ageHeaderValue(age))
+	return &r
+}
@@ -0,0 +1,129 @@
+package rfc9111
+
+import (
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/k1LoW/httpcache"
+)
+
+func TestCurrentAge(t *testing.T) {
+	now := time.Date(2024, 12, 13, 14, 15, 16, 00, time.UTC)
+
+	tests := []struct {
+		name         string
+		header       http.Header
+		requestTime  time.Time
+		responseTime time.Time
+		want         time.Duration
+	}{
+		{
+			"resident_time only",
+			http.Header{},
+			now.Add(-10 * time.Second),
+			now.Add(-10 * time.Second),
+			10 * time.Second,
+		},
+		{
+			"apparent_age",
+			http.Header{
+				"Date": []string{now.Add(-15 * time.Second).Format(http.TimeFormat)},
+			},
+			now.Add(-10 * time.Second),
+			now.Add(-10 * time.Second),
+			15 * time.Second,
+		},
+		{
+			"corrected_age_value",
+			http.Header{
+				"Date": []string{now.Add(-15 * time.Second).Format(http.TimeFormat)},
+				"Age":  []string{"30"},
+			},
+			now.Add(-12 * time.Second),
+			now.Add(-10 * time.Second),
+			42 * time.Second,
+		},
+		{
+			"invalid Age",
+			http.Header{
+				"Age": []string{"-30"},
+			},
+			now.Add(-10 * time.Second),
+			now.Add(-10 * time.Second),
+			10 * time.Second,
+		},
+		{
+			"overflowing Age",
+			http.Header{
+				"Age": []string{"99999999999999999999"},
+			},
+			now.Add(-10 * time.Second),
+			now.Add(-10 * time.Second),
+			(math.MaxInt32+1)*time.Second + 10*time.Second,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got := CurrentAge(tt.header, tt.requestTime, tt.responseTime, now)
+			if got != tt.want {
+				t.Errorf("CurrentAge() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStoredTimes(t *testing.T) {
+	now := time.Date(2024, 12, 13, 14, 15, 16, 00, time.UTC)
+	date := now.Add(-time.Minute)
+	requestTime := now.Add(-30 * time.Second)
+	responseTime := now.Add(-29 * time.Second)
+
+	tests := []struct {
+		name             string
+		header           http.Header
+		wantRequestTime  time.Time
+		wantResponseTime time.Time
+	}{
+		{
+			"recorded",
+			http.Header{
+				"Date":                       []string{date.Format(http.TimeFormat)},
+				httpcache.HeaderRequestTime:  []string{requestTime.Format(time.RFC3339Nano)},
+				httpcache.HeaderResponseTime: []string{responseTime.Format(time.RFC3339Nano)},
+			},
+			requestTime,
+			responseTime,
+		},
+		{
+			"Date",
+			http.Header{
+				"Date": []string{date.Format(http.TimeFormat)},
+			},
+			date,
+			date,
+		},
+		{
+			"now",
+			http.Header{},
+			now,
+			now,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			gotRequestTime, gotResponseTime := StoredTimes(tt.header, now)
+			if !gotRequestTime.Equal(tt.wantRequestTime) {
+				t.Errorf("StoredTimes() gotRequestTime = %v, want %v", gotRequestTime, tt.wantRequestTime)
+			}
+			if !gotResponseTime.Equal(tt.wantResponseTime) {
+				t.Errorf("StoredTimes() gotResponseTime = %v, want %v", gotResponseTime, tt.wantResponseTime)
+			}
+		})
+	}
+}
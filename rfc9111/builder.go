@@ -0,0 +1,224 @@
+package rfc9111
+
+import (
+	"math"
+	"net/http"
+	"strings"
+)
+
+// RequestDirectivesBuilder builds RequestDirectives.
+type RequestDirectivesBuilder struct {
+	d RequestDirectives
+}
+
+// NewRequestDirectivesBuilder returns a new RequestDirectivesBuilder.
+func NewRequestDirectivesBuilder() *RequestDirectivesBuilder {
+	return &RequestDirectivesBuilder{}
+}
+
+// MaxAge sets the max-age request directive.
+func (b *RequestDirectivesBuilder) MaxAge(sec uint32) *RequestDirectivesBuilder {
+	b.d.MaxAge = &sec
+	return b
+}
+
+// MaxStale sets the max-stale request directive.
+func (b *RequestDirectivesBuilder) MaxStale(sec uint32) *RequestDirectivesBuilder {
+	b.d.MaxStale = &sec
+	return b
+}
+
+// AnyStale sets the max-stale request directive without a value, which accepts a stale response of any age.
+func (b *RequestDirectivesBuilder) AnyStale() *RequestDirectivesBuilder {
+	return b.MaxStale(math.MaxUint32)
+}
+
+// MinFresh sets the min-fresh request directive.
+func (b *RequestDirectivesBuilder) MinFresh(sec uint32) *RequestDirectivesBuilder {
+	b.d.MinFresh = &sec
+	return b
+}
+
+// NoCache sets the no-cache request directive.
+func (b *RequestDirectivesBuilder) NoCache() *RequestDirectivesBuilder {
+	b.d.NoCache = true
+	return b
+}
+
+// NoStore sets the no-store request directive.
+func (b *RequestDirectivesBuilder) NoStore() *RequestDirectivesBuilder {
+	b.d.NoStore = true
+	return b
+}
+
+// NoTransform sets the no-transform request directive.
+func (b *RequestDirectivesBuilder) NoTransform() *RequestDirectivesBuilder {
+	b.d.NoTransform = true
+	return b
+}
+
+// OnlyIfCached sets the only-if-cached request directive.
+func (b *RequestDirectivesBuilder) OnlyIfCached() *RequestDirectivesBuilder {
+	b.d.OnlyIfCached = true
+	return b
+}
+
+// StaleIfError sets the stale-if-error request directive.
+func (b *RequestDirectivesBuilder) StaleIfError(sec uint32) *RequestDirectivesBuilder {
+	b.d.StaleIfError = &sec
+	return b
+}
+
+// Extension adds a cache extension directive. If value is empty, the directive has no argument.
+// The directive is not added if name is not a token (https://www.rfc-editor.org/rfc/rfc9111#section-5.2).
+func (b *RequestDirectivesBuilder) Extension(name, value string) *RequestDirectivesBuilder {
+	if !isToken(name) {
+		return b
+	}
+	b.d.Extensions = append(b.d.Extensions, Extension{Name: strings.ToLower(name), Value: value, HasValue: value != ""})
+	return b
+}
+
+// Build returns a copy of the built RequestDirectives.
+func (b *RequestDirectivesBuilder) Build() *RequestDirectives {
+	d := b.d
+	d.Extensions = append([]Extension(nil), b.d.Extensions...)
+	return &d
+}
+
+// String returns the built directives as a Cache-Control header field value.
+func (b *RequestDirectivesBuilder) String() string {
+	return b.d.String()
+}
+
+// Header returns the header fields containing the Cache-Control header field for the built directives.
+func (b *RequestDirectivesBuilder) Header() http.Header {
+	return b.d.Header()
+}
+
+// ResponseDirectivesBuilder builds ResponseDirectives.
+type ResponseDirectivesBuilder struct {
+	d ResponseDirectives
+}
+
+// NewResponseDirectivesBuilder returns a new ResponseDirectivesBuilder.
+func NewResponseDirectivesBuilder() *ResponseDirectivesBuilder {
+	return &ResponseDirectivesBuilder{}
+}
+
+// MaxAge sets the max-age response directive.
+func (b *ResponseDirectivesBuilder) MaxAge(sec uint32) *ResponseDirectivesBuilder {
+	b.d.MaxAge = &sec
+	return b
+}
+
+// MustRevalidate sets the must-revalidate response directive.
+func (b *ResponseDirectivesBuilder) MustRevalidate() *ResponseDirectivesBuilder {
+	b.d.MustRevalidate = true
+	return b
+}
+
+// MustUnderstand sets the must-understand response directive.
+func (b *ResponseDirectivesBuilder) MustUnderstand() *ResponseDirectivesBuilder {
+	b.d.MustUnderstand = true
+	return b
+}
+
+// NoCache sets the no-cache response directive. If fields are given, the qualified form is used.
+func (b *ResponseDirectivesBuilder) NoCache(fields ...string) *ResponseDirectivesBuilder {
+	if len(fields) == 0 {
+		b.d.NoCache = true
+		return b
+	}
+	b.d.NoCacheFields = append(b.d.NoCacheFields, canonicalHeaderKeys(fields)...)
+	return b
+}
+
+// NoStore sets the no-store response directive.
+func (b *ResponseDirectivesBuilder) NoStore() *ResponseDirectivesBuilder {
+	b.d.NoStore = true
+	return b
+}
+
+// NoTransform sets the no-transform response directive.
+func (b *ResponseDirectivesBuilder) NoTransform() *ResponseDirectivesBuilder {
+	b.d.NoTransform = true
+	return b
+}
+
+// Private sets the private response directive. If fields are given, the qualified form is used.
+func (b *ResponseDirectivesBuilder) Private(fields ...string) *ResponseDirectivesBuilder {
+	if len(fields) == 0 {
+		b.d.Private = true
+		return b
+	}
+	b.d.PrivateFields = append(b.d.PrivateFields, canonicalHeaderKeys(fields)...)
+	return b
+}
+
+// ProxyRevalidate sets the proxy-revalidate response directive.
+func (b *ResponseDirectivesBuilder) ProxyRevalidate() *ResponseDirectivesBuilder {
+	b.d.ProxyRevalidate = true
+	return b
+}
+
+// Public sets the public response directive.
+func (b *ResponseDirectivesBuilder) Public() *ResponseDirectivesBuilder {
+	b.d.Public = true
+	return b
+}
+
+// SMaxAge sets the s-maxage response directive.
+func (b *ResponseDirectivesBuilder) SMaxAge(sec uint32) *ResponseDirectivesBuilder {
+	b.d.SMaxAge = &sec
+	return b
+}
+
+// StaleWhileRevalidate sets the stale-while-revalidate response directive.
+func (b *ResponseDirectivesBuilder) StaleWhileRevalidate(sec uint32) *ResponseDirectivesBuilder {
+	b.d.StaleWhileRevalidate = &sec
+	return b
+}
+
+// StaleIfError sets the stale-if-error response directive.
+func (b *ResponseDirectivesBuilder) StaleIfError(sec uint32) *ResponseDirectivesBuilder {
+	b.d.StaleIfError = &sec
+	return b
+}
+
+// Extension adds a cache extension directive. If value is empty, the directive has no argument.
+// The directive is not added if name is not a token (https://www.rfc-editor.org/rfc/rfc9111#section-5.2).
+func (b *ResponseDirectivesBuilder) Extension(name, value string) *ResponseDirectivesBuilder {
+	if !isToken(name) {
+		return b
+	}
+	b.d.Extensions = append(b.d.Extensions, Extension{Name: strings.ToLower(name), Value: value, HasValue: value != ""})
+	return b
+}
+
+// Build returns a copy of the built ResponseDirectives.
+func (b *ResponseDirectivesBuilder) Build() *ResponseDirectives {
+	d := b.d
+	d.NoCacheFields = append([]string(nil), b.d.NoCacheFields...)
+	d.PrivateFields = append([]string(nil), b.d.PrivateFields...)
+	d.Extensions = append([]Extension(nil), b.d.Extensions...)
+	return &d
+}
+
+// String returns the built directives as a Cache-Control header field value.
+func (b *ResponseDirectivesBuilder) String() string {
+	return b.d.String()
+}
+
+// Header returns the header fields containing the Cache-Control header field for the built directives.
+func (b *ResponseDirectivesBuilder) Header() http.Header {
+	return b.d.Header()
+}
+
+func canonicalHeaderKeys(fields []string) []string {
+	keys := make([]string, len(fields))
+	for i, f := range fields {
+		keys[i] = http.CanonicalHeaderKey(f)
+	}
+	return keys
+}
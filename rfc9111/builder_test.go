@@ -0,0 +1,60 @@
+package rfc9111
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestResponseDirectivesBuilder(t *testing.T) {
+	b := NewResponseDirectivesBuilder().
+		Public().
+		MaxAge(60).
+		SMaxAge(600).
+		StaleWhileRevalidate(30).
+		NoCache("set-cookie").
+		Extension("Immutable", "")
+	want := `max-age=60, no-cache="Set-Cookie", public, s-maxage=600, stale-while-revalidate=30, immutable`
+	if got := b.String(); got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if diff := cmp.Diff(http.Header{"Cache-Control": []string{want}}, b.Header()); diff != "" {
+		t.Error(diff)
+	}
+	got := b.Build()
+	if diff := cmp.Diff(ParseResponseCacheControlHeader([]string{want}), got); diff != "" {
+		t.Error(diff)
+	}
+	// Build returns a copy.
+	b.NoCache("authorization")
+	if diff := cmp.Diff([]string{"Set-Cookie"}, got.NoCacheFields); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestRequestDirectivesBuilder(t *testing.T) {
+	tests := []struct {
+		name string
+		b    *RequestDirectivesBuilder
+		want string
+	}{
+		{"empty", NewRequestDirectivesBuilder(), ""},
+		{"max-age=0", NewRequestDirectivesBuilder().MaxAge(0), "max-age=0"},
+		{"any stale", NewRequestDirectivesBuilder().AnyStale().OnlyIfCached(), "max-stale, only-if-cached"},
+		{"invalid extension name", NewRequestDirectivesBuilder().Extension("x foo", "1").Extension("", "").Extension("x-bar", ""), "x-bar"},
+		{"all", NewRequestDirectivesBuilder().MaxStale(10).MinFresh(5).NoCache().NoStore().NoTransform().StaleIfError(60).Extension("x-foo", "a b"), `max-stale=10, min-fresh=5, no-cache, no-store, no-transform, stale-if-error=60, x-foo="a b"`},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := tt.b.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+			if diff := cmp.Diff(ParseRequestCacheControlHeader(tt.b.Header().Values("Cache-Control")), tt.b.Build()); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
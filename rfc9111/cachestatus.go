@@ -0,0 +1,159 @@
+package rfc9111
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/k1LoW/httpcache"
+)
+
+// The values of the fwd parameter of the Cache-Status header field (https://www.rfc-editor.org/rfc/rfc9211#section-2.2).
+const (
+	// The cache was configured to not handle this request.
+	fwdBypass = "bypass"
+	// The request method's semantics require the request to be forwarded.
+	fwdMethod = "method"
+	// The cache did not contain any responses that matched the request URI.
+	fwdURIMiss = "uri-miss"
+	// The cache contained a response that matched the request URI, but it could not select a response based upon this request's header fields and stored Vary header fields.
+	fwdVaryMiss = "vary-miss"
+	// The cache had a partial response, but it wasn't sufficient.
+	fwdPartial = "partial"
+	// The cache was able to select a response for the request, but it was stale.
+	fwdStale = "stale"
+	// The cache was able to select a fresh response for the request, but client request headers (e.g., Cache-Control request directives) did not allow its use.
+	fwdRequest = "request"
+)
+
+// cacheStatus is the result of handling a request, reported in the Cache-Status header field (https://www.rfc-editor.org/rfc/rfc9211).
+type cacheStatus struct {
+	hit       bool
+	fwd       string
+	fwdStatus int
+	ttl       *time.Duration
+	stored    bool
+	collapsed bool
+	// reason is the reason for the decision, returned by HandleWithReason.
+	reason Reason
+}
+
+// CacheStatus enables the Cache-Status header field (https://www.rfc-editor.org/rfc/rfc9211) with the cache identifier.
+func CacheStatus(identifier string) SharedOption {
+	return func(s *Shared) error {
+		s.cacheStatusIdentifier = identifier
+		return nil
+	}
+}
+
+// setTTL sets the remaining freshness lifetime of the response, which is negative if the response is stale.
+func (st *cacheStatus) setTTL(expires, now time.Time) {
+	ttl := expires.Sub(now)
+	st.ttl = &ttl
+}
+
+// value returns the list member of the Cache-Status header field for the cache.
+func (st *cacheStatus) value(identifier string) string {
+	params := []string{sfItem(identifier)}
+	if st.hit {
+		params = append(params, "hit")
+	}
+	if st.fwd != "" {
+		params = append(params, "fwd="+st.fwd)
+	}
+	if st.fwdStatus != 0 {
+		params = append(params, "fwd-status="+strconv.Itoa(st.fwdStatus))
+	}
+	if st.ttl != nil {
+		params = append(params, "ttl="+strconv.FormatInt(int64(*st.ttl/time.Second), 10))
+	}
+	if st.stored {
+		params = append(params, "stored")
+	}
+	if st.collapsed {
+		params = append(params, "collapsed")
+	}
+	return strings.Join(params, "; ")
+}
+
+// addCacheStatus appends the list member for the cache to the Cache-Status header field of the response.
+// Caches SHOULD add their list member after any existing members (https://www.rfc-editor.org/rfc/rfc9211#section-2).
+func (c *cache) addCacheStatus(req *http.Request, res *http.Response, cacheUsed bool, st *cacheStatus, now time.Time) {
+	if !cacheUsed {
+		// The ttl parameter is only for the response served from the cache.
+		st.ttl = nil
+		if st.fwdStatus == 0 {
+			// The response is generated without forwarding the request, such as with the only-if-cached request directive.
+			st.fwd = ""
+		}
+	}
+	if st.fwdStatus != 0 && (!cacheUsed || st.fwdStatus == http.StatusNotModified) {
+		// The forwarded or freshened response is stored by the caller if it is storable.
+		st.stored, _ = c.Storable(req, res, now)
+	}
+	if res.Header == nil {
+		res.Header = http.Header{}
+	}
+	if cacheUsed {
+		// The stored response may carry the member added when it was forwarded.
+		removeCacheStatus(res.Header, c.cacheStatusIdentifier)
+	}
+	res.Header.Add("Cache-Status", st.value(c.cacheStatusIdentifier))
+}
+
+// removeCacheStatus removes the list members of the cache from the Cache-Status header field.
+func removeCacheStatus(h http.Header, identifier string) {
+	values := h.Values("Cache-Status")
+	if len(values) == 0 {
+		return
+	}
+	id := sfItem(identifier)
+	var members []string
+	for _, v := range values {
+		for _, m := range strings.Split(v, ",") {
+			m = strings.TrimSpace(m)
+			if m == "" || m == id || strings.HasPrefix(m, id+";") {
+				continue
+			}
+			members = append(members, m)
+		}
+	}
+	h.Del("Cache-Status")
+	if len(members) > 0 {
+		h.Set("Cache-Status", strings.Join(members, ", "))
+	}
+}
+
+// sfItem returns the identifier as a Structured Fields token if possible, or as a string (https://www.rfc-editor.org/rfc/rfc8941#section-3.3).
+func sfItem(v string) string {
+	if isSFToken(v) {
+		return v
+	}
+	return strconv.Quote(v)
+}
+
+func isSFToken(v string) bool {
+	if v == "" || (v[0] != '*' && !isAlpha(v[0])) {
+		return false
+	}
+	for i := 1; i < len(v); i++ {
+		if !isTChar(v[i]) && v[i] != ':' && v[i] != '/' {
+			return false
+		}
+	}
+	return true
+}
+
+// forwardRecorder wraps do to record the status code of the forwarded response.
+func forwardRecorder(do func(*http.Request) (*http.Response, error), st *cacheStatus) func(*http.Request) (*http.Response, error) {
+	return func(req *http.Request) (*http.Response, error) {
+		res, err := do(req)
+		if err == nil && !httpcache.IsBackground(req.Context()) {
+			st.fwdStatus = res.StatusCode
+			// The response is shared with the request collapsed with another request (see httpcache.CollapsedForwarding).
+			st.collapsed = res.Header.Get(httpcache.HeaderCollapsed) != ""
+		}
+		return res, err
+	}
+}
@@ -0,0 +1,188 @@
+package rfc9111
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/k1LoW/httpcache"
+)
+
+func TestShared_HandleCacheStatus(t *testing.T) {
+	now := time.Date(2024, 12, 13, 14, 15, 16, 00, time.UTC)
+	endpoint, err := url.Parse("https://example.com/api/v1/path/to/resource")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		identifier string
+		reqcc      string
+		cached     http.Header
+		res        *http.Response
+		want       []string
+	}{
+		{
+			"miss and stored",
+			"ExampleCache",
+			"",
+			nil,
+			&http.Response{StatusCode: http.StatusOK, Header: http.Header{"Cache-Control": []string{"max-age=60"}}},
+			[]string{"ExampleCache; fwd=uri-miss; fwd-status=200; stored"},
+		},
+		{
+			"collapsed",
+			"ExampleCache",
+			"",
+			nil,
+			&http.Response{StatusCode: http.StatusOK, Header: http.Header{"Cache-Control": []string{"max-age=60"}, httpcache.HeaderCollapsed: []string{"1"}}},
+			[]string{"ExampleCache; fwd=uri-miss; fwd-status=200; stored; collapsed"},
+		},
+		{
+			"miss and not stored",
+			"ExampleCache",
+			"",
+			nil,
+			&http.Response{StatusCode: http.StatusOK, Header: http.Header{"Cache-Control": []string{"no-store"}}},
+			[]string{"ExampleCache; fwd=uri-miss; fwd-status=200"},
+		},
+		{
+			"hit",
+			"ExampleCache",
+			"",
+			http.Header{
+				"Cache-Control": []string{"max-age=60"},
+				"Date":          []string{now.Add(-10 * time.Second).Format(http.TimeFormat)},
+				"Cache-Status":  []string{"OriginCache; hit, ExampleCache; fwd=uri-miss; fwd-status=200; stored"},
+			},
+			nil,
+			[]string{"OriginCache; hit", "ExampleCache; hit; ttl=50"},
+		},
+		{
+			"stale",
+			"ExampleCache",
+			"",
+			http.Header{
+				"Cache-Control": []string{"max-age=5, must-revalidate"},
+				"Date":          []string{now.Add(-10 * time.Second).Format(http.TimeFormat)},
+			},
+			&http.Response{StatusCode: http.StatusOK, Header: http.Header{"Cache-Control": []string{"max-age=60"}}},
+			[]string{"ExampleCache; fwd=stale; fwd-status=200; stored"},
+		},
+		{
+			"request no-cache",
+			"ExampleCache",
+			"no-cache",
+			http.Header{
+				"Cache-Control": []string{"max-age=60"},
+				"Date":          []string{now.Add(-10 * time.Second).Format(http.TimeFormat)},
+			},
+			&http.Response{StatusCode: http.StatusOK, Header: http.Header{"Cache-Control": []string{"private"}}},
+			[]string{"ExampleCache; fwd=request; fwd-status=200"},
+		},
+		{
+			"validated",
+			"ExampleCache",
+			"",
+			http.Header{
+				"Cache-Control": []string{"max-age=5, must-revalidate"},
+				"Date":          []string{now.Add(-10 * time.Second).Format(http.TimeFormat)},
+				"Etag":          []string{`"a"`},
+			},
+			&http.Response{StatusCode: http.StatusNotModified, Header: http.Header{"Cache-Control": []string{"max-age=60"}, "Etag": []string{`"a"`}}},
+			[]string{"ExampleCache; fwd=stale; fwd-status=304; ttl=50; stored"},
+		},
+		{
+			"vary miss",
+			"ExampleCache",
+			"",
+			http.Header{
+				"Cache-Control": []string{"max-age=60"},
+				"Date":          []string{now.Add(-10 * time.Second).Format(http.TimeFormat)},
+				"Vary":          []string{"*"},
+			},
+			&http.Response{StatusCode: http.StatusOK, Header: http.Header{}},
+			[]string{"ExampleCache; fwd=vary-miss; fwd-status=200"},
+		},
+		{
+			"only-if-cached",
+			"ExampleCache",
+			"only-if-cached",
+			nil,
+			nil,
+			[]string{"ExampleCache"},
+		},
+		{
+			"identifier which is not a token",
+			"example cache",
+			"",
+			nil,
+			&http.Response{StatusCode: http.StatusOK, Header: http.Header{}},
+			[]string{`"example cache"; fwd=uri-miss; fwd-status=200`},
+		},
+		{
+			"disabled",
+			"",
+			"",
+			nil,
+			&http.Response{StatusCode: http.StatusOK, Header: http.Header{}},
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			s, err := NewShared(CacheStatus(tt.identifier))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := &http.Request{URL: endpoint, Method: http.MethodGet, Header: http.Header{}}
+			if tt.reqcc != "" {
+				req.Header.Set("Cache-Control", tt.reqcc)
+			}
+			var cachedReq *http.Request
+			var cachedRes *http.Response
+			if tt.cached != nil {
+				cachedReq = &http.Request{URL: endpoint, Method: http.MethodGet, Header: http.Header{}}
+				cachedRes = &http.Response{StatusCode: http.StatusOK, Header: tt.cached}
+			}
+			do := func(*http.Request) (*http.Response, error) {
+				return tt.res, nil
+			}
+			_, got, err := s.Handle(req, cachedReq, cachedRes, do, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.want, got.Header.Values("Cache-Status")); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestShared_HandleCacheStatusBypass(t *testing.T) {
+	now := time.Date(2024, 12, 13, 14, 15, 16, 00, time.UTC)
+	endpoint, err := url.Parse("https://example.com/api/v1/path/to/resource")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewShared(CacheStatus("ExampleCache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &http.Request{URL: endpoint, Method: "PROPFIND", Header: http.Header{}}
+	do := func(*http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusMultiStatus, Header: http.Header{}}, nil
+	}
+	_, got, err := s.Handle(req, nil, nil, do, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"ExampleCache; fwd=bypass; fwd-status=207"}
+	if diff := cmp.Diff(want, got.Header.Values("Cache-Status")); diff != "" {
+		t.Error(diff)
+	}
+}
@@ -1,6 +1,8 @@
 package rfc9111
 
 import (
+	"math"
+	"net/http"
 	"strconv"
 	"strings"
 )
@@ -20,6 +22,10 @@ type RequestDirectives struct {
 	NoTransform bool
 	// only-if-cached https://www.rfc-editor.org/rfc/rfc9111#section-5.2.1.7.
 	OnlyIfCached bool
+	// stale-if-error https://www.rfc-editor.org/rfc/rfc5861#section-4.
+	StaleIfError *uint32
+	// Extensions are the cache directives not recognized by the parser https://www.rfc-editor.org/rfc/rfc9111#section-5.2.3.
+	Extensions []Extension
 }
 
 type ResponseDirectives struct {
@@ -31,64 +37,221 @@ type ResponseDirectives struct {
 	MustUnderstand bool
 	// no-cache https://www.rfc-editor.org/rfc/rfc9111#section-5.2.2.4.
 	NoCache bool
+	// NoCacheFields are the field names of the qualified no-cache directive https://www.rfc-editor.org/rfc/rfc9111#section-5.2.2.4.
+	NoCacheFields []string
 	// no-store https://www.rfc-editor.org/rfc/rfc9111#section-5.2.2.5.
 	NoStore bool
 	// no-transform https://www.rfc-editor.org/rfc/rfc9111#section-5.2.2.6.
 	NoTransform bool
 	// private https://www.rfc-editor.org/rfc/rfc9111#section-5.2.2.7.
 	Private bool
+	// PrivateFields are the field names of the qualified private directive https://www.rfc-editor.org/rfc/rfc9111#section-5.2.2.7.
+	PrivateFields []string
 	// proxy-revalidate https://www.rfc-editor.org/rfc/rfc9111#section-5.2.2.8.
 	ProxyRevalidate bool
 	// public https://www.rfc-editor.org/rfc/rfc9111#section-5.2.2.9.
 	Public bool
 	// s-maxag https://www.rfc-editor.org/rfc/rfc9111#section-5.2.2.10.
 	SMaxAge *uint32
+	// stale-while-revalidate https://www.rfc-editor.org/rfc/rfc5861#section-3.
+	StaleWhileRevalidate *uint32
+	// stale-if-error https://www.rfc-editor.org/rfc/rfc5861#section-4.
+	StaleIfError *uint32
+	// Extensions are the cache directives not recognized by the parser https://www.rfc-editor.org/rfc/rfc9111#section-5.2.3.
+	Extensions []Extension
+}
+
+// Extension is a cache directive not recognized by the parser (https://www.rfc-editor.org/rfc/rfc9111#section-5.2.3).
+type Extension struct {
+	// Name is the lowercased directive name.
+	Name string
+	// Value is the argument of the directive, unquoted if it is a quoted-string.
+	// Value is empty if the directive has no argument.
+	Value string
+	// HasValue is true if the directive has an argument, which distinguishes an empty quoted-string argument from no argument.
+	HasValue bool
+}
+
+// directive is a cache directive (https://www.rfc-editor.org/rfc/rfc9111#section-5.2).
+//
+//	cache-directive = token [ "=" ( token / quoted-string ) ]
+type directive struct {
+	name     string
+	value    string
+	hasValue bool
+}
+
+// parseDirectives parses the Cache-Control header field values into cache directives.
+// Cache directives are identified by a token, to be compared case-insensitively, and have an optional argument that can use both token and quoted-string syntax (https://www.rfc-editor.org/rfc/rfc9111#section-5.2).
+// Malformed list elements are skipped.
+func parseDirectives(headers []string) []directive {
+	var ds []directive
+	for _, h := range headers {
+		i := 0
+		for i < len(h) {
+			d, next, ok := parseDirective(h, i)
+			if ok {
+				ds = append(ds, d)
+			}
+			i = next
+		}
+	}
+	return ds
+}
+
+// parseDirective parses a list element of the Cache-Control header field value starting at i, and returns the position after the following comma.
+func parseDirective(h string, i int) (directive, int, bool) {
+	i = skipOWS(h, i)
+	start := i
+	for i < len(h) && isTChar(h[i]) {
+		i++
+	}
+	d := directive{name: strings.ToLower(h[start:i])}
+	i = skipOWS(h, i)
+	if i < len(h) && h[i] == '=' {
+		d.hasValue = true
+		i = skipOWS(h, i+1)
+		if i < len(h) && h[i] == '"' {
+			v, next, ok := parseQuotedString(h, i)
+			if !ok {
+				return directive{}, len(h), false
+			}
+			d.value, i = v, next
+		} else {
+			start := i
+			for i < len(h) && isTChar(h[i]) {
+				i++
+			}
+			if i == start {
+				// An empty token is not allowed.
+				d.name = ""
+			}
+			d.value = h[start:i]
+		}
+		i = skipOWS(h, i)
+	}
+	if i < len(h) && h[i] != ',' {
+		// Skip the rest of the malformed element.
+		for i < len(h) && h[i] != ',' {
+			if h[i] == '"' {
+				_, next, ok := parseQuotedString(h, i)
+				if !ok {
+					return directive{}, len(h), false
+				}
+				i = next
+				continue
+			}
+			i++
+		}
+		return directive{}, i + 1, false
+	}
+	if d.name == "" {
+		return directive{}, i + 1, false
+	}
+	return d, i + 1, true
+}
+
+// parseQuotedString parses a quoted-string starting at i (https://www.rfc-editor.org/rfc/rfc9110#section-5.6.4).
+func parseQuotedString(h string, i int) (string, int, bool) {
+	var b strings.Builder
+	for i++; i < len(h); i++ {
+		switch h[i] {
+		case '"':
+			return b.String(), i + 1, true
+		case '\\':
+			i++
+			if i == len(h) {
+				return "", len(h), false
+			}
+			b.WriteByte(h[i])
+		default:
+			b.WriteByte(h[i])
+		}
+	}
+	return "", len(h), false
+}
+
+func skipOWS(h string, i int) int {
+	for i < len(h) && (h[i] == ' ' || h[i] == '\t') {
+		i++
+	}
+	return i
+}
+
+// parseFieldNames parses the argument of the qualified form of a directive as a list of field names (https://www.rfc-editor.org/rfc/rfc9111#section-5.2.2.4).
+// It returns false if the directive has no argument or the argument has no valid field names, so that the directive is handled as unqualified.
+func parseFieldNames(t directive) ([]string, bool) {
+	if !t.hasValue {
+		return nil, false
+	}
+	var fields []string
+	for _, f := range strings.Split(t.value, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" || strings.IndexFunc(f, func(r rune) bool { return r > 0x7e || !isTChar(byte(r)) }) >= 0 {
+			continue
+		}
+		fields = append(fields, http.CanonicalHeaderKey(f))
+	}
+	return fields, len(fields) > 0
+}
+
+// parseDeltaSeconds parses the argument of a directive as delta-seconds (https://www.rfc-editor.org/rfc/rfc9111#section-1.2.2).
+func parseDeltaSeconds(v string) (*uint32, bool) {
+	if v == "" {
+		return nil, false
+	}
+	for i := 0; i < len(v); i++ {
+		if !isDigit(v[i]) {
+			return nil, false
+		}
+	}
+	u64, err := strconv.ParseUint(v, 10, 32)
+	if err != nil {
+		// If a cache receives a delta-seconds value greater than the greatest integer it can represent, or if any of its subsequent calculations overflows, the cache MUST consider the value to be 2147483648 (2^31) or the greatest positive integer it can conveniently represent.
+		u64 = math.MaxUint32
+	}
+	u32 := uint32(u64)
+	return &u32, true
 }
 
 // ParseRequestCacheControlHeader parses the Cache-Control header of a request.
 func ParseRequestCacheControlHeader(headers []string) *RequestDirectives {
 	d := &RequestDirectives{}
-	for _, h := range headers {
-		tokens := strings.Split(h, ",")
-		for _, t := range tokens {
-			t = strings.TrimSpace(t)
-			switch {
-			// When there is more than one value present for a given directive (e.g., two Expires header field lines or multiple Cache-Control: max-age directives), either the first occurrence should be used or the response should be considered stale.
-			case strings.HasPrefix(t, "max-age=") && d.MaxAge == nil:
-				sec := strings.TrimPrefix(t, "max-age=")
-				u64, err := strconv.ParseUint(sec, 10, 32)
-				if err != nil {
-					continue
-				}
-				u32 := uint32(u64)
-				d.MaxAge = &u32
-			case strings.HasPrefix(t, "max-stale=") && d.MaxStale == nil:
-				sec := strings.TrimPrefix(t, "max-stale=")
-				u64, err := strconv.ParseUint(sec, 10, 32)
-				if err != nil {
-					continue
-				}
-				u32 := uint32(u64)
+	for _, t := range parseDirectives(headers) {
+		// When there is more than one value present for a given directive (e.g., two Expires header field lines or multiple Cache-Control: max-age directives), either the first occurrence should be used or the response should be considered stale.
+		switch t.name {
+		case "max-age":
+			if v, ok := parseDeltaSeconds(t.value); ok && d.MaxAge == nil {
+				d.MaxAge = v
+			}
+		case "max-stale":
+			if !t.hasValue && d.MaxStale == nil {
+				// If no value is assigned to max-stale, then the client will accept a stale response of any age.
+				u32 := uint32(math.MaxUint32)
 				d.MaxStale = &u32
-			case strings.HasPrefix(t, "min-fresh=") && d.MinFresh == nil:
-				sec := strings.TrimPrefix(t, "min-fresh=")
-				u64, err := strconv.ParseUint(sec, 10, 32)
-				if err != nil {
-					continue
-				}
-				u32 := uint32(u64)
-				d.MinFresh = &u32
-			case t == "no-cache":
-				d.NoCache = true
-			case t == "no-store":
-				d.NoStore = true
-			case t == "no-transform":
-				d.NoTransform = true
-			case t == "only-if-cached":
-				d.OnlyIfCached = true
-			default:
-				// A cache MUST ignore unrecognized cache directives. (https://www.rfc-editor.org/rfc/rfc9111#section-5.2.3)
 			}
+			if v, ok := parseDeltaSeconds(t.value); ok && d.MaxStale == nil {
+				d.MaxStale = v
+			}
+		case "min-fresh":
+			if v, ok := parseDeltaSeconds(t.value); ok && d.MinFresh == nil {
+				d.MinFresh = v
+			}
+		case "no-cache":
+			d.NoCache = true
+		case "no-store":
+			d.NoStore = true
+		case "no-transform":
+			d.NoTransform = true
+		case "only-if-cached":
+			d.OnlyIfCached = true
+		case "stale-if-error":
+			if v, ok := parseDeltaSeconds(t.value); ok && d.StaleIfError == nil {
+				d.StaleIfError = v
+			}
+		default:
+			// A cache MUST ignore unrecognized cache directives. (https://www.rfc-editor.org/rfc/rfc9111#section-5.2.3)
+			d.Extensions = append(d.Extensions, Extension{Name: t.name, Value: t.value, HasValue: t.hasValue})
 		}
 	}
 	return d
@@ -97,48 +260,181 @@ func ParseRequestCacheControlHeader(headers []string) *RequestDirectives {
 // ParseResponseCacheControlHeader parses the Cache-Control header of a response.
 func ParseResponseCacheControlHeader(headers []string) *ResponseDirectives {
 	d := &ResponseDirectives{}
-	for _, h := range headers {
-		tokens := strings.Split(h, ",")
-		for _, t := range tokens {
-			t = strings.TrimSpace(t)
-			switch {
-			// When there is more than one value present for a given directive (e.g., two Expires header field lines or multiple Cache-Control: max-age directives), either the first occurrence should be used or the response should be considered stale.
-			case strings.HasPrefix(t, "max-age=") && d.MaxAge == nil:
-				sec := strings.TrimPrefix(t, "max-age=")
-				u64, err := strconv.ParseUint(sec, 10, 32)
-				if err != nil {
-					continue
-				}
-				u32 := uint32(u64)
-				d.MaxAge = &u32
-			case t == "must-revalidate":
-				d.MustRevalidate = true
-			case t == "must-understand":
-				d.MustUnderstand = true
-			case t == "no-cache":
-				d.NoCache = true
-			case t == "no-store":
-				d.NoStore = true
-			case t == "no-transform":
-				d.NoTransform = true
-			case t == "private":
-				d.Private = true
-			case t == "proxy-revalidate":
-				d.ProxyRevalidate = true
-			case t == "public":
-				d.Public = true
-			case strings.HasPrefix(t, "s-maxage=") && d.SMaxAge == nil:
-				sec := strings.TrimPrefix(t, "s-maxage=")
-				u64, err := strconv.ParseUint(sec, 10, 32)
-				if err != nil {
-					continue
-				}
-				u32 := uint32(u64)
-				d.SMaxAge = &u32
-			default:
-				// A cache MUST ignore unrecognized cache directives. (https://www.rfc-editor.org/rfc/rfc9111#section-5.2.3)
+	for _, t := range parseDirectives(headers) {
+		// When there is more than one value present for a given directive (e.g., two Expires header field lines or multiple Cache-Control: max-age directives), either the first occurrence should be used or the response should be considered stale.
+		switch t.name {
+		case "max-age":
+			if v, ok := parseDeltaSeconds(t.value); ok && d.MaxAge == nil {
+				d.MaxAge = v
+			}
+		case "must-revalidate":
+			d.MustRevalidate = true
+		case "must-understand":
+			d.MustUnderstand = true
+		case "no-cache":
+			if fields, ok := parseFieldNames(t); ok {
+				d.NoCacheFields = append(d.NoCacheFields, fields...)
+				continue
+			}
+			d.NoCache = true
+		case "no-store":
+			d.NoStore = true
+		case "no-transform":
+			d.NoTransform = true
+		case "private":
+			if fields, ok := parseFieldNames(t); ok {
+				d.PrivateFields = append(d.PrivateFields, fields...)
+				continue
 			}
+			d.Private = true
+		case "proxy-revalidate":
+			d.ProxyRevalidate = true
+		case "public":
+			d.Public = true
+		case "s-maxage":
+			if v, ok := parseDeltaSeconds(t.value); ok && d.SMaxAge == nil {
+				d.SMaxAge = v
+			}
+		case "stale-while-revalidate":
+			if v, ok := parseDeltaSeconds(t.value); ok && d.StaleWhileRevalidate == nil {
+				d.StaleWhileRevalidate = v
+			}
+		case "stale-if-error":
+			if v, ok := parseDeltaSeconds(t.value); ok && d.StaleIfError == nil {
+				d.StaleIfError = v
+			}
+		default:
+			// A cache MUST ignore unrecognized cache directives. (https://www.rfc-editor.org/rfc/rfc9111#section-5.2.3)
+			d.Extensions = append(d.Extensions, Extension{Name: t.name, Value: t.value, HasValue: t.hasValue})
 		}
 	}
 	return d
 }
+
+// String returns the directives as a canonical Cache-Control header field value.
+func (d *RequestDirectives) String() string {
+	w := &directiveWriter{}
+	w.deltaSeconds("max-age", d.MaxAge)
+	if d.MaxStale != nil && *d.MaxStale == math.MaxUint32 {
+		w.flag("max-stale", true)
+	} else {
+		w.deltaSeconds("max-stale", d.MaxStale)
+	}
+	w.deltaSeconds("min-fresh", d.MinFresh)
+	w.flag("no-cache", d.NoCache)
+	w.flag("no-store", d.NoStore)
+	w.flag("no-transform", d.NoTransform)
+	w.flag("only-if-cached", d.OnlyIfCached)
+	w.deltaSeconds("stale-if-error", d.StaleIfError)
+	w.extensions(d.Extensions)
+	return w.String()
+}
+
+// Header returns the header fields containing the Cache-Control header field for the directives.
+func (d *RequestDirectives) Header() http.Header {
+	return cacheControlHeader(d.String())
+}
+
+// String returns the directives as a canonical Cache-Control header field value.
+func (d *ResponseDirectives) String() string {
+	w := &directiveWriter{}
+	w.deltaSeconds("max-age", d.MaxAge)
+	w.flag("must-revalidate", d.MustRevalidate)
+	w.flag("must-understand", d.MustUnderstand)
+	w.flag("no-cache", d.NoCache)
+	w.fieldNames("no-cache", d.NoCacheFields)
+	w.flag("no-store", d.NoStore)
+	w.flag("no-transform", d.NoTransform)
+	w.flag("private", d.Private)
+	w.fieldNames("private", d.PrivateFields)
+	w.flag("proxy-revalidate", d.ProxyRevalidate)
+	w.flag("public", d.Public)
+	w.deltaSeconds("s-maxage", d.SMaxAge)
+	w.deltaSeconds("stale-while-revalidate", d.StaleWhileRevalidate)
+	w.deltaSeconds("stale-if-error", d.StaleIfError)
+	w.extensions(d.Extensions)
+	return w.String()
+}
+
+// Header returns the header fields containing the Cache-Control header field for the directives.
+func (d *ResponseDirectives) Header() http.Header {
+	return cacheControlHeader(d.String())
+}
+
+func cacheControlHeader(v string) http.Header {
+	h := http.Header{}
+	if v != "" {
+		h.Set("Cache-Control", v)
+	}
+	return h
+}
+
+// directiveWriter writes cache directives as a Cache-Control header field value.
+// Arguments use the token form, or the quoted-string form if they are not tokens (https://www.rfc-editor.org/rfc/rfc9111#section-5.2).
+type directiveWriter struct {
+	directives []string
+}
+
+func (w *directiveWriter) flag(name string, v bool) {
+	if v {
+		w.directives = append(w.directives, name)
+	}
+}
+
+func (w *directiveWriter) deltaSeconds(name string, v *uint32) {
+	if v != nil {
+		w.directives = append(w.directives, name+"="+strconv.FormatUint(uint64(*v), 10))
+	}
+}
+
+// fieldNames writes the qualified form of a directive, which uses the quoted-string form (https://www.rfc-editor.org/rfc/rfc9111#section-5.2.2.4).
+func (w *directiveWriter) fieldNames(name string, fields []string) {
+	if len(fields) > 0 {
+		w.directives = append(w.directives, name+"="+quoteString(strings.Join(fields, ", ")))
+	}
+}
+
+// extensions writes the extension directives. An extension whose name is not a token cannot be written, and is skipped.
+func (w *directiveWriter) extensions(exts []Extension) {
+	for _, e := range exts {
+		if !isToken(e.Name) {
+			continue
+		}
+		if e.Value == "" && !e.HasValue {
+			w.directives = append(w.directives, e.Name)
+			continue
+		}
+		if isToken(e.Value) {
+			w.directives = append(w.directives, e.Name+"="+e.Value)
+			continue
+		}
+		w.directives = append(w.directives, e.Name+"="+quoteString(e.Value))
+	}
+}
+
+func (w *directiveWriter) String() string {
+	return strings.Join(w.directives, ", ")
+}
+
+func isToken(v string) bool {
+	for i := 0; i < len(v); i++ {
+		if !isTChar(v[i]) {
+			return false
+		}
+	}
+	return v != ""
+}
+
+// quoteString returns v as a quoted-string (https://www.rfc-editor.org/rfc/rfc9110#section-5.6.4).
+func quoteString(v string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(v); i++ {
+		if v[i] == '"' || v[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(v[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
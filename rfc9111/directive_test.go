@@ -0,0 +1,105 @@
+package rfc9111
+
+import (
+	"math"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseRequestCacheControlHeader(t *testing.T) {
+	u32 := func(v uint32) *uint32 { return &v }
+	tests := []struct {
+		name    string
+		headers []string
+		want    *RequestDirectives
+	}{
+		{"empty", nil, &RequestDirectives{}},
+		{"max-age", []string{"max-age=60"}, &RequestDirectives{MaxAge: u32(60)}},
+		{"case-insensitive", []string{"Max-Age=60, NO-CACHE"}, &RequestDirectives{MaxAge: u32(60), NoCache: true}},
+		{"whitespace", []string{" max-age = 60 ,\tno-store "}, &RequestDirectives{MaxAge: u32(60), NoStore: true}},
+		{"quoted argument", []string{`max-age="60"`}, &RequestDirectives{MaxAge: u32(60)}},
+		{"first occurrence", []string{"max-age=60", "max-age=10"}, &RequestDirectives{MaxAge: u32(60)}},
+		{"max-stale without value", []string{"max-stale"}, &RequestDirectives{MaxStale: u32(math.MaxUint32)}},
+		{"overflow", []string{"min-fresh=99999999999"}, &RequestDirectives{MinFresh: u32(math.MaxUint32)}},
+		{"invalid argument", []string{"max-age=-1, max-age=abc, only-if-cached"}, &RequestDirectives{OnlyIfCached: true}},
+		{"malformed elements", []string{`max-age=, =1, a b, "c", max-age=5`}, &RequestDirectives{MaxAge: u32(5)}},
+		{"extensions", []string{`community="UCI", Foo=Bar, baz`}, &RequestDirectives{Extensions: []Extension{{"community", "UCI", true}, {"foo", "Bar", true}, {"baz", "", false}}}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got := ParseRequestCacheControlHeader(tt.headers)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestParseResponseCacheControlHeader(t *testing.T) {
+	u32 := func(v uint32) *uint32 { return &v }
+	tests := []struct {
+		name    string
+		headers []string
+		want    *ResponseDirectives
+	}{
+		{"empty", nil, &ResponseDirectives{}},
+		{"public max-age", []string{"public, max-age=60"}, &ResponseDirectives{Public: true, MaxAge: u32(60)}},
+		{"quoted string with commas", []string{`no-cache="Set-Cookie, Authorization", s-maxage=10`}, &ResponseDirectives{NoCacheFields: []string{"Set-Cookie", "Authorization"}, SMaxAge: u32(10)}},
+		{"escaped quote", []string{`private="a\"b, c", must-revalidate`}, &ResponseDirectives{PrivateFields: []string{"C"}, MustRevalidate: true}},
+		{"qualified private", []string{`private="x-user", private`}, &ResponseDirectives{Private: true, PrivateFields: []string{"X-User"}}},
+		{"qualified no-cache without field names", []string{`no-cache=""`}, &ResponseDirectives{NoCache: true}},
+		{"unterminated quoted string", []string{`max-age=10, foo="bar, public`}, &ResponseDirectives{MaxAge: u32(10)}},
+		{"stale extensions", []string{"stale-while-revalidate=30, stale-if-error=60"}, &ResponseDirectives{StaleWhileRevalidate: u32(30), StaleIfError: u32(60)}},
+		{"extensions", []string{`MaxAge=1, x-foo="a, b", x-bar=""`}, &ResponseDirectives{Extensions: []Extension{{"maxage", "1", true}, {"x-foo", "a, b", true}, {"x-bar", "", true}}}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got := ParseResponseCacheControlHeader(tt.headers)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestDirectivesString(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []string
+		want    string
+	}{
+		{"empty", nil, ""},
+		{"canonical order", []string{"PUBLIC, s-maxage=\"10\", max-age=60"}, "max-age=60, public, s-maxage=10"},
+		{"qualified", []string{`no-cache="set-cookie,authorization", private`}, `no-cache="Set-Cookie, Authorization", private`},
+		{"extensions", []string{`community="UCI", foo="a \"b\"", bar`}, `community=UCI, foo="a \"b\"", bar`},
+		{"empty extension argument", []string{`foo="", bar`}, `foo="", bar`},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			d := ParseResponseCacheControlHeader(tt.headers)
+			got := d.String()
+			if got != tt.want {
+				t.Errorf("ResponseDirectives.String() = %q, want %q", got, tt.want)
+			}
+			// The serialized directives round-trip with the parser.
+			if diff := cmp.Diff(d, ParseResponseCacheControlHeader(d.Header().Values("Cache-Control"))); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+
+	reqcc := ParseRequestCacheControlHeader([]string{"max-stale, no-cache, x-foo=1"})
+	if got, want := reqcc.String(), "max-stale, no-cache, x-foo=1"; got != want {
+		t.Errorf("RequestDirectives.String() = %q, want %q", got, want)
+	}
+	if diff := cmp.Diff(reqcc, ParseRequestCacheControlHeader(reqcc.Header().Values("Cache-Control"))); diff != "" {
+		t.Error(diff)
+	}
+}
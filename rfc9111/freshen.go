@@ -0,0 +1,107 @@
+package rfc9111
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/k1LoW/httpcache"
+)
+
+var (
+	_ httpcache.Freshener = (*Shared)(nil)
+	_ httpcache.Freshener = (*Private)(nil)
+)
+
+// hopByHopHeaders are header fields that are not stored (https://www.rfc-editor.org/rfc/rfc9111#section-3.1).
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Te",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// UpdateStoredHeader returns the header fields of the stored response updated with those of a newer response,
+// such as a 304 (Not Modified) response (https://www.rfc-editor.org/rfc/rfc9111#section-3.2).
+func UpdateStoredHeader(stored, header http.Header) http.Header {
+	updated := stored.Clone()
+	excepted := map[string]struct{}{
+		// The Content-Length header field is not updated, because the new response does not describe the stored content.
+		"Content-Length": {},
+	}
+	for _, h := range hopByHopHeaders {
+		excepted[h] = struct{}{}
+	}
+	// Header fields listed in the Connection header field are hop-by-hop.
+	for _, v := range header.Values("Connection") {
+		for _, h := range strings.Split(v, ",") {
+			excepted[http.CanonicalHeaderKey(strings.TrimSpace(h))] = struct{}{}
+		}
+	}
+	// The cache MUST add each header field in the provided response to the stored response, replacing field values that are already present.
+	for k, v := range header {
+		if _, ok := excepted[http.CanonicalHeaderKey(k)]; ok {
+			continue
+		}
+		updated[k] = append([]string(nil), v...)
+	}
+	return updated
+}
+
+// selectedForUpdate returns true if the stored response is selected to be updated by the 304 (Not Modified) response (https://www.rfc-editor.org/rfc/rfc9111#section-4.3.4).
+// A 304 response without any validator updates the stored response, because many origin servers omit validators in 304 responses.
+func selectedForUpdate(stored, header http.Header) bool {
+	if etag := header.Get("ETag"); etag != "" {
+		storedETag := stored.Get("ETag")
+		if storedETag == "" {
+			return false
+		}
+		// - If the new response contains one or more strong validators, then each of those strong validators identifies a selected representation for update.
+		if !strings.HasPrefix(etag, "W/") {
+			return etag == storedETag
+		}
+		// - If the new response contains no strong validators but does contain one or more weak validators, and those validators correspond to one of the cache's stored responses, then the most recent of those matching stored responses is selected for update.
+		return strings.TrimPrefix(etag, "W/") == strings.TrimPrefix(storedETag, "W/")
+	}
+	if lm := header.Get("Last-Modified"); lm != "" {
+		if storedLM := stored.Get("Last-Modified"); storedLM != "" {
+			return lm == storedLM
+		}
+	}
+	return true
+}
+
+// Freshen freshens the stored response to a GET request with the 200 (OK) response to a HEAD request (https://www.rfc-editor.org/rfc/rfc9111#section-4.3.5).
+// The stored response is affected only if it could have been selected for the HEAD request.
+func (c *cache) Freshen(req *http.Request, res *http.Response, cachedReq *http.Request, cachedRes *http.Response) (bool, *http.Response) {
+	// When a cache makes an inbound HEAD request for a target URI and receives a 200 (OK) response, the cache SHOULD update or invalidate each of its stored GET responses that could have been chosen for that request (see https://www.rfc-editor.org/rfc/rfc9111#section-4.1).
+	if req.Method != http.MethodHead || cachedReq.Method != http.MethodGet || res.StatusCode != http.StatusOK {
+		return false, nil
+	}
+	if !c.targetMatches(req, cachedReq) || !varyMatches(req, cachedReq, cachedRes) {
+		return false, nil
+	}
+	// For each of the stored responses that could have been chosen, if the stored response and HEAD response have matching values for any received validator fields (ETag and Last-Modified)
+	// and, if the HEAD response has a Content-Length header field, the value of Content-Length matches that of the stored response, the cache SHOULD update the stored response as described below;
+	// otherwise, the cache SHOULD consider the stored response to be stale.
+	for _, h := range []string{"ETag", "Last-Modified"} {
+		if v := res.Header.Get(h); v != "" && v != cachedRes.Header.Get(h) {
+			return true, nil
+		}
+	}
+	if v := res.Header.Get("Content-Length"); v != "" {
+		cl := cachedRes.Header.Get("Content-Length")
+		if cl == "" && cachedRes.ContentLength >= 0 {
+			cl = strconv.FormatInt(cachedRes.ContentLength, 10)
+		}
+		if v != cl {
+			return true, nil
+		}
+	}
+	// If a cache updates a stored response with the metadata provided in a HEAD response, the cache MUST use the header fields provided in the HEAD response to update the stored response (see https://www.rfc-editor.org/rfc/rfc9111#section-3.2).
+	freshened := *cachedRes
+	freshened.Header = UpdateStoredHeader(cachedRes.Header, res.Header)
+	return true, &freshened
+}
@@ -0,0 +1,192 @@
+package rfc9111
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestUpdateStoredHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		stored http.Header
+		header http.Header
+		want   http.Header
+	}{
+		{
+			"replace and add",
+			http.Header{
+				"Cache-Control": []string{"max-age=10"},
+				"Content-Type":  []string{"text/plain"},
+				"Etag":          []string{`"a"`},
+			},
+			http.Header{
+				"Cache-Control": []string{"max-age=60"},
+				"Expires":       []string{"Mon, 13 Dec 2024 14:15:20 GMT"},
+			},
+			http.Header{
+				"Cache-Control": []string{"max-age=60"},
+				"Content-Type":  []string{"text/plain"},
+				"Etag":          []string{`"a"`},
+				"Expires":       []string{"Mon, 13 Dec 2024 14:15:20 GMT"},
+			},
+		},
+		{
+			"excepted",
+			http.Header{
+				"Content-Length": []string{"5"},
+			},
+			http.Header{
+				"Content-Length":    []string{"0"},
+				"Connection":        []string{"close, X-Hop"},
+				"Transfer-Encoding": []string{"chunked"},
+				"X-Hop":             []string{"1"},
+			},
+			http.Header{
+				"Content-Length": []string{"5"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got := UpdateStoredHeader(tt.stored, tt.header)
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Errorf("UpdateStoredHeader() mismatch:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestShared_Freshen(t *testing.T) {
+	endpoint, err := url.Parse("https://example.com/api/v1/path/to/resource")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cachedHeader := http.Header{
+		"Cache-Control":  []string{"max-age=10"},
+		"Content-Length": []string{"5"},
+		"Etag":           []string{`"v1"`},
+		"Last-Modified":  []string{"Fri, 13 Dec 2024 14:00:00 GMT"},
+	}
+	tests := []struct {
+		name         string
+		method       string
+		cachedMethod string
+		statusCode   int
+		header       http.Header
+		wantAffected bool
+		wantHeader   http.Header
+	}{
+		{
+			"matching validators",
+			http.MethodHead,
+			http.MethodGet,
+			http.StatusOK,
+			http.Header{"Cache-Control": []string{"max-age=60"}, "Content-Length": []string{"5"}, "Etag": []string{`"v1"`}},
+			true,
+			http.Header{
+				"Cache-Control":  []string{"max-age=60"},
+				"Content-Length": []string{"5"},
+				"Etag":           []string{`"v1"`},
+				"Last-Modified":  []string{"Fri, 13 Dec 2024 14:00:00 GMT"},
+			},
+		},
+		{
+			"no validators",
+			http.MethodHead,
+			http.MethodGet,
+			http.StatusOK,
+			http.Header{"Cache-Control": []string{"max-age=60"}},
+			true,
+			http.Header{
+				"Cache-Control":  []string{"max-age=60"},
+				"Content-Length": []string{"5"},
+				"Etag":           []string{`"v1"`},
+				"Last-Modified":  []string{"Fri, 13 Dec 2024 14:00:00 GMT"},
+			},
+		},
+		{
+			"different ETag",
+			http.MethodHead,
+			http.MethodGet,
+			http.StatusOK,
+			http.Header{"Etag": []string{`"v2"`}},
+			true,
+			nil,
+		},
+		{
+			"different Last-Modified",
+			http.MethodHead,
+			http.MethodGet,
+			http.StatusOK,
+			http.Header{"Last-Modified": []string{"Fri, 13 Dec 2024 15:00:00 GMT"}},
+			true,
+			nil,
+		},
+		{
+			"different Content-Length",
+			http.MethodHead,
+			http.MethodGet,
+			http.StatusOK,
+			http.Header{"Content-Length": []string{"6"}, "Etag": []string{`"v1"`}},
+			true,
+			nil,
+		},
+		{
+			"not 200",
+			http.MethodHead,
+			http.MethodGet,
+			http.StatusNotFound,
+			http.Header{},
+			false,
+			nil,
+		},
+		{
+			"GET",
+			http.MethodGet,
+			http.MethodGet,
+			http.StatusOK,
+			http.Header{},
+			false,
+			nil,
+		},
+		{
+			"stored HEAD",
+			http.MethodHead,
+			http.MethodHead,
+			http.StatusOK,
+			http.Header{},
+			false,
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			s, err := NewShared()
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := &http.Request{Method: tt.method, URL: endpoint, Header: http.Header{}}
+			res := &http.Response{StatusCode: tt.statusCode, Header: tt.header}
+			cachedReq := &http.Request{Method: tt.cachedMethod, URL: endpoint, Header: http.Header{}}
+			cachedRes := &http.Response{StatusCode: http.StatusOK, Header: cachedHeader, ContentLength: 5}
+			gotAffected, got := s.Freshen(req, res, cachedReq, cachedRes)
+			if gotAffected != tt.wantAffected {
+				t.Errorf("Shared.Freshen() gotAffected = %v, want %v", gotAffected, tt.wantAffected)
+			}
+			var gotHeader http.Header
+			if got != nil {
+				gotHeader = got.Header
+			}
+			if diff := cmp.Diff(tt.wantHeader, gotHeader); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
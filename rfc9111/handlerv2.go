@@ -0,0 +1,81 @@
+package rfc9111
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/k1LoW/httpcache"
+)
+
+var _ httpcache.HandlerV2 = (*handlerV2)(nil)
+
+// handlerV2 is a Shared or Private cache used as an httpcache.HandlerV2, which reports the outcome by the reason for the decision.
+// The optional interfaces implemented by the cache, such as httpcache.Invalidator and httpcache.Combiner, are promoted from it.
+type handlerV2 struct {
+	*cache
+}
+
+// HandlerV2 returns the cache as an httpcache.HandlerV2.
+// httpcache.AdaptHandler uses it, so that a stored response served stale is reported as httpcache.OutcomeStale.
+func (c *cache) HandlerV2() httpcache.HandlerV2 {
+	return &handlerV2{cache: c}
+}
+
+// Handle implements httpcache.HandlerV2.
+func (h *handlerV2) Handle(ctx context.Context, req *http.Request, cachedReq *http.Request, cachedRes *http.Response, do func(context.Context, *http.Request) (*http.Response, error), now time.Time) (*httpcache.Decision, error) {
+	var (
+		resTime   time.Time
+		validated *http.Response
+	)
+	req = req.WithContext(ctx)
+	cacheUsed, res, reason, err := h.HandleWithReason(req, cachedReq, cachedRes, func(r *http.Request) (*http.Response, error) {
+		res, err := do(r.Context(), r)
+		if !httpcache.IsBackground(r.Context()) {
+			resTime = time.Now()
+			if err == nil && res.StatusCode == http.StatusNotModified {
+				validated = res
+			}
+		}
+		return res, err
+	}, now)
+	if err != nil {
+		return nil, err
+	}
+	d := &httpcache.Decision{Outcome: outcome(cacheUsed, reason), Response: res}
+	switch d.Outcome {
+	case httpcache.OutcomeMiss:
+		d.Invalidations = h.Invalidate(req, res)
+	case httpcache.OutcomeRevalidated:
+		d.UpdateHeader = validated.Header
+	case httpcache.OutcomeHit, httpcache.OutcomeStale:
+		return d, nil
+	}
+	if resTime.IsZero() {
+		// The response was not obtained through do.
+		resTime = time.Now()
+	}
+	if ok, expires := h.cache.Storable(req, res, resTime); ok {
+		d.Expires = expires
+	}
+	return d, nil
+}
+
+// Storable implements httpcache.HandlerV2.
+func (h *handlerV2) Storable(_ context.Context, req *http.Request, res *http.Response, now time.Time) (bool, time.Time) {
+	return h.cache.Storable(req, res, now)
+}
+
+// outcome returns how the response is obtained from whether the stored response is used and the reason for the decision.
+func outcome(cacheUsed bool, reason Reason) httpcache.Outcome {
+	switch {
+	case !cacheUsed:
+		return httpcache.OutcomeMiss
+	case reason == ReasonValidated:
+		return httpcache.OutcomeRevalidated
+	case reason == ReasonStaleWhileRevalidate || reason == ReasonMaxStale || reason == ReasonStaleIfError:
+		return httpcache.OutcomeStale
+	default:
+		return httpcache.OutcomeHit
+	}
+}
@@ -0,0 +1,72 @@
+package rfc9111
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/k1LoW/httpcache"
+)
+
+var (
+	_ httpcache.Invalidator = (*Shared)(nil)
+	_ httpcache.Invalidator = (*Private)(nil)
+)
+
+// safeMethods are the request methods defined as safe (https://www.rfc-editor.org/rfc/rfc9110#section-9.2.1).
+var safeMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodOptions,
+	http.MethodTrace,
+}
+
+// Invalidate returns the URIs whose stored responses are invalidated by the response to an unsafe request (https://www.rfc-editor.org/rfc/rfc9111#section-4.4).
+func (c *cache) Invalidate(req *http.Request, res *http.Response) []*url.URL {
+	// Because unsafe request methods (Section 9.2.1 of [HTTP]) such as PUT, POST, or DELETE have the potential for changing state on the origin server, intervening caches are required to invalidate stored responses to keep their contents up to date.
+	if contains(req.Method, safeMethods) {
+		return nil
+	}
+	// A cache MUST invalidate the target URI (Section 7.1 of [HTTP]) when it receives a non-error status code in response to an unsafe request method (including methods whose safety is unknown).
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusBadRequest {
+		return nil
+	}
+	target := targetURI(req)
+	urls := []*url.URL{target}
+	// A cache MAY invalidate other URIs when it receives a non-error status code in response to an unsafe request method (including methods whose safety is unknown).
+	// In particular, the URI(s) in the Location and Content-Location response header fields (if present) are candidates for invalidation;
+	// however, a cache MUST NOT trigger an invalidation under these conditions if the origin (Section 4.3.1 of [HTTP]) of the URI to be invalidated differs from that of the target URI (Section 7.1 of [HTTP]).
+	for _, h := range []string{"Location", "Content-Location"} {
+		v := res.Header.Get(h)
+		if v == "" {
+			continue
+		}
+		u, err := target.Parse(v)
+		if err != nil {
+			continue
+		}
+		if !strings.EqualFold(u.Scheme, target.Scheme) || !strings.EqualFold(u.Host, target.Host) {
+			continue
+		}
+		u.Fragment = ""
+		u.RawFragment = ""
+		urls = append(urls, u)
+	}
+	return urls
+}
+
+// targetURI returns the absolute target URI of the request (https://www.rfc-editor.org/rfc/rfc9110#section-7.1).
+func targetURI(req *http.Request) *url.URL {
+	u := *req.URL
+	if u.Host == "" {
+		// The request received by a server has the target URI reconstructed from the Host header field.
+		u.Host = req.Host
+	}
+	if u.Scheme == "" {
+		u.Scheme = "http"
+		if req.TLS != nil {
+			u.Scheme = "https"
+		}
+	}
+	return &u
+}
@@ -0,0 +1,84 @@
+package rfc9111
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestShared_Invalidate(t *testing.T) {
+	endpoint, err := url.Parse("https://example.com/api/v1/users")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		res  *http.Response
+		want []string
+	}{
+		{
+			"GET 200 -> none",
+			&http.Request{Method: http.MethodGet, URL: endpoint},
+			&http.Response{StatusCode: http.StatusOK, Header: http.Header{}},
+			nil,
+		},
+		{
+			"POST 500 -> none",
+			&http.Request{Method: http.MethodPost, URL: endpoint},
+			&http.Response{StatusCode: http.StatusInternalServerError, Header: http.Header{}},
+			nil,
+		},
+		{
+			"POST 201 -> target URI and Location",
+			&http.Request{Method: http.MethodPost, URL: endpoint},
+			&http.Response{StatusCode: http.StatusCreated, Header: http.Header{
+				"Location": []string{"/api/v1/users/1"},
+			}},
+			[]string{"https://example.com/api/v1/users", "https://example.com/api/v1/users/1"},
+		},
+		{
+			"PUT 200 -> target URI and Content-Location",
+			&http.Request{Method: http.MethodPut, URL: endpoint},
+			&http.Response{StatusCode: http.StatusOK, Header: http.Header{
+				"Content-Location": []string{"https://example.com/api/v1/users/2"},
+			}},
+			[]string{"https://example.com/api/v1/users", "https://example.com/api/v1/users/2"},
+		},
+		{
+			"DELETE 204 -> Location with different origin is not invalidated",
+			&http.Request{Method: http.MethodDelete, URL: endpoint},
+			&http.Response{StatusCode: http.StatusNoContent, Header: http.Header{
+				"Location": []string{"https://other.example.com/api/v1/users"},
+			}},
+			[]string{"https://example.com/api/v1/users"},
+		},
+		{
+			"PATCH 200 received by server",
+			&http.Request{Method: http.MethodPatch, URL: &url.URL{Path: "/api/v1/users"}, Host: "example.com"},
+			&http.Response{StatusCode: http.StatusOK, Header: http.Header{
+				"Location": []string{"/api/v1/users/3"},
+			}},
+			[]string{"http://example.com/api/v1/users", "http://example.com/api/v1/users/3"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			s, err := NewShared()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, u := range s.Invalidate(tt.req, tt.res) {
+				got = append(got, u.String())
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Shared.Invalidate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
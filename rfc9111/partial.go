@@ -0,0 +1,167 @@
+package rfc9111
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/k1LoW/httpcache"
+)
+
+var (
+	_ httpcache.Combiner = (*Shared)(nil)
+	_ httpcache.Combiner = (*Private)(nil)
+)
+
+// parseContentRange parses the Content-Range header field value of a single part response with the bytes range unit and the complete length (https://www.rfc-editor.org/rfc/rfc9110#section-14.4).
+func parseContentRange(v string) (byteRange, int64, bool) {
+	unit, resp, ok := strings.Cut(strings.TrimSpace(v), " ")
+	if !ok || !strings.EqualFold(unit, "bytes") {
+		return byteRange{}, 0, false
+	}
+	rg, complete, ok := strings.Cut(resp, "/")
+	if !ok {
+		return byteRange{}, 0, false
+	}
+	first, last, ok := strings.Cut(rg, "-")
+	if !ok {
+		return byteRange{}, 0, false
+	}
+	f, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || f < 0 {
+		return byteRange{}, 0, false
+	}
+	l, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || l < f {
+		return byteRange{}, 0, false
+	}
+	size, err := strconv.ParseInt(complete, 10, 64)
+	if err != nil || size <= l {
+		return byteRange{}, 0, false
+	}
+	return byteRange{first: f, last: l}, size, true
+}
+
+// strongETag returns the entity tag of the response if it is a strong validator (https://www.rfc-editor.org/rfc/rfc9110#section-8.8.1).
+func strongETag(header http.Header) (string, bool) {
+	etag := header.Get("ETag")
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		return "", false
+	}
+	return etag, true
+}
+
+// storablePartial returns true if the partial response can be stored and combined with other partial responses.
+// A cache MUST NOT use an incomplete response to answer requests unless the response has been made complete, or the request is partial and specifies a range wholly within the incomplete response (https://www.rfc-editor.org/rfc/rfc9111#section-3.3).
+// The cache stores a 206 (Partial Content) response only if it has a strong entity tag and a single range of the known complete length,
+// which is required to combine it with other partial responses.
+func storablePartial(res *http.Response) bool {
+	if _, ok := strongETag(res.Header); !ok {
+		return false
+	}
+	if strings.HasPrefix(strings.ToLower(res.Header.Get("Content-Type")), "multipart/byteranges") {
+		return false
+	}
+	_, _, ok := parseContentRange(res.Header.Get("Content-Range"))
+	return ok
+}
+
+// partialRange returns the range requested by the request if it is wholly within the stored partial response,
+// the range of the stored partial response, and the complete length.
+func partialRange(req *http.Request, res *http.Response) (byteRange, byteRange, int64, bool) {
+	if req.Method != http.MethodGet || res.StatusCode != http.StatusPartialContent {
+		return byteRange{}, byteRange{}, 0, false
+	}
+	stored, size, ok := parseContentRange(res.Header.Get("Content-Range"))
+	if !ok {
+		return byteRange{}, byteRange{}, 0, false
+	}
+	specs, ok := parseRange(req.Header.Get("Range"))
+	if !ok || !ifRangeMatches(req.Header.Get("If-Range"), res.Header) {
+		return byteRange{}, byteRange{}, 0, false
+	}
+	ranges := satisfiable(specs, size)
+	if len(ranges) != 1 || ranges[0].first < stored.first || ranges[0].last > stored.last {
+		return byteRange{}, byteRange{}, 0, false
+	}
+	return ranges[0], stored, size, true
+}
+
+// partialResponse returns the response to the range request generated from the stored partial response.
+// It returns res as it is if the requested range is not wholly within the stored partial response.
+func partialResponse(req *http.Request, res *http.Response) *http.Response {
+	rg, stored, size, ok := partialRange(req, res)
+	if !ok {
+		return res
+	}
+	r := *res
+	r.Header = res.Header.Clone()
+	r.Header.Set("Content-Range", rg.contentRange(size))
+	r.Header.Set("Content-Length", strconv.FormatInt(rg.length(), 10))
+	r.ContentLength = rg.length()
+	r.Body = &sectionBody{rc: res.Body, skip: rg.first - stored.first, n: rg.length()}
+	return &r
+}
+
+// Combine combines the stored partial response with the new partial response (https://www.rfc-editor.org/rfc/rfc9111#section-3.4).
+// They are combined only if both have the same strong entity tag and the same complete length, and their ranges overlap or are adjacent.
+// If the combined response is complete, it is a 200 (OK) response.
+func (c *cache) Combine(cachedRes *http.Response, res *http.Response) (*http.Response, bool) {
+	// A cache MUST NOT combine responses unless they have the same strong validator.
+	if cachedRes.StatusCode != http.StatusPartialContent || res.StatusCode != http.StatusPartialContent {
+		return nil, false
+	}
+	if !storablePartial(cachedRes) || !storablePartial(res) {
+		return nil, false
+	}
+	etag, _ := strongETag(cachedRes.Header)
+	if newETag, _ := strongETag(res.Header); etag != newETag {
+		return nil, false
+	}
+	stored, size, _ := parseContentRange(cachedRes.Header.Get("Content-Range"))
+	rg, newSize, _ := parseContentRange(res.Header.Get("Content-Range"))
+	if size != newSize || rg.first > stored.last+1 || stored.first > rg.last+1 {
+		return nil, false
+	}
+
+	// The combined response consists of the stored bytes before the new range, the new range, and the stored bytes after it.
+	combined := byteRange{first: min(stored.first, rg.first), last: max(stored.last, rg.last)}
+	prefix := max(rg.first-stored.first, 0)
+	readers := []io.Reader{&sectionBody{rc: cachedRes.Body, n: prefix}, res.Body}
+	if stored.last > rg.last {
+		readers = append(readers, &sectionBody{rc: cachedRes.Body, skip: rg.last + 1 - stored.first - prefix, n: stored.last - rg.last})
+	}
+
+	// The header fields of the new response are used for the combined response.
+	r := *res
+	r.Header = res.Header.Clone()
+	r.Header.Set("Content-Length", strconv.FormatInt(combined.length(), 10))
+	r.ContentLength = combined.length()
+	r.Body = &combinedBody{Reader: io.MultiReader(readers...), closers: []io.Closer{cachedRes.Body, res.Body}}
+	if combined.first == 0 && combined.last == size-1 {
+		r.StatusCode = http.StatusOK
+		r.Status = statusLine(r.StatusCode)
+		r.Header.Del("Content-Range")
+		return &r, true
+	}
+	r.Header.Set("Content-Range", combined.contentRange(size))
+	return &r, true
+}
+
+// combinedBody is the body of a combined response, which closes the bodies of the responses combined.
+type combinedBody struct {
+	io.Reader
+	closers []io.Closer
+}
+
+// Close implements io.Closer.
+func (b *combinedBody) Close() error {
+	var err error
+	for _, c := range b.closers {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,82 @@
+package rfc9111
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestShared_Combine(t *testing.T) {
+	tests := []struct {
+		name             string
+		cachedRange      string
+		cachedBody       string
+		cachedETag       string
+		newRange         string
+		newBody          string
+		wantOK           bool
+		wantStatusCode   int
+		wantContentRange string
+		wantBody         string
+	}{
+		{"adjacent", "bytes 0-4/10", "01234", `"v1"`, "bytes 5-7/10", "567", true, http.StatusPartialContent, "bytes 0-7/10", "01234567"},
+		{"preceding", "bytes 5-9/10", "56789", `"v1"`, "bytes 2-4/10", "234", true, http.StatusPartialContent, "bytes 2-9/10", "23456789"},
+		{"overlapping", "bytes 2-5/10", "2345", `"v1"`, "bytes 4-7/10", "4567", true, http.StatusPartialContent, "bytes 2-7/10", "234567"},
+		{"within", "bytes 0-9/10", "0123456789", `"v1"`, "bytes 3-4/10", "34", true, http.StatusOK, "", "0123456789"},
+		{"complete", "bytes 0-4/10", "01234", `"v1"`, "bytes 3-9/10", "3456789", true, http.StatusOK, "", "0123456789"},
+		{"disjoint", "bytes 0-2/10", "012", `"v1"`, "bytes 5-7/10", "567", false, 0, "", ""},
+		{"different entity tags", "bytes 0-4/10", "01234", `"v0"`, "bytes 5-9/10", "56789", false, 0, "", ""},
+		{"different complete lengths", "bytes 0-4/11", "01234", `"v1"`, "bytes 5-9/10", "56789", false, 0, "", ""},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			s, err := NewShared()
+			if err != nil {
+				t.Fatal(err)
+			}
+			cachedRes := &http.Response{
+				StatusCode: http.StatusPartialContent,
+				Header: http.Header{
+					"Content-Range": []string{tt.cachedRange},
+					"Etag":          []string{tt.cachedETag},
+				},
+				Body: io.NopCloser(strings.NewReader(tt.cachedBody)),
+			}
+			res := &http.Response{
+				StatusCode: http.StatusPartialContent,
+				Header: http.Header{
+					"Content-Range": []string{tt.newRange},
+					"Etag":          []string{`"v1"`},
+				},
+				Body: io.NopCloser(strings.NewReader(tt.newBody)),
+			}
+			got, gotOK := s.Combine(cachedRes, res)
+			if gotOK != tt.wantOK {
+				t.Fatalf("Shared.Combine() gotOK = %v, want %v", gotOK, tt.wantOK)
+			}
+			if !gotOK {
+				return
+			}
+			b, err := io.ReadAll(got.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			type result struct {
+				StatusCode    int
+				ContentRange  string
+				ContentLength int64
+				Body          string
+			}
+			gotResult := result{got.StatusCode, got.Header.Get("Content-Range"), got.ContentLength, string(b)}
+			want := result{tt.wantStatusCode, tt.wantContentRange, int64(len(tt.wantBody)), tt.wantBody}
+			if diff := cmp.Diff(want, gotResult); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
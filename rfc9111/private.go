@@ -0,0 +1,24 @@
+package rfc9111
+
+import "github.com/k1LoW/httpcache"
+
+var _ httpcache.Handler = (*Private)(nil)
+
+// Private is a private cache that implements RFC 9111.
+// Unlike Shared, it stores responses with the private response directive and responses to requests with the Authorization header field,
+// and ignores the s-maxage and proxy-revalidate response directives.
+type Private struct {
+	cache
+}
+
+// NewPrivate returns a new Private cache handler.
+// It accepts the same options as NewShared.
+func NewPrivate(opts ...SharedOption) (*Private, error) {
+	s, err := NewShared(opts...)
+	if err != nil {
+		return nil, err
+	}
+	p := &Private{cache: s.cache}
+	p.shared = false
+	return p, nil
+}
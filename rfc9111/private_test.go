@@ -0,0 +1,233 @@
+package rfc9111
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPrivate_Storable(t *testing.T) {
+	now := time.Date(2024, 12, 13, 14, 15, 16, 00, time.UTC)
+
+	tests := []struct {
+		name        string
+		req         *http.Request
+		res         *http.Response
+		wantOK      bool
+		wantExpires time.Time
+	}{
+		{
+			"GET 200 Cache-Control: private, max-age=15 -> +15s",
+			&http.Request{
+				Method: http.MethodGet,
+			},
+			&http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Cache-Control": []string{"private, max-age=15"},
+				},
+			},
+			true,
+			time.Date(2024, 12, 13, 14, 15, 31, 00, time.UTC),
+		},
+		{
+			"GET 500 Cache-Control: private, Last-Modified 2024-12-13 14:15:06 -> +1s",
+			&http.Request{
+				Method: http.MethodGet,
+			},
+			&http.Response{
+				StatusCode: http.StatusInternalServerError,
+				Header: http.Header{
+					"Last-Modified": []string{"Mon, 13 Dec 2024 14:15:06 GMT"},
+					"Cache-Control": []string{"private"},
+				},
+			},
+			true,
+			time.Date(2024, 12, 13, 14, 15, 17, 00, time.UTC),
+		},
+		{
+			"GET Authorization: XXX 200 Cache-Control: max-age=15 -> +15s",
+			&http.Request{
+				Method: http.MethodGet,
+				Header: http.Header{
+					"Authorization": []string{"XXX"},
+				},
+			},
+			&http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Cache-Control": []string{"max-age=15"},
+				},
+			},
+			true,
+			time.Date(2024, 12, 13, 14, 15, 31, 00, time.UTC),
+		},
+		{
+			"GET 200 Cache-Control: s-maxage=10, max-age=15 -> +15s",
+			&http.Request{
+				Method: http.MethodGet,
+			},
+			&http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Cache-Control": []string{"s-maxage=10, max-age=15"},
+				},
+			},
+			true,
+			time.Date(2024, 12, 13, 14, 15, 31, 00, time.UTC),
+		},
+		{
+			"GET 500 Cache-Control: s-maxage=10 -> No Store",
+			&http.Request{
+				Method: http.MethodGet,
+			},
+			&http.Response{
+				StatusCode: http.StatusInternalServerError,
+				Header: http.Header{
+					"Cache-Control": []string{"s-maxage=10"},
+				},
+			},
+			false,
+			time.Time{},
+		},
+		{
+			"GET 200 Cache-Control: no-store -> No Store",
+			&http.Request{
+				Method: http.MethodGet,
+			},
+			&http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Cache-Control": []string{"private, no-store"},
+				},
+			},
+			false,
+			time.Time{},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			p, err := NewPrivate()
+			if err != nil {
+				t.Errorf("Private.Storable() error = %v", err)
+				return
+			}
+			gotOK, gotExpires := p.Storable(tt.req, tt.res, now)
+			if gotOK != tt.wantOK {
+				t.Errorf("Private.Storable() gotOK = %v, want %v", gotOK, tt.wantOK)
+			}
+			if !gotExpires.Equal(tt.wantExpires) {
+				t.Errorf("Private.Storable() gotExpires = %v, want %v", gotExpires, tt.wantExpires)
+			}
+		})
+	}
+}
+
+func TestPrivate_Handle(t *testing.T) {
+	now := time.Date(2024, 12, 13, 14, 15, 16, 00, time.UTC)
+	stale := now.Add(-15 * time.Second)
+
+	endpoint, err := url.Parse("https://example.com/api/v1/path/to/resource")
+	if err != nil {
+		t.Fatal(err)
+	}
+	origin200res := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+	}
+	do200 := func(req *http.Request) (*http.Response, error) {
+		return origin200res, nil
+	}
+
+	tests := []struct {
+		name          string
+		reqHeader     http.Header
+		cachedRes     *http.Response
+		wantCacheUsed bool
+		wantRes       *http.Response
+	}{
+		{
+			"Use stale cached response (Cache-Control: proxy-revalidate, request Cache-Control: max-stale)",
+			http.Header{
+				"Cache-Control": []string{"max-stale"},
+			},
+			&http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Date":          []string{stale.Format(http.TimeFormat)},
+					"Cache-Control": []string{"private, max-age=10, proxy-revalidate"},
+				},
+			},
+			true,
+			&http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Age":           []string{"15"},
+					"Date":          []string{stale.Format(http.TimeFormat)},
+					"Cache-Control": []string{"private, max-age=10, proxy-revalidate"},
+				},
+			},
+		},
+		{
+			"Use flesh cached response (Cache-Control: s-maxage=10, max-age=20)",
+			http.Header{},
+			&http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Date":          []string{stale.Format(http.TimeFormat)},
+					"Cache-Control": []string{"s-maxage=10, max-age=20, must-revalidate"},
+				},
+			},
+			true,
+			&http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Age":           []string{"15"},
+					"Date":          []string{stale.Format(http.TimeFormat)},
+					"Cache-Control": []string{"s-maxage=10, max-age=20, must-revalidate"},
+				},
+			},
+		},
+		{
+			"Validate and use origin response (Cache-Control: max-age=10, must-revalidate)",
+			http.Header{},
+			&http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Date":          []string{stale.Format(http.TimeFormat)},
+					"Cache-Control": []string{"max-age=10, must-revalidate"},
+				},
+			},
+			false,
+			origin200res,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewPrivate()
+			if err != nil {
+				t.Errorf("Private.Handle() error = %v", err)
+				return
+			}
+			req := &http.Request{URL: endpoint, Method: http.MethodGet, Header: tt.reqHeader}
+			cachedReq := &http.Request{URL: endpoint, Method: http.MethodGet, Header: http.Header{}}
+			gotCacheUsed, gotRes, err := p.Handle(req, cachedReq, tt.cachedRes, do200, now)
+			if err != nil {
+				t.Errorf("Private.Handle() error = %v", err)
+				return
+			}
+			if gotCacheUsed != tt.wantCacheUsed {
+				t.Errorf("Private.Handle() gotCacheUsed = %v, want %v", gotCacheUsed, tt.wantCacheUsed)
+			}
+			if diff := cmp.Diff(gotRes, tt.wantRes); diff != "" {
+				t.Errorf("Private.Handle() gotRes != tt.wantRes:\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package rfc9111
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/k1LoW/httpcache"
+)
+
+var (
+	_ httpcache.Sanitizer = (*Shared)(nil)
+	_ httpcache.Sanitizer = (*Private)(nil)
+)
+
+// Sanitize returns the header fields of the response to be stored.
+// If the cache is shared, the header fields listed in the qualified private directive are removed (https://www.rfc-editor.org/rfc/rfc9111#section-5.2.2.7).
+func (c *cache) Sanitize(req *http.Request, res *http.Response) http.Header {
+	header := res.Header.Clone()
+	if !c.shared {
+		return header
+	}
+	rescc, _ := c.responseDirectives(res.Header)
+	for _, f := range rescc.PrivateFields {
+		header.Del(f)
+	}
+	return header
+}
+
+// reusedResponse returns a copy of the stored response to be served to the request without validation.
+// The header fields listed in the qualified no-cache directive MUST NOT be sent in the response to a subsequent request without successful revalidation with the origin server (https://www.rfc-editor.org/rfc/rfc9111#section-5.2.2.4).
+// If the cache is shared, the header fields listed in the qualified private directive are also removed, in case they were stored without Sanitize.
+// A range request is satisfied from the stored response, and the response to a HEAD request has no content.
+func (c *cache) reusedResponse(req *http.Request, res *http.Response, age time.Duration, rescc *ResponseDirectives) *http.Response {
+	r := cachedResponse(res, age)
+	for _, f := range rescc.NoCacheFields {
+		r.Header.Del(f)
+	}
+	if c.shared {
+		for _, f := range rescc.PrivateFields {
+			r.Header.Del(f)
+		}
+	}
+	if req.Method == http.MethodHead {
+		if r.Body != nil {
+			_ = r.Body.Close()
+		}
+		r.Body = http.NoBody
+		return r
+	}
+	return rangeResponse(req, r)
+}
@@ -0,0 +1,167 @@
+package rfc9111
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestShared_QualifiedPrivate(t *testing.T) {
+	now := time.Date(2024, 12, 13, 14, 15, 16, 00, time.UTC)
+	tests := []struct {
+		name       string
+		shared     bool
+		rescc      string
+		wantOK     bool
+		wantHeader http.Header
+	}{
+		{
+			"shared: qualified private",
+			true,
+			`max-age=60, private="X-User"`,
+			true,
+			http.Header{"Cache-Control": []string{`max-age=60, private="X-User"`}, "Content-Type": []string{"text/plain"}},
+		},
+		{
+			"shared: unqualified private",
+			true,
+			`max-age=60, private`,
+			false,
+			nil,
+		},
+		{
+			"private: qualified private",
+			false,
+			`private="X-User"`,
+			true,
+			http.Header{"Cache-Control": []string{`private="X-User"`}, "Content-Type": []string{"text/plain"}, "X-User": []string{"alice"}},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var c *cache
+			if tt.shared {
+				s, err := NewShared()
+				if err != nil {
+					t.Fatal(err)
+				}
+				c = &s.cache
+			} else {
+				p, err := NewPrivate(HeuristicExpirationRatio(0.1))
+				if err != nil {
+					t.Fatal(err)
+				}
+				c = &p.cache
+			}
+			req := &http.Request{Method: http.MethodGet, Header: http.Header{}}
+			res := &http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Cache-Control": []string{tt.rescc},
+					"Content-Type":  []string{"text/plain"},
+					"Last-Modified": []string{now.Add(-time.Hour).Format(http.TimeFormat)},
+					"X-User":        []string{"alice"},
+				},
+			}
+			gotOK, _ := c.Storable(req, res, now)
+			if gotOK != tt.wantOK {
+				t.Errorf("Storable() gotOK = %v, want %v", gotOK, tt.wantOK)
+			}
+			if !gotOK {
+				return
+			}
+			got := c.Sanitize(req, res)
+			got.Del("Last-Modified")
+			if diff := cmp.Diff(tt.wantHeader, got); diff != "" {
+				t.Error(diff)
+			}
+			if res.Header.Get("X-User") != "alice" {
+				t.Error("Sanitize() modified the response")
+			}
+		})
+	}
+}
+
+func TestShared_HandleQualifiedNoCache(t *testing.T) {
+	now := time.Date(2024, 12, 13, 14, 15, 16, 00, time.UTC)
+	endpoint, err := url.Parse("https://example.com/api/v1/path/to/resource")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewShared()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &http.Request{URL: endpoint, Method: http.MethodGet, Header: http.Header{}}
+	cachedReq := &http.Request{URL: endpoint, Method: http.MethodGet, Header: http.Header{}}
+	cachedRes := &http.Response{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Cache-Control": []string{`max-age=60, no-cache="Set-Cookie"`},
+			"Date":          []string{now.Format(http.TimeFormat)},
+			"Set-Cookie":    []string{"session=a"},
+		},
+	}
+	do := func(*http.Request) (*http.Response, error) {
+		t.Error("the request is forwarded")
+		return nil, nil
+	}
+	gotCacheUsed, got, err := s.Handle(req, cachedReq, cachedRes, do, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gotCacheUsed {
+		t.Error("Shared.Handle() gotCacheUsed = false, want true")
+	}
+	if got.Header.Get("Set-Cookie") != "" {
+		t.Errorf("got Set-Cookie %q", got.Header.Get("Set-Cookie"))
+	}
+	if cachedRes.Header.Get("Set-Cookie") == "" {
+		t.Error("Shared.Handle() modified the stored response")
+	}
+}
+
+func TestShared_HandleQualifiedPrivate(t *testing.T) {
+	now := time.Date(2024, 12, 13, 14, 15, 16, 00, time.UTC)
+	endpoint, err := url.Parse("https://example.com/api/v1/path/to/resource")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewShared()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &http.Request{URL: endpoint, Method: http.MethodGet, Header: http.Header{}}
+	cachedReq := &http.Request{URL: endpoint, Method: http.MethodGet, Header: http.Header{}}
+	// The stored response may not have been sanitized.
+	cachedRes := &http.Response{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Cache-Control": []string{`max-age=60, private="X-User"`},
+			"Date":          []string{now.Format(http.TimeFormat)},
+			"X-User":        []string{"alice"},
+		},
+	}
+	do := func(*http.Request) (*http.Response, error) {
+		t.Error("the request is forwarded")
+		return nil, nil
+	}
+	gotCacheUsed, got, err := s.Handle(req, cachedReq, cachedRes, do, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gotCacheUsed {
+		t.Error("Shared.Handle() gotCacheUsed = false, want true")
+	}
+	if got.Header.Get("X-User") != "" {
+		t.Errorf("got X-User %q", got.Header.Get("X-User"))
+	}
+	if cachedRes.Header.Get("X-User") == "" {
+		t.Error("Shared.Handle() modified the stored response")
+	}
+}
@@ -0,0 +1,314 @@
+package rfc9111
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxRanges is the maximum number of ranges in the Range header field to which the cache responds with the partial content.
+// A server that supports range requests MAY ignore or reject a Range header field that contains an invalid ranges-specifier, a ranges-specifier with more than two overlapping ranges,
+// or a set of many small ranges that are not listed in ascending order, since these are indications of either a broken client or a deliberate denial-of-service attack (https://www.rfc-editor.org/rfc/rfc9110#section-14.2).
+const maxRanges = 16
+
+// maxRangeBuffer is the maximum number of bytes of a stored body of unknown length read into memory to respond to a range request.
+// If the stored body is larger, the Range header field is ignored and the whole stored response is used.
+const maxRangeBuffer = 8 << 20
+
+// byteRange is a range of bytes of a representation, where the last position is inclusive (https://www.rfc-editor.org/rfc/rfc9110#section-14.1.2).
+type byteRange struct {
+	first int64
+	last  int64
+}
+
+func (r byteRange) length() int64 {
+	return r.last - r.first + 1
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.first, r.last, size)
+}
+
+// rangeSpec is a byte-range-spec or a suffix-range of the Range header field (https://www.rfc-editor.org/rfc/rfc9110#section-14.1.2).
+// first is -1 for a suffix-range, and last is -1 if it is omitted.
+type rangeSpec struct {
+	first int64
+	last  int64
+}
+
+// parseRange parses the Range header field value with the bytes range unit.
+// It returns false if the value is not valid, so that the Range header field is ignored.
+func parseRange(v string) ([]rangeSpec, bool) {
+	unit, set, ok := strings.Cut(v, "=")
+	if !ok || !strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return nil, false
+	}
+	var specs []rangeSpec
+	for _, r := range strings.Split(set, ",") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(r, "-")
+		if !ok {
+			return nil, false
+		}
+		spec := rangeSpec{first: -1, last: -1}
+		if first != "" {
+			f, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || f < 0 {
+				return nil, false
+			}
+			spec.first = f
+		}
+		if last != "" {
+			l, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || l < 0 {
+				return nil, false
+			}
+			spec.last = l
+		}
+		switch {
+		case spec.first < 0 && spec.last < 0:
+			return nil, false
+		case spec.first >= 0 && spec.last >= 0 && spec.last < spec.first:
+			// A byte-range-spec is invalid if the last-pos value is present and less than the first-pos.
+			return nil, false
+		}
+		specs = append(specs, spec)
+	}
+	if len(specs) == 0 {
+		return nil, false
+	}
+	return specs, true
+}
+
+// satisfiable returns the ranges of the specs satisfiable for a representation of size bytes (https://www.rfc-editor.org/rfc/rfc9110#section-14.1.1).
+func satisfiable(specs []rangeSpec, size int64) []byteRange {
+	var ranges []byteRange
+	for _, s := range specs {
+		switch {
+		case s.first < 0:
+			// A suffix-range is satisfiable if its suffix-length is non-zero.
+			if s.last == 0 || size == 0 {
+				continue
+			}
+			ranges = append(ranges, byteRange{first: max(size-s.last, 0), last: size - 1})
+		case s.first < size:
+			// A byte-range-spec is satisfiable if the first-pos is less than the current length of the representation.
+			last := size - 1
+			if s.last >= 0 && s.last < last {
+				last = s.last
+			}
+			ranges = append(ranges, byteRange{first: s.first, last: last})
+		}
+	}
+	return coalesce(ranges)
+}
+
+// coalesce sorts the ranges and merges the ones that overlap or are adjacent.
+// A server MAY coalesce any of the ranges that overlap, or that are separated by a gap that is smaller than the overhead of sending multiple parts,
+// regardless of the order in which the corresponding range-spec appeared in the received Range header field (https://www.rfc-editor.org/rfc/rfc9110#section-14.2).
+func coalesce(ranges []byteRange) []byteRange {
+	if len(ranges) < 2 {
+		return ranges
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].first < ranges[j].first
+	})
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r.first <= last.last+1 {
+			last.last = max(last.last, r.last)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// ifRangeMatches evaluates the If-Range header field against the stored response (https://www.rfc-editor.org/rfc/rfc9110#section-13.1.5).
+func ifRangeMatches(v string, header http.Header) bool {
+	if v == "" {
+		return true
+	}
+	if strings.HasPrefix(v, `"`) || strings.HasPrefix(v, "W/") {
+		// The condition is true if the entity-tag is strong and matches the current entity tag using the strong comparison function.
+		etag := header.Get("ETag")
+		return !strings.HasPrefix(v, "W/") && etag != "" && !strings.HasPrefix(etag, "W/") && v == etag
+	}
+	// The condition is true if the HTTP-date is an exact match of the Last-Modified.
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return false
+	}
+	lm, err := http.ParseTime(header.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+	return t.Equal(lm)
+}
+
+// rangeResponse returns the response to the range request generated from the stored response (https://www.rfc-editor.org/rfc/rfc9110#section-14.2).
+// It returns res as it is if the request is not a range request to which the cache responds with the partial content.
+func rangeResponse(req *http.Request, res *http.Response) *http.Response {
+	if res.StatusCode == http.StatusPartialContent {
+		return partialResponse(req, res)
+	}
+	// A server MUST ignore a Range header field received with a request method that is unrecognized or for which range handling is not defined. For this specification, GET is the only method for which range handling is defined.
+	v := req.Header.Get("Range")
+	if req.Method != http.MethodGet || v == "" || res.StatusCode != http.StatusOK || res.Body == nil {
+		return res
+	}
+	specs, ok := parseRange(v)
+	if !ok || len(specs) > maxRanges {
+		return res
+	}
+	// A server MUST ignore an If-Range header field received in a request that does not contain a Range header field.
+	if !ifRangeMatches(req.Header.Get("If-Range"), res.Header) {
+		return res
+	}
+
+	// The length of a stored response may be unknown to Storage, so it falls back to the stored Content-Length header field.
+	size := res.ContentLength
+	if size < 0 {
+		if n, err := strconv.ParseInt(res.Header.Get("Content-Length"), 10, 64); err == nil && n >= 0 {
+			size = n
+		}
+	}
+	if size < 0 {
+		// Only a body of unknown length is read into memory, up to maxRangeBuffer bytes.
+		b, err := io.ReadAll(io.LimitReader(res.Body, maxRangeBuffer+1))
+		if err != nil {
+			_ = res.Body.Close()
+			r := *res
+			r.Body = io.NopCloser(errReader{err})
+			return &r
+		}
+		if len(b) > maxRangeBuffer {
+			r := *res
+			r.Body = &combinedBody{Reader: io.MultiReader(bytes.NewReader(b), res.Body), closers: []io.Closer{res.Body}}
+			return &r
+		}
+		_ = res.Body.Close()
+		size = int64(len(b))
+		res.Body = io.NopCloser(bytes.NewReader(b))
+	}
+	ranges := satisfiable(specs, size)
+
+	r := *res
+	r.Header = res.Header.Clone()
+	r.Header.Del("Content-Length")
+	r.Header.Set("Accept-Ranges", "bytes")
+	switch len(ranges) {
+	case 0:
+		// If all of the preconditions are true, the server supports the Range header field for the target resource, and the specified range(s) are invalid or unsatisfiable, the server SHOULD send a 416 (Range Not Satisfiable) response.
+		_ = res.Body.Close()
+		r.StatusCode = http.StatusRequestedRangeNotSatisfiable
+		r.Status = statusLine(r.StatusCode)
+		r.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		r.Header.Del("Content-Type")
+		r.Header.Set("Content-Length", "0")
+		r.ContentLength = 0
+		r.Body = http.NoBody
+	case 1:
+		rg := ranges[0]
+		r.StatusCode = http.StatusPartialContent
+		r.Status = statusLine(r.StatusCode)
+		r.Header.Set("Content-Range", rg.contentRange(size))
+		r.Header.Set("Content-Length", strconv.FormatInt(rg.length(), 10))
+		r.ContentLength = rg.length()
+		r.Body = &sectionBody{rc: res.Body, skip: rg.first, n: rg.length()}
+	default:
+		// A server generating a 206 response for multiple ranges uses the multipart/byteranges media type (https://www.rfc-editor.org/rfc/rfc9110#section-14.6).
+		// The ranges are sorted and do not overlap, so the parts are streamed from the stored body in one forward pass.
+		// Only the delimiters and header fields of the parts are generated in memory.
+		buf := &bytes.Buffer{}
+		mw := multipart.NewWriter(buf)
+		ct := res.Header.Get("Content-Type")
+		var (
+			readers []io.Reader
+			length  int64
+			pos     int64
+		)
+		for _, rg := range ranges {
+			h := textproto.MIMEHeader{}
+			if ct != "" {
+				h.Set("Content-Type", ct)
+			}
+			h.Set("Content-Range", rg.contentRange(size))
+			_, _ = mw.CreatePart(h)
+			readers = append(readers, strings.NewReader(buf.String()), &sectionBody{rc: res.Body, skip: rg.first - pos, n: rg.length()})
+			length += int64(buf.Len()) + rg.length()
+			buf.Reset()
+			pos = rg.last + 1
+		}
+		_ = mw.Close()
+		readers = append(readers, strings.NewReader(buf.String()))
+		length += int64(buf.Len())
+		r.StatusCode = http.StatusPartialContent
+		r.Status = statusLine(r.StatusCode)
+		r.Header.Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+		r.Header.Set("Content-Length", strconv.FormatInt(length, 10))
+		r.ContentLength = length
+		r.Body = &combinedBody{Reader: io.MultiReader(readers...), closers: []io.Closer{res.Body}}
+	}
+	return &r
+}
+
+func statusLine(code int) string {
+	return strconv.Itoa(code) + " " + http.StatusText(code)
+}
+
+// sectionBody reads n bytes after skipping the first skip bytes of the body.
+type sectionBody struct {
+	rc      io.ReadCloser
+	skip    int64
+	n       int64
+	skipped bool
+}
+
+// Read implements io.Reader.
+func (b *sectionBody) Read(p []byte) (int, error) {
+	if !b.skipped {
+		if _, err := io.CopyN(io.Discard, b.rc, b.skip); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		b.skipped = true
+	}
+	if b.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > b.n {
+		p = p[:b.n]
+	}
+	n, err := b.rc.Read(p)
+	b.n -= int64(n)
+	if err == io.EOF && b.n > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// Close implements io.Closer.
+func (b *sectionBody) Close() error {
+	return b.rc.Close()
+}
+
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
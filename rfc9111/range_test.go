@@ -0,0 +1,322 @@
+package rfc9111
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestShared_HandleRange(t *testing.T) {
+	now := time.Date(2024, 12, 13, 14, 15, 16, 00, time.UTC)
+	lastModified := now.Add(-time.Hour).Format(http.TimeFormat)
+	endpoint, err := url.Parse("https://example.com/api/v1/path/to/resource")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name             string
+		reqHeader        http.Header
+		wantStatusCode   int
+		wantContentRange string
+		wantBody         string
+	}{
+		{"no range", http.Header{}, http.StatusOK, "", "0123456789"},
+		{"first-last", http.Header{"Range": []string{"bytes=2-5"}}, http.StatusPartialContent, "bytes 2-5/10", "2345"},
+		{"first-", http.Header{"Range": []string{"bytes=7-"}}, http.StatusPartialContent, "bytes 7-9/10", "789"},
+		{"suffix", http.Header{"Range": []string{"bytes=-3"}}, http.StatusPartialContent, "bytes 7-9/10", "789"},
+		{"last beyond length", http.Header{"Range": []string{"bytes=8-100"}}, http.StatusPartialContent, "bytes 8-9/10", "89"},
+		{"unsatisfiable", http.Header{"Range": []string{"bytes=10-"}}, http.StatusRequestedRangeNotSatisfiable, "bytes */10", ""},
+		{"overlapping ranges are coalesced", http.Header{"Range": []string{"bytes=2-5, 0-3"}}, http.StatusPartialContent, "bytes 0-5/10", "012345"},
+		{"adjacent ranges are coalesced", http.Header{"Range": []string{"bytes=4-5, 0-3"}}, http.StatusPartialContent, "bytes 0-5/10", "012345"},
+		{"too many ranges are ignored", http.Header{"Range": []string{"bytes=0-0" + strings.Repeat(", 0-0", maxRanges)}}, http.StatusOK, "", "0123456789"},
+		{"invalid range is ignored", http.Header{"Range": []string{"bytes=5-2"}}, http.StatusOK, "", "0123456789"},
+		{"unknown unit is ignored", http.Header{"Range": []string{"items=0-1"}}, http.StatusOK, "", "0123456789"},
+		{"If-Range: matching entity tag", http.Header{"Range": []string{"bytes=0-1"}, "If-Range": []string{`"v1"`}}, http.StatusPartialContent, "bytes 0-1/10", "01"},
+		{"If-Range: mismatching entity tag", http.Header{"Range": []string{"bytes=0-1"}, "If-Range": []string{`"v2"`}}, http.StatusOK, "", "0123456789"},
+		{"If-Range: weak entity tag", http.Header{"Range": []string{"bytes=0-1"}, "If-Range": []string{`W/"v1"`}}, http.StatusOK, "", "0123456789"},
+		{"If-Range: matching date", http.Header{"Range": []string{"bytes=0-1"}, "If-Range": []string{lastModified}}, http.StatusPartialContent, "bytes 0-1/10", "01"},
+		{"If-Range: mismatching date", http.Header{"Range": []string{"bytes=0-1"}, "If-Range": []string{now.Format(http.TimeFormat)}}, http.StatusOK, "", "0123456789"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			s, err := NewShared()
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := &http.Request{URL: endpoint, Method: http.MethodGet, Header: tt.reqHeader}
+			cachedReq := &http.Request{URL: endpoint, Method: http.MethodGet, Header: http.Header{}}
+			cachedRes := &http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Cache-Control": []string{"max-age=60"},
+					"Content-Type":  []string{"text/plain"},
+					"Date":          []string{now.Format(http.TimeFormat)},
+					"Etag":          []string{`"v1"`},
+					"Last-Modified": []string{lastModified},
+				},
+				ContentLength: 10,
+				Body:          io.NopCloser(strings.NewReader("0123456789")),
+			}
+			do := func(*http.Request) (*http.Response, error) {
+				t.Error("the request is forwarded")
+				return nil, nil
+			}
+			gotCacheUsed, got, err := s.Handle(req, cachedReq, cachedRes, do, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !gotCacheUsed {
+				t.Error("Shared.Handle() gotCacheUsed = false, want true")
+			}
+			if got.StatusCode != tt.wantStatusCode {
+				t.Errorf("got status code %d, want %d", got.StatusCode, tt.wantStatusCode)
+			}
+			if got.Header.Get("Content-Range") != tt.wantContentRange {
+				t.Errorf("got Content-Range %q, want %q", got.Header.Get("Content-Range"), tt.wantContentRange)
+			}
+			b, err := io.ReadAll(got.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.wantBody {
+				t.Errorf("got body %q, want %q", string(b), tt.wantBody)
+			}
+			if got.ContentLength != int64(len(tt.wantBody)) {
+				t.Errorf("got ContentLength %d, want %d", got.ContentLength, len(tt.wantBody))
+			}
+		})
+	}
+}
+
+func TestShared_HandleMultipleRanges(t *testing.T) {
+	now := time.Date(2024, 12, 13, 14, 15, 16, 00, time.UTC)
+	endpoint, err := url.Parse("https://example.com/api/v1/path/to/resource")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewShared()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &http.Request{URL: endpoint, Method: http.MethodGet, Header: http.Header{"Range": []string{"bytes=0-1, -2"}}}
+	cachedReq := &http.Request{URL: endpoint, Method: http.MethodGet, Header: http.Header{}}
+	cachedRes := &http.Response{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Cache-Control": []string{"max-age=60"},
+			"Content-Type":  []string{"text/plain"},
+			"Date":          []string{now.Format(http.TimeFormat)},
+		},
+		ContentLength: -1,
+		Body:          io.NopCloser(strings.NewReader("0123456789")),
+	}
+	do := func(*http.Request) (*http.Response, error) {
+		t.Error("the request is forwarded")
+		return nil, nil
+	}
+	_, got, err := s.Handle(req, cachedReq, cachedRes, do, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.StatusCode != http.StatusPartialContent {
+		t.Errorf("got status code %d, want %d", got.StatusCode, http.StatusPartialContent)
+	}
+	mt, params, err := mime.ParseMediaType(got.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mt != "multipart/byteranges" {
+		t.Errorf("got media type %q", mt)
+	}
+	type part struct {
+		ContentType  string
+		ContentRange string
+		Body         string
+	}
+	var parts []part
+	mr := multipart.NewReader(got.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, part{p.Header.Get("Content-Type"), p.Header.Get("Content-Range"), string(b)})
+	}
+	want := []part{
+		{"text/plain", "bytes 0-1/10", "01"},
+		{"text/plain", "bytes 8-9/10", "89"},
+	}
+	if diff := cmp.Diff(want, parts); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestRangeResponse_StoredContentLength(t *testing.T) {
+	req := &http.Request{Method: http.MethodGet, Header: http.Header{"Range": []string{"bytes=2-5"}}}
+	res := &http.Response{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Content-Length": []string{"10"},
+		},
+		// Storage may not know the length of the stored body.
+		ContentLength: -1,
+		Body:          io.NopCloser(strings.NewReader("0123456789")),
+	}
+	got := rangeResponse(req, res)
+	if _, ok := got.Body.(*sectionBody); !ok {
+		t.Errorf("got body %T, want the section of the stored body", got.Body)
+	}
+	if got.Header.Get("Content-Range") != "bytes 2-5/10" {
+		t.Errorf("got Content-Range %q, want %q", got.Header.Get("Content-Range"), "bytes 2-5/10")
+	}
+	b, err := io.ReadAll(got.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "2345" {
+		t.Errorf("got body %q, want %q", string(b), "2345")
+	}
+}
+
+func TestShared_HandleRangeAfterValidation(t *testing.T) {
+	now := time.Date(2024, 12, 13, 14, 15, 16, 00, time.UTC)
+	stale := now.Add(-time.Minute)
+	endpoint, err := url.Parse("https://example.com/api/v1/path/to/resource")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewShared()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &http.Request{URL: endpoint, Method: http.MethodGet, Header: http.Header{"Range": []string{"bytes=2-5"}}}
+	cachedReq := &http.Request{URL: endpoint, Method: http.MethodGet, Header: http.Header{}}
+	cachedRes := &http.Response{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Cache-Control": []string{"max-age=10"},
+			"Date":          []string{stale.Format(http.TimeFormat)},
+			"Etag":          []string{`"v1"`},
+		},
+		ContentLength: 10,
+		Body:          io.NopCloser(strings.NewReader("0123456789")),
+	}
+	do := func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusNotModified,
+			Header: http.Header{
+				"Cache-Control": []string{"max-age=60"},
+				"Date":          []string{now.Format(http.TimeFormat)},
+				"Etag":          []string{`"v1"`},
+			},
+			Body: http.NoBody,
+		}, nil
+	}
+	gotCacheUsed, got, reason, err := s.HandleWithReason(req, cachedReq, cachedRes, do, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gotCacheUsed || reason != ReasonValidated {
+		t.Errorf("got cacheUsed %v, reason %v", gotCacheUsed, reason)
+	}
+	if got.StatusCode != http.StatusPartialContent {
+		t.Errorf("got status code %d, want %d", got.StatusCode, http.StatusPartialContent)
+	}
+	b, err := io.ReadAll(got.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "2345" {
+		t.Errorf("got body %q, want %q", string(b), "2345")
+	}
+}
+
+func TestRangeResponse_MultipleRangesStreamed(t *testing.T) {
+	req := &http.Request{Method: http.MethodGet, Header: http.Header{"Range": []string{"bytes=6-7, 0-1, 1-2"}}}
+	res := &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Content-Type": []string{"text/plain"}},
+		ContentLength: 10,
+		Body:          io.NopCloser(strings.NewReader("0123456789")),
+	}
+	got := rangeResponse(req, res)
+	if _, ok := got.Body.(*combinedBody); !ok {
+		t.Errorf("got body %T, want the parts streamed from the stored body", got.Body)
+	}
+	b, err := io.ReadAll(got.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ContentLength != int64(len(b)) || got.Header.Get("Content-Length") != strconv.Itoa(len(b)) {
+		t.Errorf("got ContentLength %d and Content-Length %q, want %d", got.ContentLength, got.Header.Get("Content-Length"), len(b))
+	}
+	_, params, err := mime.ParseMediaType(got.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ranges, bodies []string
+	mr := multipart.NewReader(bytes.NewReader(b), params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		pb, err := io.ReadAll(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ranges = append(ranges, p.Header.Get("Content-Range"))
+		bodies = append(bodies, string(pb))
+	}
+	// The ranges are coalesced and sorted.
+	if diff := cmp.Diff([]string{"bytes 0-2/10", "bytes 6-7/10"}, ranges); diff != "" {
+		t.Error(diff)
+	}
+	if diff := cmp.Diff([]string{"012", "67"}, bodies); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestRangeResponse_UnknownLengthTooLarge(t *testing.T) {
+	req := &http.Request{Method: http.MethodGet, Header: http.Header{"Range": []string{"bytes=0-1, 4-5"}}}
+	body := strings.Repeat("a", maxRangeBuffer+1)
+	res := &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{},
+		ContentLength: -1,
+		Body:          io.NopCloser(strings.NewReader(body)),
+	}
+	// The stored body is not read into memory beyond the limit, so the whole stored response is used.
+	got := rangeResponse(req, res)
+	if got.StatusCode != http.StatusOK {
+		t.Errorf("got status code %d, want %d", got.StatusCode, http.StatusOK)
+	}
+	b, err := io.ReadAll(got.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != body {
+		t.Errorf("got body of %d bytes, want %d", len(b), len(body))
+	}
+}
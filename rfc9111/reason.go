@@ -0,0 +1,128 @@
+package rfc9111
+
+// Reason is the reason for a decision of Storable or Handle, which refers to the rule of the specifications applied.
+type Reason int
+
+// Reasons for the decisions of Storable.
+const (
+	// ReasonUnknown is the zero value of Reason.
+	ReasonUnknown Reason = iota
+	// ReasonMethodNotUnderstood means that the request method is not understood by the cache.
+	ReasonMethodNotUnderstood
+	// ReasonRequestNoStore means that the no-store request directive is present.
+	ReasonRequestNoStore
+	// ReasonStatusNotFinal means that the response status code is not final.
+	ReasonStatusNotFinal
+	// ReasonStatusNotUnderstood means that the response status code is not understood by the cache.
+	ReasonStatusNotUnderstood
+	// ReasonNoStore means that the no-store response directive is present.
+	ReasonNoStore
+	// ReasonPrivate means that the private response directive is present in the response to a shared cache.
+	ReasonPrivate
+	// ReasonAuthorization means that the Authorization header field is present in the request to a shared cache without a response directive that explicitly allows shared caching.
+	ReasonAuthorization
+	// ReasonZeroFreshness means that the response is already stale when it is received.
+	ReasonZeroFreshness
+	// ReasonNotCacheable means that the response contains nothing that allows it to be stored.
+	ReasonNotCacheable
+	// ReasonPublic means that the response is stored because of the public response directive.
+	ReasonPublic
+	// ReasonPrivateCache means that the response is stored by a private cache because of the private response directive.
+	ReasonPrivateCache
+	// ReasonExpires means that the response is stored because of the Expires header field.
+	ReasonExpires
+	// ReasonMaxAge means that the response is stored because of the max-age response directive.
+	ReasonMaxAge
+	// ReasonSMaxAge means that the response is stored by a shared cache because of the s-maxage response directive.
+	ReasonSMaxAge
+	// ReasonHeuristic means that the response is stored because its status code is heuristically cacheable.
+	ReasonHeuristic
+)
+
+// Reasons for the decisions of Handle.
+const (
+	// ReasonNoStoredResponse means that no response is stored for the request.
+	ReasonNoStoredResponse Reason = iota + 100
+	// ReasonURIMismatch means that the target URI of the request does not match that of the stored response.
+	ReasonURIMismatch
+	// ReasonMethodMismatch means that the request method associated with the stored response does not allow it to be used for the request.
+	ReasonMethodMismatch
+	// ReasonVaryMismatch means that the request header fields nominated by the Vary header field of the stored response do not match,
+	// or that the caller found no stored variant matching the request (see httpcache.ContextWithVaryMiss).
+	ReasonVaryMismatch
+	// ReasonVaryStar means that the Vary header field of the stored response contains "*", which always fails to match.
+	ReasonVaryStar
+	// ReasonNoCache means that the no-cache response directive is present in the stored response.
+	ReasonNoCache
+	// ReasonPartialMismatch means that the requested range is not wholly within the stored partial response.
+	ReasonPartialMismatch
+	// ReasonFresh means that the stored response is fresh.
+	ReasonFresh
+	// ReasonStaleWhileRevalidate means that the stored response is served stale because of the stale-while-revalidate response directive.
+	ReasonStaleWhileRevalidate
+	// ReasonMaxStale means that the stored response is served stale because of the max-stale request directive.
+	ReasonMaxStale
+	// ReasonStaleIfError means that the stored response is served stale because of the stale-if-error directive.
+	ReasonStaleIfError
+	// ReasonValidated means that the stored response is validated and freshened.
+	ReasonValidated
+	// ReasonNotSelectedForUpdate means that the stored response is not selected to be updated by the 304 (Not Modified) response.
+	ReasonNotSelectedForUpdate
+	// ReasonStale means that the stored response is stale.
+	ReasonStale
+	// ReasonRequestDirectives means that the stored response is fresh, but the request directives do not allow its use.
+	ReasonRequestDirectives
+)
+
+type reasonInfo struct {
+	name    string
+	section string
+}
+
+var reasonInfos = map[Reason]reasonInfo{
+	ReasonUnknown:             {"unknown", ""},
+	ReasonMethodNotUnderstood: {"method-not-understood", "RFC 9111 Section 3"},
+	ReasonRequestNoStore:      {"request-no-store", "RFC 9111 Section 5.2.1.5"},
+	ReasonStatusNotFinal:      {"status-not-final", "RFC 9111 Section 3"},
+	ReasonStatusNotUnderstood: {"status-not-understood", "RFC 9111 Section 3"},
+	ReasonNoStore:             {"no-store", "RFC 9111 Section 5.2.2.5"},
+	ReasonPrivate:             {"private", "RFC 9111 Section 5.2.2.7"},
+	ReasonAuthorization:       {"authorization", "RFC 9111 Section 3.5"},
+	ReasonZeroFreshness:       {"zero-freshness", "RFC 9111 Section 4.2"},
+	ReasonNotCacheable:        {"not-cacheable", "RFC 9111 Section 3"},
+	ReasonPublic:              {"public", "RFC 9111 Section 5.2.2.9"},
+	ReasonPrivateCache:        {"private-cache", "RFC 9111 Section 5.2.2.7"},
+	ReasonExpires:             {"expires", "RFC 9111 Section 5.3"},
+	ReasonMaxAge:              {"max-age", "RFC 9111 Section 5.2.2.1"},
+	ReasonSMaxAge:             {"s-maxage", "RFC 9111 Section 5.2.2.10"},
+	ReasonHeuristic:           {"heuristic", "RFC 9111 Section 4.2.2"},
+
+	ReasonNoStoredResponse:     {"no-stored-response", "RFC 9111 Section 4"},
+	ReasonURIMismatch:          {"uri-mismatch", "RFC 9111 Section 4"},
+	ReasonMethodMismatch:       {"method-mismatch", "RFC 9111 Section 4"},
+	ReasonVaryMismatch:         {"vary-mismatch", "RFC 9111 Section 4.1"},
+	ReasonVaryStar:             {"vary-star", "RFC 9111 Section 4.1"},
+	ReasonNoCache:              {"no-cache", "RFC 9111 Section 5.2.2.4"},
+	ReasonPartialMismatch:      {"partial-mismatch", "RFC 9111 Section 3.3"},
+	ReasonFresh:                {"fresh", "RFC 9111 Section 4.2"},
+	ReasonStaleWhileRevalidate: {"stale-while-revalidate", "RFC 5861 Section 3"},
+	ReasonMaxStale:             {"max-stale", "RFC 9111 Section 5.2.1.2"},
+	ReasonStaleIfError:         {"stale-if-error", "RFC 5861 Section 4"},
+	ReasonValidated:            {"validated", "RFC 9111 Section 4.3.4"},
+	ReasonNotSelectedForUpdate: {"not-selected-for-update", "RFC 9111 Section 4.3.4"},
+	ReasonStale:                {"stale", "RFC 9111 Section 4.3"},
+	ReasonRequestDirectives:    {"request-directives", "RFC 9111 Section 5.2.1"},
+}
+
+// String returns the name of the reason, such as "no-store".
+func (r Reason) String() string {
+	if i, ok := reasonInfos[r]; ok {
+		return i.name
+	}
+	return "unknown"
+}
+
+// Section returns the reference to the section of the specification that defines the rule, such as "RFC 9111 Section 5.2.2.5".
+func (r Reason) Section() string {
+	return reasonInfos[r].section
+}
@@ -0,0 +1,254 @@
+package rfc9111
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestShared_StorableWithReason(t *testing.T) {
+	now := time.Date(2024, 12, 13, 14, 15, 16, 00, time.UTC)
+	tests := []struct {
+		name       string
+		method     string
+		reqHeader  http.Header
+		statusCode int
+		resHeader  http.Header
+		wantOK     bool
+		wantReason Reason
+	}{
+		{"method not understood", "PROPFIND", http.Header{}, http.StatusOK, http.Header{"Cache-Control": []string{"max-age=60"}}, false, ReasonMethodNotUnderstood},
+		{"request no-store", http.MethodGet, http.Header{"Cache-Control": []string{"no-store"}}, http.StatusOK, http.Header{"Cache-Control": []string{"max-age=60"}}, false, ReasonRequestNoStore},
+		{"status not final", http.MethodGet, http.Header{}, http.StatusEarlyHints, http.Header{"Cache-Control": []string{"max-age=60"}}, false, ReasonStatusNotFinal},
+		{"status not understood", http.MethodGet, http.Header{}, http.StatusNotModified, http.Header{"Cache-Control": []string{"max-age=60"}}, false, ReasonStatusNotUnderstood},
+		{"no-store", http.MethodGet, http.Header{}, http.StatusOK, http.Header{"Cache-Control": []string{"max-age=60, no-store"}}, false, ReasonNoStore},
+		{"private", http.MethodGet, http.Header{}, http.StatusOK, http.Header{"Cache-Control": []string{"max-age=60, private"}}, false, ReasonPrivate},
+		{"authorization", http.MethodGet, http.Header{"Authorization": []string{"Bearer token"}}, http.StatusOK, http.Header{"Cache-Control": []string{"max-age=60"}}, false, ReasonAuthorization},
+		{"zero freshness", http.MethodGet, http.Header{}, http.StatusOK, http.Header{"Cache-Control": []string{"max-age=0"}}, false, ReasonZeroFreshness},
+		{"public", http.MethodGet, http.Header{}, http.StatusOK, http.Header{"Cache-Control": []string{"public, max-age=60"}}, true, ReasonPublic},
+		{"max-age", http.MethodGet, http.Header{}, http.StatusOK, http.Header{"Cache-Control": []string{"max-age=60"}}, true, ReasonMaxAge},
+		{"s-maxage", http.MethodGet, http.Header{}, http.StatusOK, http.Header{"Cache-Control": []string{"s-maxage=60"}}, true, ReasonSMaxAge},
+		{"heuristic", http.MethodGet, http.Header{}, http.StatusOK, http.Header{"Last-Modified": []string{"Fri, 13 Dec 2024 14:00:00 GMT"}}, true, ReasonHeuristic},
+		{"not cacheable", http.MethodPost, http.Header{}, http.StatusCreated, http.Header{"Last-Modified": []string{"Fri, 13 Dec 2024 14:00:00 GMT"}}, false, ReasonNotCacheable},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			s, err := NewShared()
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := &http.Request{Method: tt.method, Header: tt.reqHeader}
+			res := &http.Response{StatusCode: tt.statusCode, Header: tt.resHeader}
+			gotOK, _, gotReason := s.StorableWithReason(req, res, now)
+			if gotOK != tt.wantOK {
+				t.Errorf("Shared.StorableWithReason() gotOK = %v, want %v", gotOK, tt.wantOK)
+			}
+			if gotReason != tt.wantReason {
+				t.Errorf("Shared.StorableWithReason() gotReason = %v, want %v", gotReason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestShared_HandleWithReason(t *testing.T) {
+	now := time.Date(2024, 12, 13, 14, 15, 16, 00, time.UTC)
+	endpoint, err := url.Parse("https://example.com/api/v1/path/to/resource")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := url.Parse("https://example.com/api/v1/path/to/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name          string
+		req           *http.Request
+		cachedReq     *http.Request
+		cachedHeader  http.Header
+		originStatus  int
+		wantCacheUsed bool
+		wantReason    Reason
+	}{
+		{
+			"no stored response",
+			&http.Request{Method: http.MethodGet, URL: endpoint, Header: http.Header{}},
+			nil,
+			nil,
+			http.StatusOK,
+			false,
+			ReasonNoStoredResponse,
+		},
+		{
+			"method not understood",
+			&http.Request{Method: "PROPFIND", URL: endpoint, Header: http.Header{}},
+			&http.Request{Method: "PROPFIND", URL: endpoint, Header: http.Header{}},
+			http.Header{"Cache-Control": []string{"max-age=60"}},
+			http.StatusOK,
+			false,
+			ReasonMethodNotUnderstood,
+		},
+		{
+			"uri mismatch",
+			&http.Request{Method: http.MethodGet, URL: endpoint, Header: http.Header{}},
+			&http.Request{Method: http.MethodGet, URL: other, Header: http.Header{}},
+			http.Header{"Cache-Control": []string{"max-age=60"}},
+			http.StatusOK,
+			false,
+			ReasonURIMismatch,
+		},
+		{
+			"method mismatch",
+			&http.Request{Method: http.MethodGet, URL: endpoint, Header: http.Header{}},
+			&http.Request{Method: http.MethodHead, URL: endpoint, Header: http.Header{}},
+			http.Header{"Cache-Control": []string{"max-age=60"}},
+			http.StatusOK,
+			false,
+			ReasonMethodMismatch,
+		},
+		{
+			"unsafe method",
+			&http.Request{Method: http.MethodPost, URL: endpoint, Header: http.Header{}},
+			&http.Request{Method: http.MethodPost, URL: endpoint, Header: http.Header{}},
+			http.Header{"Cache-Control": []string{"max-age=60"}},
+			http.StatusOK,
+			false,
+			ReasonMethodMismatch,
+		},
+		{
+			"vary mismatch",
+			&http.Request{Method: http.MethodGet, URL: endpoint, Header: http.Header{"Accept": []string{"text/html"}}},
+			&http.Request{Method: http.MethodGet, URL: endpoint, Header: http.Header{"Accept": []string{"text/plain"}}},
+			http.Header{"Cache-Control": []string{"max-age=60"}, "Vary": []string{"Accept"}},
+			http.StatusOK,
+			false,
+			ReasonVaryMismatch,
+		},
+		{
+			"vary star",
+			&http.Request{Method: http.MethodGet, URL: endpoint, Header: http.Header{}},
+			&http.Request{Method: http.MethodGet, URL: endpoint, Header: http.Header{}},
+			http.Header{"Cache-Control": []string{"max-age=60"}, "Vary": []string{"*"}},
+			http.StatusOK,
+			false,
+			ReasonVaryStar,
+		},
+		{
+			"no-cache",
+			&http.Request{Method: http.MethodGet, URL: endpoint, Header: http.Header{}},
+			&http.Request{Method: http.MethodGet, URL: endpoint, Header: http.Header{}},
+			http.Header{"Cache-Control": []string{"max-age=60, no-cache"}},
+			http.StatusOK,
+			false,
+			ReasonNoCache,
+		},
+		{
+			"fresh",
+			&http.Request{Method: http.MethodGet, URL: endpoint, Header: http.Header{}},
+			&http.Request{Method: http.MethodGet, URL: endpoint, Header: http.Header{}},
+			http.Header{"Cache-Control": []string{"max-age=60"}},
+			http.StatusOK,
+			true,
+			ReasonFresh,
+		},
+		{
+			"request directives",
+			&http.Request{Method: http.MethodGet, URL: endpoint, Header: http.Header{"Cache-Control": []string{"no-cache"}}},
+			&http.Request{Method: http.MethodGet, URL: endpoint, Header: http.Header{}},
+			http.Header{"Cache-Control": []string{"max-age=60"}},
+			http.StatusOK,
+			false,
+			ReasonRequestDirectives,
+		},
+		{
+			"stale",
+			&http.Request{Method: http.MethodGet, URL: endpoint, Header: http.Header{}},
+			&http.Request{Method: http.MethodGet, URL: endpoint, Header: http.Header{}},
+			http.Header{"Cache-Control": []string{"max-age=0, must-revalidate"}},
+			http.StatusOK,
+			false,
+			ReasonStale,
+		},
+		{
+			"stale-while-revalidate",
+			&http.Request{Method: http.MethodGet, URL: endpoint, Header: http.Header{"Cache-Control": []string{"only-if-cached"}}},
+			&http.Request{Method: http.MethodGet, URL: endpoint, Header: http.Header{}},
+			http.Header{"Cache-Control": []string{"max-age=0, stale-while-revalidate=60"}},
+			http.StatusOK,
+			true,
+			ReasonStaleWhileRevalidate,
+		},
+		{
+			"max-stale",
+			&http.Request{Method: http.MethodGet, URL: endpoint, Header: http.Header{"Cache-Control": []string{"max-stale=60"}}},
+			&http.Request{Method: http.MethodGet, URL: endpoint, Header: http.Header{}},
+			http.Header{"Cache-Control": []string{"max-age=0"}},
+			http.StatusOK,
+			true,
+			ReasonMaxStale,
+		},
+		{
+			"stale-if-error",
+			&http.Request{Method: http.MethodGet, URL: endpoint, Header: http.Header{"Cache-Control": []string{"max-age=0"}}},
+			&http.Request{Method: http.MethodGet, URL: endpoint, Header: http.Header{}},
+			http.Header{"Cache-Control": []string{"max-age=0, stale-if-error=60"}},
+			http.StatusServiceUnavailable,
+			true,
+			ReasonStaleIfError,
+		},
+		{
+			"validated",
+			&http.Request{Method: http.MethodGet, URL: endpoint, Header: http.Header{}},
+			&http.Request{Method: http.MethodGet, URL: endpoint, Header: http.Header{}},
+			http.Header{"Cache-Control": []string{"max-age=0, must-revalidate"}},
+			http.StatusNotModified,
+			true,
+			ReasonValidated,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			s, err := NewShared()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var cachedRes *http.Response
+			if tt.cachedHeader != nil {
+				header := tt.cachedHeader.Clone()
+				header.Set("Date", now.Format(http.TimeFormat))
+				cachedRes = &http.Response{StatusCode: http.StatusOK, Header: header, Body: http.NoBody}
+			}
+			do := func(req *http.Request) (*http.Response, error) {
+				return &http.Response{StatusCode: tt.originStatus, Header: http.Header{}, Body: http.NoBody, Request: req}, nil
+			}
+			gotCacheUsed, _, gotReason, err := s.HandleWithReason(tt.req, tt.cachedReq, cachedRes, do, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotCacheUsed != tt.wantCacheUsed {
+				t.Errorf("Shared.HandleWithReason() gotCacheUsed = %v, want %v", gotCacheUsed, tt.wantCacheUsed)
+			}
+			if gotReason != tt.wantReason {
+				t.Errorf("Shared.HandleWithReason() gotReason = %v, want %v", gotReason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestReason(t *testing.T) {
+	for r := range reasonInfos {
+		if r == ReasonUnknown {
+			continue
+		}
+		if r.String() == "unknown" || r.Section() == "" {
+			t.Errorf("reason %d has no name or section", int(r))
+		}
+	}
+	if got := Reason(-1).String(); got != "unknown" {
+		t.Errorf("got %q, want %q", got, "unknown")
+	}
+}
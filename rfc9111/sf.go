@@ -0,0 +1,345 @@
+package rfc9111
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// errInvalidStructuredField is returned when a field value fails to parse as a Structured Field (https://www.rfc-editor.org/rfc/rfc8941).
+var errInvalidStructuredField = errors.New("invalid structured field value")
+
+// sfToken is a Token of Structured Fields (https://www.rfc-editor.org/rfc/rfc8941#section-3.3.4).
+type sfToken string
+
+// sfParam is a parameter of Structured Fields (https://www.rfc-editor.org/rfc/rfc8941#section-3.1.2).
+type sfParam struct {
+	key   string
+	value any
+}
+
+// sfMember is a member of a Dictionary of Structured Fields (https://www.rfc-editor.org/rfc/rfc8941#section-3.2).
+// value is a bare item (int64, float64, string, sfToken, []byte or bool) or an Inner List ([]sfInnerItem).
+type sfMember struct {
+	key    string
+	value  any
+	params []sfParam
+}
+
+// sfInnerItem is an item of an Inner List of Structured Fields (https://www.rfc-editor.org/rfc/rfc8941#section-3.1.1).
+type sfInnerItem struct {
+	value  any
+	params []sfParam
+}
+
+// sfParser parses a field value as Structured Fields (https://www.rfc-editor.org/rfc/rfc8941#section-4.2).
+type sfParser struct {
+	s   string
+	pos int
+}
+
+// parseSFDictionary parses field values as a Dictionary (https://www.rfc-editor.org/rfc/rfc8941#section-4.2.2).
+// Multiple field lines are combined with commas before parsing (https://www.rfc-editor.org/rfc/rfc8941#section-4.2).
+// When a key appears more than once, the last value is used.
+func parseSFDictionary(values []string) ([]sfMember, error) {
+	p := &sfParser{s: strings.Join(values, ",")}
+	p.skipSP()
+	var members []sfMember
+	for !p.eof() {
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		m := sfMember{key: key, value: true}
+		if p.peek() == '=' {
+			p.pos++
+			v, params, err := p.parseItemOrInnerList()
+			if err != nil {
+				return nil, err
+			}
+			m.value, m.params = v, params
+		} else {
+			params, err := p.parseParameters()
+			if err != nil {
+				return nil, err
+			}
+			m.params = params
+		}
+		members = setSFMember(members, m)
+		p.skipOWS()
+		if p.eof() {
+			return members, nil
+		}
+		if p.peek() != ',' {
+			return nil, errInvalidStructuredField
+		}
+		p.pos++
+		p.skipOWS()
+		if p.eof() {
+			// Trailing comma.
+			return nil, errInvalidStructuredField
+		}
+	}
+	return members, nil
+}
+
+func setSFMember(members []sfMember, m sfMember) []sfMember {
+	for i := range members {
+		if members[i].key == m.key {
+			members[i] = m
+			return members
+		}
+	}
+	return append(members, m)
+}
+
+func (p *sfParser) eof() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *sfParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *sfParser) skipSP() {
+	for p.peek() == ' ' {
+		p.pos++
+	}
+}
+
+func (p *sfParser) skipOWS() {
+	for p.peek() == ' ' || p.peek() == '\t' {
+		p.pos++
+	}
+}
+
+func (p *sfParser) parseItemOrInnerList() (any, []sfParam, error) {
+	if p.peek() == '(' {
+		return p.parseInnerList()
+	}
+	v, err := p.parseBareItem()
+	if err != nil {
+		return nil, nil, err
+	}
+	params, err := p.parseParameters()
+	if err != nil {
+		return nil, nil, err
+	}
+	return v, params, nil
+}
+
+func (p *sfParser) parseInnerList() (any, []sfParam, error) {
+	p.pos++ // (
+	var items []sfInnerItem
+	for !p.eof() {
+		p.skipSP()
+		if p.peek() == ')' {
+			p.pos++
+			params, err := p.parseParameters()
+			if err != nil {
+				return nil, nil, err
+			}
+			return items, params, nil
+		}
+		v, err := p.parseBareItem()
+		if err != nil {
+			return nil, nil, err
+		}
+		params, err := p.parseParameters()
+		if err != nil {
+			return nil, nil, err
+		}
+		items = append(items, sfInnerItem{value: v, params: params})
+		if c := p.peek(); c != ' ' && c != ')' {
+			return nil, nil, errInvalidStructuredField
+		}
+	}
+	return nil, nil, errInvalidStructuredField
+}
+
+func (p *sfParser) parseParameters() ([]sfParam, error) {
+	var params []sfParam
+	for p.peek() == ';' {
+		p.pos++
+		p.skipSP()
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		var v any = true
+		if p.peek() == '=' {
+			p.pos++
+			v, err = p.parseBareItem()
+			if err != nil {
+				return nil, err
+			}
+		}
+		replaced := false
+		for i := range params {
+			if params[i].key == key {
+				params[i].value = v
+				replaced = true
+			}
+		}
+		if !replaced {
+			params = append(params, sfParam{key: key, value: v})
+		}
+	}
+	return params, nil
+}
+
+func (p *sfParser) parseKey() (string, error) {
+	c := p.peek()
+	if !isLCAlpha(c) && c != '*' {
+		return "", errInvalidStructuredField
+	}
+	start := p.pos
+	for !p.eof() {
+		c := p.peek()
+		if !isLCAlpha(c) && !isDigit(c) && !strings.ContainsRune("_-.*", rune(c)) {
+			break
+		}
+		p.pos++
+	}
+	return p.s[start:p.pos], nil
+}
+
+func (p *sfParser) parseBareItem() (any, error) {
+	c := p.peek()
+	switch {
+	case c == '-' || isDigit(c):
+		return p.parseNumber()
+	case c == '"':
+		return p.parseString()
+	case c == '*' || isAlpha(c):
+		return p.parseToken(), nil
+	case c == ':':
+		return p.parseByteSequence()
+	case c == '?':
+		return p.parseBoolean()
+	default:
+		return nil, errInvalidStructuredField
+	}
+}
+
+func (p *sfParser) parseNumber() (any, error) {
+	start := p.pos
+	if p.peek() == '-' {
+		p.pos++
+	}
+	if !isDigit(p.peek()) {
+		return nil, errInvalidStructuredField
+	}
+	decimal := false
+	for !p.eof() {
+		c := p.peek()
+		if c == '.' && !decimal {
+			decimal = true
+			p.pos++
+			continue
+		}
+		if !isDigit(c) {
+			break
+		}
+		p.pos++
+	}
+	num := p.s[start:p.pos]
+	if !decimal {
+		if len(strings.TrimPrefix(num, "-")) > 15 {
+			return nil, errInvalidStructuredField
+		}
+		return strconv.ParseInt(num, 10, 64)
+	}
+	i, f, _ := strings.Cut(strings.TrimPrefix(num, "-"), ".")
+	if len(i) > 12 || len(f) == 0 || len(f) > 3 {
+		return nil, errInvalidStructuredField
+	}
+	return strconv.ParseFloat(num, 64)
+}
+
+func (p *sfParser) parseString() (any, error) {
+	p.pos++ // "
+	var b strings.Builder
+	for !p.eof() {
+		c := p.s[p.pos]
+		p.pos++
+		switch {
+		case c == '\\':
+			if p.eof() {
+				return nil, errInvalidStructuredField
+			}
+			next := p.s[p.pos]
+			p.pos++
+			if next != '"' && next != '\\' {
+				return nil, errInvalidStructuredField
+			}
+			b.WriteByte(next)
+		case c == '"':
+			return b.String(), nil
+		case c < 0x20 || c > 0x7e:
+			return nil, errInvalidStructuredField
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return nil, errInvalidStructuredField
+}
+
+func (p *sfParser) parseToken() any {
+	start := p.pos
+	p.pos++
+	for !p.eof() && (isTChar(p.peek()) || p.peek() == ':' || p.peek() == '/') {
+		p.pos++
+	}
+	return sfToken(p.s[start:p.pos])
+}
+
+func (p *sfParser) parseByteSequence() (any, error) {
+	p.pos++ // :
+	end := strings.IndexByte(p.s[p.pos:], ':')
+	if end < 0 {
+		return nil, errInvalidStructuredField
+	}
+	encoded := p.s[p.pos : p.pos+end]
+	p.pos += end + 1
+	b, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errInvalidStructuredField
+	}
+	return b, nil
+}
+
+func (p *sfParser) parseBoolean() (any, error) {
+	p.pos++ // ?
+	switch p.peek() {
+	case '1':
+		p.pos++
+		return true, nil
+	case '0':
+		p.pos++
+		return false, nil
+	default:
+		return nil, errInvalidStructuredField
+	}
+}
+
+func isLCAlpha(c byte) bool {
+	return c >= 'a' && c <= 'z'
+}
+
+func isAlpha(c byte) bool {
+	return isLCAlpha(c) || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// isTChar reports whether c is a tchar (https://www.rfc-editor.org/rfc/rfc9110#section-5.6.2).
+func isTChar(c byte) bool {
+	return isAlpha(c) || isDigit(c) || strings.ContainsRune("!#$%&'*+-.^_`|~", rune(c))
+}
@@ -1,8 +1,9 @@
 package rfc9111
 
 import (
+	"context"
+	"errors"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/k1LoW/httpcache"
@@ -11,14 +12,24 @@ import (
 var _ httpcache.Handler = (*Shared)(nil)
 
 // Shared is a shared cache that implements RFC 9111.
-// The following features are not implemented
-// - Private cache.
-// - Request directives.
 type Shared struct {
+	cache
+}
+
+// cache implements the rules of RFC 9111 common to shared and private caches.
+type cache struct {
+	// shared is true if the cache is shared (https://www.rfc-editor.org/rfc/rfc9111#section-1).
+	shared                            bool
 	understoodMethods                 []string
 	understoodStatusCodes             []int
 	heuristicallyCacheableStatusCodes []int
 	heuristicExpirationRatio          float64
+	maxHeuristicLifetime              time.Duration
+	minHeuristicLifetime              time.Duration
+	heuristicLifetimes                map[int]time.Duration
+	cacheStatusIdentifier             string
+	targetedFields                    []string
+	key                               httpcache.KeyFunc
 }
 
 // SharedOption is an option for Shared.
@@ -32,10 +43,92 @@ func HeuristicExpirationRatio(ratio float64) SharedOption {
 	}
 }
 
+// HeuristicallyCacheableStatusCodes sets the status codes that are heuristically cacheable (https://www.rfc-editor.org/rfc/rfc9111#section-4.2.2).
+// The default is the status codes defined as heuristically cacheable in https://www.rfc-editor.org/rfc/rfc9110#section-15.1.
+func HeuristicallyCacheableStatusCodes(codes ...int) SharedOption {
+	return func(s *Shared) error {
+		s.heuristicallyCacheableStatusCodes = append([]int(nil), codes...)
+		return nil
+	}
+}
+
+// UnderstoodMethods sets the request methods understood by the cache (https://www.rfc-editor.org/rfc/rfc9111#section-3).
+// Responses to requests with other methods are not stored.
+func UnderstoodMethods(methods ...string) SharedOption {
+	return func(s *Shared) error {
+		s.understoodMethods = append([]string(nil), methods...)
+		return nil
+	}
+}
+
+// UnderstoodStatusCodes sets the response status codes understood by the cache.
+// Responses with the must-understand directive and other status codes are not stored (https://www.rfc-editor.org/rfc/rfc9111#section-5.2.2.3).
+func UnderstoodStatusCodes(codes ...int) SharedOption {
+	return func(s *Shared) error {
+		s.understoodStatusCodes = append([]int(nil), codes...)
+		return nil
+	}
+}
+
+// MaxHeuristicLifetime sets the maximum heuristic freshness lifetime calculated with HeuristicExpirationRatio. 0 means no limit.
+func MaxHeuristicLifetime(d time.Duration) SharedOption {
+	return func(s *Shared) error {
+		if d < 0 {
+			return errors.New("max heuristic lifetime must not be negative")
+		}
+		s.maxHeuristicLifetime = d
+		return nil
+	}
+}
+
+// MinHeuristicLifetime sets the minimum heuristic freshness lifetime, which is used even if the response has no Last-Modified header field.
+// Responses with an explicit expiration time are not affected. It must not be greater than MaxHeuristicLifetime.
+func MinHeuristicLifetime(d time.Duration) SharedOption {
+	return func(s *Shared) error {
+		if d < 0 {
+			return errors.New("min heuristic lifetime must not be negative")
+		}
+		s.minHeuristicLifetime = d
+		return nil
+	}
+}
+
+// HeuristicLifetime sets the heuristic freshness lifetime of responses with the status code, instead of that calculated with HeuristicExpirationRatio.
+// The status code is treated as heuristically cacheable. Responses with an explicit expiration time are not affected.
+func HeuristicLifetime(statusCode int, d time.Duration) SharedOption {
+	return func(s *Shared) error {
+		if d < 0 {
+			return errors.New("heuristic lifetime must not be negative")
+		}
+		if s.heuristicLifetimes == nil {
+			s.heuristicLifetimes = map[int]time.Duration{}
+		}
+		s.heuristicLifetimes[statusCode] = d
+		return nil
+	}
+}
+
+// CacheKey sets the KeyFunc to compare the target URIs of requests. The default is httpcache.DefaultKey.
+// It is not used if the request carries the cache key with which the stored response is looked up (see httpcache.ContextWithCacheKey),
+// as the requests passed by httpcache.Transport and httpcache.NewMiddleware do.
+func CacheKey(f httpcache.KeyFunc) SharedOption {
+	return func(s *Shared) error {
+		if f == nil {
+			return errors.New("nil KeyFunc")
+		}
+		s.key = f
+		return nil
+	}
+}
+
 // NewShared returns a new Shared cache handler.
 func NewShared(opts ...SharedOption) (*Shared, error) {
 	s := &Shared{
-		heuristicExpirationRatio: defaultHeuristicExpirationRatio,
+		cache: cache{
+			shared:                   true,
+			heuristicExpirationRatio: defaultHeuristicExpirationRatio,
+			key:                      httpcache.DefaultKey,
+		},
 	}
 
 	um := make([]string, len(defaultUnderstoodMethods))
@@ -55,16 +148,31 @@ func NewShared(opts ...SharedOption) (*Shared, error) {
 			return nil, err
 		}
 	}
+	if s.maxHeuristicLifetime > 0 && s.minHeuristicLifetime > s.maxHeuristicLifetime {
+		return nil, errors.New("min heuristic lifetime must not be greater than max heuristic lifetime")
+	}
 
 	return s, nil
 }
 
 // Storable returns true if the response is storable in the cache.
-func (s *Shared) Storable(req *http.Request, res *http.Response, now time.Time) (bool, time.Time) {
+func (c *cache) Storable(req *http.Request, res *http.Response, now time.Time) (bool, time.Time) {
+	ok, expires, _ := c.StorableWithReason(req, res, now)
+	return ok, expires
+}
+
+// StorableWithReason is the same as Storable, but also returns the reason for the decision.
+func (c *cache) StorableWithReason(req *http.Request, res *http.Response, now time.Time) (bool, time.Time, Reason) {
 	// 3. Storing Responses in Caches (https://www.rfc-editor.org/rfc/rfc9111#section-3)
 	// - the request method is understood by the cache;
-	if !contains(req.Method, s.understoodMethods) {
-		return false, time.Time{}
+	if !contains(req.Method, c.understoodMethods) {
+		return false, time.Time{}, ReasonMethodNotUnderstood
+	}
+
+	// The no-store request directive indicates that a cache MUST NOT store any part of either this request or any response to it (https://www.rfc-editor.org/rfc/rfc9111#section-5.2.1.5).
+	reqcc := ParseRequestCacheControlHeader(req.Header.Values("Cache-Control"))
+	if reqcc.NoStore {
+		return false, time.Time{}, ReasonRequestNoStore
 	}
 
 	// - the response status code is final (see https://www.rfc-editor.org/rfc/rfc9110#section-15);
@@ -74,74 +182,118 @@ func (s *Shared) Storable(req *http.Request, res *http.Response, now time.Time)
 		http.StatusProcessing,
 		http.StatusEarlyHints,
 	}) {
-		return false, time.Time{}
+		return false, time.Time{}, ReasonStatusNotFinal
 	}
 
-	rescc := ParseResponseCacheControlHeader(res.Header.Values("Cache-Control"))
+	rescc, header := c.responseDirectives(res.Header)
 
 	// - if the response status code is 206 or 304, or the must-understand cache directive (see https://www.rfc-editor.org/rfc/rfc9111#section-5.2.2.3) is present: the cache understands the response status code;
-	if contains(res.StatusCode, []int{
-		http.StatusPartialContent,
-		http.StatusNotModified,
-	}) || (rescc.MustUnderstand && !contains(res.StatusCode, s.understoodStatusCodes)) {
-		return false, time.Time{}
+	// The cache understands a 206 (Partial Content) response only if it can be combined (https://www.rfc-editor.org/rfc/rfc9111#section-3.3).
+	if res.StatusCode == http.StatusNotModified || (res.StatusCode == http.StatusPartialContent && !storablePartial(res)) ||
+		(rescc.MustUnderstand && !contains(res.StatusCode, c.understoodStatusCodes)) {
+		return false, time.Time{}, ReasonStatusNotUnderstood
 	}
 
 	// - the no-store cache directive is not present in the response (see https://www.rfc-editor.org/rfc/rfc9111#section-5.2.2.5);
 	if rescc.NoStore {
-		return false, time.Time{}
+		return false, time.Time{}, ReasonNoStore
 	}
 
 	// - if the cache is shared: the private response directive is either not present or allows a shared cache to store a modified response; see https://www.rfc-editor.org/rfc/rfc9111#section-5.2.2.7);
-	if rescc.Private {
-		return false, time.Time{}
+	// The qualified private directive allows it, and Sanitize removes the listed header fields.
+	if c.shared && rescc.Private {
+		return false, time.Time{}, ReasonPrivate
 	}
 
 	// - if the cache is shared: the Authorization header field is not present in the request (see https://www.rfc-editor.org/rfc/rfc9111#section-11.6.2 of [HTTP]) or a response directive is present that explicitly allows shared caching (see https://www.rfc-editor.org/rfc/rfc9111#section-3.5);
 	// In this specification, the following response directives have such an effect: must-revalidate (https://www.rfc-editor.org/rfc/rfc9111#section-5.2.2.2), public (https://www.rfc-editor.org/rfc/rfc9111#section-5.2.2.9), and s-maxage (https://www.rfc-editor.org/rfc/rfc9111#section-5.2.2.10).
-	if req.Header.Get("Authorization") != "" && !rescc.MustRevalidate && !rescc.Public && rescc.SMaxAge == nil {
-		return false, time.Time{}
+	if c.shared && req.Header.Get("Authorization") != "" && !rescc.MustRevalidate && !rescc.Public && rescc.SMaxAge == nil {
+		return false, time.Time{}, ReasonAuthorization
 	}
 
-	expires := CalclateExpires(rescc, res.Header, s.heuristicExpirationRatio, now)
+	expires := now.Add(c.freshnessLifetime(rescc, header, res.StatusCode, now) - CurrentAge(res.Header, now, now, now))
 	if expires.Sub(now) <= 0 {
-		return false, time.Time{}
+		return false, time.Time{}, ReasonZeroFreshness
 	}
 
 	// - the response contains at least one of the following:
 
 	//   * a public response directive (see https://www.rfc-editor.org/rfc/rfc9111#section-5.2.2.9);
 	if rescc.Public {
-		return true, expires
+		return true, expires, ReasonPublic
 	}
 	//   * a private response directive, if the cache is not shared (see https://www.rfc-editor.org/rfc/rfc9111#section-5.2.2.7);
-	// THE CACHE IS SHARED
+	if !c.shared && (rescc.Private || len(rescc.PrivateFields) > 0) {
+		return true, expires, ReasonPrivateCache
+	}
 
 	//   * an Expires header field (see https://www.rfc-editor.org/rfc/rfc9111#section-5.3);
-	if res.Header.Get("Expires") != "" {
-		return true, expires
+	if header.Get("Expires") != "" {
+		return true, expires, ReasonExpires
 	}
 	//   * a max-age response directive (see https://www.rfc-editor.org/rfc/rfc9111#section-5.2.2.1);
 	if rescc.MaxAge != nil {
-		return true, expires
+		return true, expires, ReasonMaxAge
 	}
 	//   * if the cache is shared: an s-maxage response directive (see https://www.rfc-editor.org/rfc/rfc9111#section-5.2.2.10);
-	if rescc.SMaxAge != nil {
-		return true, expires
+	if c.shared && rescc.SMaxAge != nil {
+		return true, expires, ReasonSMaxAge
 	}
 	//   * a cache extension that allows it to be cached (see https://www.rfc-editor.org/rfc/rfc9111#section-5.2.3); or
 	// NOT IMPLEMENTED
 
 	//   * a status code that is defined as heuristically cacheable (see https://www.rfc-editor.org/rfc/rfc9111#section-4.2.2).
-	if contains(res.StatusCode, s.heuristicallyCacheableStatusCodes) {
-		return true, expires
+	// A status code with a heuristic freshness lifetime set by HeuristicLifetime is also heuristically cacheable.
+	if _, ok := c.heuristicLifetimes[res.StatusCode]; ok || contains(res.StatusCode, c.heuristicallyCacheableStatusCodes) {
+		return true, expires, ReasonHeuristic
 	}
 
-	return false, time.Time{}
+	return false, time.Time{}, ReasonNotCacheable
+}
+
+// Handle handles a request using the stored request and response, and returns whether the stored response is used.
+func (c *cache) Handle(req *http.Request, cachedReq *http.Request, cachedRes *http.Response, do func(*http.Request) (*http.Response, error), now time.Time) (bool, *http.Response, error) {
+	cacheUsed, res, _, err := c.HandleWithReason(req, cachedReq, cachedRes, do, now)
+	return cacheUsed, res, err
 }
 
-func (s *Shared) Handle(req *http.Request, cachedReq *http.Request, cachedRes *http.Response, do func(*http.Request) (*http.Response, error), now time.Time) (bool, *http.Response, error) {
+// HandleWithReason is the same as Handle, but also returns the reason for the decision.
+func (c *cache) HandleWithReason(req *http.Request, cachedReq *http.Request, cachedRes *http.Response, do func(*http.Request) (*http.Response, error), now time.Time) (bool, *http.Response, Reason, error) {
+	st := &cacheStatus{}
+	if c.cacheStatusIdentifier == "" {
+		cacheUsed, res, err := c.handle(req, cachedReq, cachedRes, do, now, st)
+		return cacheUsed, res, st.reason, err
+	}
+	cacheUsed, res, err := c.handle(req, cachedReq, cachedRes, forwardRecorder(do, st), now, st)
+	if err != nil {
+		return cacheUsed, res, st.reason, err
+	}
+	c.addCacheStatus(req, res, cacheUsed, st, now)
+	return cacheUsed, res, st.reason, nil
+}
+
+func (c *cache) handle(req *http.Request, cachedReq *http.Request, cachedRes *http.Response, do func(*http.Request) (*http.Response, error), now time.Time, st *cacheStatus) (bool, *http.Response, error) {
+	reqcc := ParseRequestCacheControlHeader(req.Header.Values("Cache-Control"))
+
+	// The only-if-cached request directive indicates that the client only wishes to obtain a stored response.
+	// Caches that honor the only-if-cached request directive SHOULD, upon receiving it, respond with either a stored response consistent with the other constraints of the request or a 504 (Gateway Timeout) status code (https://www.rfc-editor.org/rfc/rfc9111#section-5.2.1.7).
+	if reqcc.OnlyIfCached {
+		do = gatewayTimeout
+	}
+
+	// The cache does not handle a request whose method it does not understand (see UnderstoodMethods), and forwards it.
+	if !contains(req.Method, c.understoodMethods) {
+		st.fwd, st.reason = fwdBypass, ReasonMethodNotUnderstood
+		res, err := do(req)
+		return false, res, err
+	}
+
 	if cachedReq == nil || cachedRes == nil {
+		st.fwd, st.reason = fwdURIMiss, ReasonNoStoredResponse
+		// The caller did not pass the stored responses for the target URI, because none of them matches the request by the Vary header field.
+		if httpcache.IsVaryMiss(req.Context()) {
+			st.fwd, st.reason = fwdVaryMiss, ReasonVaryMismatch
+		}
 		res, err := do(req)
 		return false, res, err
 	}
@@ -150,65 +302,114 @@ func (s *Shared) Handle(req *http.Request, cachedReq *http.Request, cachedRes *h
 	// When presented with a request, a cache MUST NOT reuse a stored response unless:
 
 	// - the presented target URI (Section 7.1 of [HTTP]) and that of the stored response match, and
-	if req.URL.String() != cachedReq.URL.String() {
+	// The target URIs are compared with the cache key, which normalizes them.
+	if !c.targetMatches(req, cachedReq) {
+		st.fwd, st.reason = fwdURIMiss, ReasonURIMismatch
 		res, err := do(req)
 		return false, res, err
 	}
 
 	// - the request method associated with the stored response allows it to be used for the presented request, and
-	if req.Method != cachedReq.Method { // FIXME: more strictly
+	// Only a stored response to a GET request is used, and only for a GET or HEAD request.
+	// A stored response to a GET request can be used for a HEAD request, because the server sends the same header fields in response to both (https://www.rfc-editor.org/rfc/rfc9110#section-9.3.2).
+	// A cached POST response can never satisfy a subsequent POST request (https://www.rfc-editor.org/rfc/rfc9110#section-9.3.3), so unsafe methods are always forwarded.
+	if cachedReq.Method != http.MethodGet || (req.Method != http.MethodGet && req.Method != http.MethodHead) {
+		st.fwd, st.reason = fwdMethod, ReasonMethodMismatch
 		res, err := do(req)
 		return false, res, err
 	}
 
 	// - request header fields nominated by the stored response (if any) match those presented (see https://www.rfc-editor.org/rfc/rfc9111#section-4.1)
-	if v := cachedRes.Header.Values("Vary"); len(v) != 0 {
-		vary := strings.Join(v, ",")
-		if strings.Contains(vary, "*") {
-			res, err := do(req)
-			return false, res, err
-		}
-		for _, h := range strings.Split(vary, ",") {
-			h = strings.TrimSpace(h)
-			if req.Header.Get(h) != cachedReq.Header.Get(h) { // FIXME: more strictly
-				res, err := do(req)
-				return false, res, err
-			}
+	if !varyMatches(req, cachedReq, cachedRes) {
+		st.fwd, st.reason = fwdVaryMiss, ReasonVaryMismatch
+		if _, ok := httpcache.VaryKey(cachedRes.Header.Values("Vary"), req.Header); !ok {
+			st.reason = ReasonVaryStar
 		}
+		res, err := do(req)
+		return false, res, err
 	}
 
 	// - the stored response does not contain the no-cache directive (https://www.rfc-editor.org/rfc/rfc9111#section-5.2.2.4), unless it is successfully validated (https://www.rfc-editor.org/rfc/rfc9111#section-4.3), and
-	rescc := ParseResponseCacheControlHeader(cachedRes.Header.Values("Cache-Control"))
+	rescc, header := c.responseDirectives(cachedRes.Header)
 
 	if rescc.NoCache {
+		st.fwd, st.reason = fwdStale, ReasonNoCache
 		res, err := do(req)
 		return false, res, err
 	}
 
-	expires := CalclateExpires(rescc, cachedRes.Header, s.heuristicExpirationRatio, now)
+	// A cache MUST NOT use an incomplete response to answer requests unless the request is partial and specifies a range wholly within the incomplete response (https://www.rfc-editor.org/rfc/rfc9111#section-3.3).
+	// The request is forwarded as it is, so that the partial response to it can be combined with the stored one.
+	if cachedRes.StatusCode == http.StatusPartialContent {
+		if _, _, _, ok := partialRange(req, cachedRes); !ok {
+			st.fwd, st.reason = fwdPartial, ReasonPartialMismatch
+			res, err := do(req)
+			return false, res, err
+		}
+	}
+
+	// The stored response is fresh if its freshness lifetime exceeds its current age (https://www.rfc-editor.org/rfc/rfc9111#section-4.2).
+	requestTime, responseTime := StoredTimes(cachedRes.Header, now)
+	age := CurrentAge(cachedRes.Header, requestTime, responseTime, now)
+	expires := now.Add(c.freshnessLifetime(rescc, header, cachedRes.StatusCode, responseTime) - age)
+	st.setTTL(expires, now)
+
+	// The request directives can prevent the stored response from being used without validation (https://www.rfc-editor.org/rfc/rfc9111#section-5.2.1).
+	reusable := true
+	// - The no-cache request directive indicates that the client prefers a stored response not be used to satisfy the request without successful validation on the origin server (https://www.rfc-editor.org/rfc/rfc9111#section-5.2.1.4).
+	if reqcc.NoCache {
+		reusable = false
+	}
+	// - The max-age request directive indicates that the client prefers a response whose age is less than or equal to the specified number of seconds (https://www.rfc-editor.org/rfc/rfc9111#section-5.2.1.1).
+	if reqcc.MaxAge != nil && age > time.Duration(*reqcc.MaxAge)*time.Second {
+		reusable = false
+	}
+	// - The min-fresh request directive indicates that the client prefers a response whose freshness lifetime is no less than its current age plus the specified time in seconds (https://www.rfc-editor.org/rfc/rfc9111#section-5.2.1.3).
+	fresh := expires
+	if reqcc.MinFresh != nil {
+		fresh = expires.Add(-time.Duration(*reqcc.MinFresh) * time.Second)
+	}
 
 	// - the stored response is one of the following:
 	//   * fresh (see https://www.rfc-editor.org/rfc/rfc9111#section-4.2), or
-	if expires.Sub(now) > 0 {
-		return true, cachedRes, nil
+	if reusable && fresh.Sub(now) > 0 {
+		st.hit, st.reason = true, ReasonFresh
+		return true, c.reusedResponse(req, cachedRes, age, rescc), nil
 	}
 
 	//   * allowed to be served stale (see https://www.rfc-editor.org/rfc/rfc9111#section-4.2.4), or
-	if !rescc.NoCache && !rescc.MustRevalidate && rescc.SMaxAge == nil && !rescc.ProxyRevalidate {
-		//     > A cache MUST NOT generate a stale response if it is prohibited by an explicit in-protocol directive (e.g., by a no-cache response directive, a must-revalidate response directive, or an applicable s-maxage or proxy-revalidate response directive; see https://www.rfc-editor.org/rfc/rfc9111#section-5.2.2).
-		reqcc := ParseRequestCacheControlHeader(req.Header.Values("Cache-Control"))
-		//     > A cache MUST NOT generate a stale response unless it is disconnected or doing so is explicitly permitted by the client or origin server (e.g., by the max-stale request directive in https://www.rfc-editor.org/rfc/rfc9111#section-5.2.1, extension directives such as those defined in [RFC5861], or configuration in accordance with an out-of-band contract).
-		if reqcc.MaxStale == nil {
-			// If no value is assigned to max-stale, then the client will accept a stale response of any age (ref https://www.rfc-editor.org/rfc/rfc9111#section-5.2.1.2).
-			return true, cachedRes, nil
-		}
-		if expires.Add(time.Duration(*reqcc.MaxStale)*time.Second).Sub(now) > 0 {
-			return true, cachedRes, nil
+	//     > A cache MUST NOT generate a stale response if it is prohibited by an explicit in-protocol directive (e.g., by a no-cache response directive, a must-revalidate response directive, or an applicable s-maxage or proxy-revalidate response directive; see https://www.rfc-editor.org/rfc/rfc9111#section-5.2.2).
+	//     The stale-while-revalidate and stale-if-error response directives explicitly permit a stale response even if s-maxage is present, because they are given by the same origin server.
+	staleProhibited := rescc.NoCache || rescc.MustRevalidate || (c.shared && rescc.ProxyRevalidate)
+	//     The client does not accept a stale response if min-fresh is present, or if max-age is present without max-stale.
+	staleAccepted := reusable && reqcc.MinFresh == nil && (reqcc.MaxAge == nil || reqcc.MaxStale != nil)
+
+	//     The stale-while-revalidate response directive indicates that caches MAY serve the response in which it appears after it becomes stale, up to the indicated number of seconds.
+	//     If a cached response is served stale due to the presence of this extension, the cache SHOULD attempt to revalidate it while still serving stale responses (https://www.rfc-editor.org/rfc/rfc5861#section-3).
+	if staleAccepted && !staleProhibited && rescc.StaleWhileRevalidate != nil && expires.Add(time.Duration(*rescc.StaleWhileRevalidate)*time.Second).Sub(now) > 0 {
+		if !reqcc.OnlyIfCached {
+			refreshInBackground(req, do)
 		}
+		st.hit, st.reason = true, ReasonStaleWhileRevalidate
+		return true, c.reusedResponse(req, cachedRes, age, rescc), nil
+	}
+
+	//     Beyond the stale-while-revalidate window, the stored response is served stale only if the client explicitly permits it with max-stale.
+	//     > A cache MUST NOT generate a stale response unless it is disconnected or doing so is explicitly permitted by the client or origin server (e.g., by the max-stale request directive in https://www.rfc-editor.org/rfc/rfc9111#section-5.2.1, extension directives such as those defined in [RFC5861], or configuration in accordance with an out-of-band contract).
+	//     If no value is assigned to max-stale, then the client will accept a stale response of any age (https://www.rfc-editor.org/rfc/rfc9111#section-5.2.1.2), which is parsed as the largest value.
+	if staleAccepted && !staleProhibited && !(c.shared && rescc.SMaxAge != nil) && reqcc.MaxStale != nil && expires.Add(time.Duration(*reqcc.MaxStale)*time.Second).Sub(now) > 0 {
+		st.hit, st.reason = true, ReasonMaxStale
+		return true, c.reusedResponse(req, cachedRes, age, rescc), nil
 	}
 
 	//   * successfully validated (see https://www.rfc-editor.org/rfc/rfc9111#section-4.3).
-	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+	st.fwd, st.reason = fwdStale, ReasonStale
+	if !reusable && fresh.Sub(now) > 0 {
+		st.fwd, st.reason = fwdRequest, ReasonRequestDirectives
+	}
+	// A stored partial response is not validated, because a 304 (Not Modified) response would not make it complete.
+	// A stored response to a GET request is not validated by a HEAD request either, but it is freshened with the response to the HEAD request (see Freshen).
+	if (req.Method == http.MethodGet || req.Method == http.MethodHead) && req.Method == cachedReq.Method && cachedRes.StatusCode != http.StatusPartialContent {
 		if cachedRes.Header.Get("ETag") != "" {
 			req.Header.Set("If-None-Match", cachedRes.Header.Get("ETag"))
 		}
@@ -216,11 +417,38 @@ func (s *Shared) Handle(req *http.Request, cachedReq *http.Request, cachedRes *h
 			req.Header.Set("If-Modified-Since", cachedRes.Header.Get("Last-Modified"))
 		}
 		res, err := do(req)
+		if staleIfError(rescc, reqcc, res, err, expires, now) && !staleProhibited {
+			if err == nil && res.Body != nil {
+				_ = res.Body.Close()
+			}
+			st.reason = ReasonStaleIfError
+			return true, c.reusedResponse(req, cachedRes, age, rescc), nil
+		}
 		if err != nil {
 			return false, res, err
 		}
 		if res.StatusCode == http.StatusNotModified {
-			return true, cachedRes, nil
+			if res.Body != nil {
+				_ = res.Body.Close()
+			}
+			if !selectedForUpdate(cachedRes.Header, res.Header) {
+				// The stored response cannot be used, so the request is forwarded without the conditional header fields.
+				st.reason = ReasonNotSelectedForUpdate
+				req.Header.Del("If-None-Match")
+				req.Header.Del("If-Modified-Since")
+				res, err := do(req)
+				return false, res, err
+			}
+			// The cache freshens the stored response with the 304 (Not Modified) response (https://www.rfc-editor.org/rfc/rfc9111#section-4.3.4).
+			// The caller is responsible for storing the freshened response, which is received at now.
+			st.reason = ReasonValidated
+			freshened := *cachedRes
+			freshened.Header = UpdateStoredHeader(cachedRes.Header, res.Header)
+			age := CurrentAge(freshened.Header, now, now, now)
+			freshenedcc, header := c.responseDirectives(freshened.Header)
+			st.setTTL(now.Add(c.freshnessLifetime(freshenedcc, header, freshened.StatusCode, now)-age), now)
+			// A range request is satisfied from the freshened response, which the caller does not store again (see httpcache.Transport).
+			return true, rangeResponse(req, cachedResponse(&freshened, age)), nil
 		}
 		return false, res, nil
 	}
@@ -229,50 +457,161 @@ func (s *Shared) Handle(req *http.Request, cachedReq *http.Request, cachedRes *h
 	return false, res, err
 }
 
+// targetMatches returns true if the target URIs of the request and the stored request match.
+// If the request carries the cache key with which the stored response is looked up, they match.
+func (c *cache) targetMatches(req, cachedReq *http.Request) bool {
+	if _, ok := httpcache.CacheKeyFromContext(req.Context()); ok {
+		return true
+	}
+	return c.key(req) == c.key(cachedReq)
+}
+
+// varyMatches returns true if the request header fields nominated by the stored response match those presented (https://www.rfc-editor.org/rfc/rfc9111#section-4.1).
+// A Vary header field value containing a member "*" always fails to match.
+// The nominated request header fields are normalized by combining multiple field lines, removing whitespace, and case-normalizing values defined to be case-insensitive.
+func varyMatches(req, cachedReq *http.Request, cachedRes *http.Response) bool {
+	v := cachedRes.Header.Values("Vary")
+	if len(v) == 0 {
+		return true
+	}
+	key, ok := httpcache.VaryKey(v, req.Header)
+	cachedKey, _ := httpcache.VaryKey(v, cachedReq.Header)
+	return ok && key == cachedKey
+}
+
+// CalclateExpires calculates the expiration time of a response that has just been received at now by a shared cache.
+// If d is parsed from a targeted field with ParseTargetedCacheControlHeader, the Expires header field should be removed from header (https://www.rfc-editor.org/rfc/rfc9213#section-2.2).
 func CalclateExpires(d *ResponseDirectives, header http.Header, heuristicExpirationRatio float64, now time.Time) time.Time {
+	return now.Add(FreshnessLifetime(d, header, heuristicExpirationRatio, now) - CurrentAge(header, now, now, now))
+}
+
+// FreshnessLifetime calculates the freshness lifetime of a response in a shared cache (https://www.rfc-editor.org/rfc/rfc9111#section-4.2.1).
+// responseTime is used instead of the Date header field if it is not present.
+func FreshnessLifetime(d *ResponseDirectives, header http.Header, heuristicExpirationRatio float64, responseTime time.Time) time.Duration {
+	if lifetime, ok := explicitFreshnessLifetime(d, header, responseTime, true); ok {
+		return lifetime
+	}
+	return heuristicFreshnessLifetime(header, heuristicExpirationRatio, responseTime)
+}
+
+// freshnessLifetime calculates the freshness lifetime of a response with the status code in the cache.
+// If no explicit expiration time is present in the response, the heuristic freshness lifetime is controlled by the options of the cache.
+func (c *cache) freshnessLifetime(d *ResponseDirectives, header http.Header, statusCode int, responseTime time.Time) time.Duration {
+	if lifetime, ok := explicitFreshnessLifetime(d, header, responseTime, c.shared); ok {
+		return lifetime
+	}
+	if lifetime, ok := c.heuristicLifetimes[statusCode]; ok {
+		return lifetime
+	}
+	lifetime := heuristicFreshnessLifetime(header, c.heuristicExpirationRatio, responseTime)
+	if c.maxHeuristicLifetime > 0 {
+		lifetime = min(lifetime, c.maxHeuristicLifetime)
+	}
+	return max(lifetime, c.minHeuristicLifetime)
+}
+
+// explicitFreshnessLifetime returns the freshness lifetime given by the explicit expiration time of a response, or false if it is not present.
+func explicitFreshnessLifetime(d *ResponseDirectives, header http.Header, responseTime time.Time, shared bool) (time.Duration, bool) {
 	// 	4.2.1. Calculating Freshness Lifetime
 	// A cache can calculate the freshness lifetime (denoted as freshness_lifetime) of a response by evaluating the following rules and using the first match:
 
 	// - If the cache is shared and the s-maxage response directive (https://www.rfc-editor.org/rfc/rfc9111#section-5.2.2.10) is present, use its value, or
-	if d.SMaxAge != nil {
-		return now.Add(time.Duration(*d.SMaxAge) * time.Second)
+	if shared && d.SMaxAge != nil {
+		return time.Duration(*d.SMaxAge) * time.Second, true
 	}
 	// - If the max-age response directive (https://www.rfc-editor.org/rfc/rfc9111#section-5.2.2.1) is present, use its value, or
 	if d.MaxAge != nil {
-		return now.Add(time.Duration(*d.MaxAge) * time.Second)
+		return time.Duration(*d.MaxAge) * time.Second, true
 	}
 	if header.Get("Expires") != "" {
 		// - If the Expires response header field (https://www.rfc-editor.org/rfc/rfc9111#section-5.3) is present, use its value minus the value of the Date response header field
+		// (using the time the message was received if it is not present, as per Section 6.6.1 of [HTTP])
 		et, err := http.ParseTime(header.Get("Expires"))
 		if err == nil {
-			if header.Get("Date") != "" {
-				dt, err := http.ParseTime(header.Get("Date"))
-				if err == nil {
-					return now.Add(et.Sub(dt))
-				}
-			} else {
-				// (using the time the message was received if it is not present, as per Section 6.6.1 of [HTTP])
-				return et // == return now.Add(et.Sub(now))
-			}
+			return et.Sub(dateValue(header, responseTime)), true
 		}
 	}
+	return 0, false
+}
+
+// heuristicFreshnessLifetime returns the heuristic freshness lifetime of a response without an explicit expiration time.
+func heuristicFreshnessLifetime(header http.Header, heuristicExpirationRatio float64, responseTime time.Time) time.Duration {
 	// Otherwise, no explicit expiration time is present in the response. A heuristic freshness lifetime might be applicable; see https://www.rfc-editor.org/rfc/rfc9111#section-4.2.2.
 	if header.Get("Last-Modified") != "" {
 		lt, err := http.ParseTime(header.Get("Last-Modified"))
 		if err == nil {
 			// If the response has a Last-Modified header field (Section 8.8.2 of [HTTP]), caches are encouraged to use a heuristic expiration value that is no more than some fraction of the interval since that time. A typical setting of this fraction might be 10%.
-			if header.Get("Date") != "" {
-				dt, err := http.ParseTime(header.Get("Date"))
-				if err == nil {
-					return dt.Add(time.Duration(float64(dt.Sub(lt)) * heuristicExpirationRatio))
-				}
-			} else {
-				return now.Add(time.Duration(float64(now.Sub(lt)) * heuristicExpirationRatio))
-			}
+			return time.Duration(float64(dateValue(header, responseTime).Sub(lt)) * heuristicExpirationRatio)
 		}
 	}
 
-	return now
+	return 0
+}
+
+// backgroundDroppedHeaders are the request header fields removed from a background request,
+// so that its response is a complete response that replaces the stored one.
+var backgroundDroppedHeaders = []string{
+	"Range",
+	"If-Range",
+	"If-Match",
+	"If-None-Match",
+	"If-Modified-Since",
+	"If-Unmodified-Since",
+}
+
+// refreshInBackground forwards the request without the request's cancellation in the background so that the caller can store the response.
+// The request is forwarded as an unconditional GET request for the complete response, because the stored response is a complete response to a GET request,
+// even if it is served to a HEAD request or a range request.
+func refreshInBackground(req *http.Request, do func(*http.Request) (*http.Response, error)) {
+	ctx := httpcache.ContextWithBackground(context.WithoutCancel(req.Context()))
+	bg := req.Clone(ctx)
+	bg.Method = http.MethodGet
+	bg.Body, bg.GetBody, bg.ContentLength = nil, nil, 0
+	for _, h := range backgroundDroppedHeaders {
+		bg.Header.Del(h)
+	}
+	go func() {
+		res, err := do(bg)
+		if err == nil && res.Body != nil {
+			_ = res.Body.Close()
+		}
+	}()
+}
+
+// staleIfError returns true if the stored response can be served stale because the request failed (https://www.rfc-editor.org/rfc/rfc5861#section-4).
+func staleIfError(rescc *ResponseDirectives, reqcc *RequestDirectives, res *http.Response, err error, expires, now time.Time) bool {
+	// An error is any situation that would result in a 500, 502, 503, or 504 HTTP response status code being returned.
+	if err == nil && !contains(res.StatusCode, []int{
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	}) {
+		return false
+	}
+	// The stale-if-error request directive indicates that the client is willing to accept a stale response if an error is encountered, and takes precedence over the response directive.
+	sie := rescc.StaleIfError
+	if reqcc.StaleIfError != nil {
+		sie = reqcc.StaleIfError
+	}
+	if sie == nil {
+		return false
+	}
+	return expires.Add(time.Duration(*sie)*time.Second).Sub(now) > 0
+}
+
+// gatewayTimeout returns a 504 (Gateway Timeout) response instead of forwarding the request.
+func gatewayTimeout(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		Status:     "504 Gateway Timeout",
+		StatusCode: http.StatusGatewayTimeout,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Body:       http.NoBody,
+		Request:    req,
+	}, nil
 }
 
 func contains[T comparable](v T, vv []T) bool {
@@ -1,12 +1,14 @@
 package rfc9111
 
 import (
+	"errors"
 	"net/http"
 	"net/url"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/k1LoW/httpcache"
 )
 
 func TestShared_Storable(t *testing.T) {
@@ -62,7 +64,7 @@ func TestShared_Storable(t *testing.T) {
 			time.Date(2024, 12, 13, 14, 15, 20, 00, time.UTC),
 		},
 		{
-			"GET 200 Expires: 2024-12-13 14:15:20, Date: 2024-12-13 13:15:20",
+			"GET 200 Expires: 2024-12-13 14:15:20, Date: 2024-12-13 13:15:20 -> Age is 1h",
 			&http.Request{
 				Method: http.MethodGet,
 			},
@@ -74,7 +76,7 @@ func TestShared_Storable(t *testing.T) {
 				},
 			},
 			true,
-			time.Date(2024, 12, 13, 15, 15, 16, 00, time.UTC),
+			time.Date(2024, 12, 13, 14, 15, 20, 00, time.UTC),
 		},
 		{
 			"GET 200 Last-Modified: 2024-12-13 14:15:10, Date: 2024-12-13 14:15:20 -> +1s",
@@ -89,7 +91,7 @@ func TestShared_Storable(t *testing.T) {
 				},
 			},
 			true,
-			time.Date(2024, 12, 13, 14, 15, 21, 00, time.UTC),
+			time.Date(2024, 12, 13, 14, 15, 17, 00, time.UTC),
 		},
 		{
 			"GET 200 Last-Modified: 2024-12-13 14:15:06 -> +1s",
@@ -175,6 +177,54 @@ func TestShared_Storable(t *testing.T) {
 			false,
 			time.Time{},
 		},
+		{
+			"GET 206 Cache-Control: max-age=15, ETag, Content-Range -> +15s",
+			&http.Request{
+				Method: http.MethodGet,
+			},
+			&http.Response{
+				StatusCode: http.StatusPartialContent,
+				Header: http.Header{
+					"Cache-Control": []string{"max-age=15"},
+					"Etag":          []string{`"v1"`},
+					"Content-Range": []string{"bytes 0-4/10"},
+				},
+			},
+			true,
+			time.Date(2024, 12, 13, 14, 15, 31, 00, time.UTC),
+		},
+		{
+			"GET 206 Cache-Control: max-age=15, weak ETag, Content-Range -> No Store",
+			&http.Request{
+				Method: http.MethodGet,
+			},
+			&http.Response{
+				StatusCode: http.StatusPartialContent,
+				Header: http.Header{
+					"Cache-Control": []string{"max-age=15"},
+					"Etag":          []string{`W/"v1"`},
+					"Content-Range": []string{"bytes 0-4/10"},
+				},
+			},
+			false,
+			time.Time{},
+		},
+		{
+			"GET 206 Cache-Control: max-age=15, ETag, Content-Range of unknown length -> No Store",
+			&http.Request{
+				Method: http.MethodGet,
+			},
+			&http.Response{
+				StatusCode: http.StatusPartialContent,
+				Header: http.Header{
+					"Cache-Control": []string{"max-age=15"},
+					"Etag":          []string{`"v1"`},
+					"Content-Range": []string{"bytes 0-4/*"},
+				},
+			},
+			false,
+			time.Time{},
+		},
 		{
 			"GET 200 Cache-Control: no-store -> No Store",
 			&http.Request{
@@ -232,6 +282,23 @@ func TestShared_Storable(t *testing.T) {
 			false,
 			time.Time{},
 		},
+		{
+			"GET Cache-Control: no-store 200 Cache-Control: max-age=15 -> No Store",
+			&http.Request{
+				Method: http.MethodGet,
+				Header: http.Header{
+					"Cache-Control": []string{"no-store"},
+				},
+			},
+			&http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Cache-Control": []string{"max-age=15"},
+				},
+			},
+			false,
+			time.Time{},
+		},
 		{
 			"GET Authorization: XXX 200 Cache-Control: max-age=15 -> No Store",
 			&http.Request{
@@ -275,6 +342,7 @@ func TestShared_Handle(t *testing.T) {
 	now := time.Date(2024, 12, 13, 14, 15, 16, 00, time.UTC)
 	fresh := now.Add(15 * time.Second)
 	stale := now.Add(-15 * time.Second)
+	recorded := now.Add(-20 * time.Second)
 
 	endpoint, err := url.Parse("https://example.com/api/v1/path/to/resource")
 	if err != nil {
@@ -299,6 +367,30 @@ func TestShared_Handle(t *testing.T) {
 	do304 := func(req *http.Request) (*http.Response, error) {
 		return origin304res, nil
 	}
+	do304Freshen := func(req *http.Request) (*http.Response, error) {
+		if req.Header.Get("If-None-Match") != `"a"` {
+			return origin200res, nil
+		}
+		return &http.Response{
+			StatusCode: http.StatusNotModified,
+			Header: http.Header{
+				"Cache-Control": []string{"max-age=60"},
+				"Date":          []string{now.Format(http.TimeFormat)},
+				"Etag":          []string{`"a"`},
+			},
+		}, nil
+	}
+	do304OtherETag := func(req *http.Request) (*http.Response, error) {
+		if req.Header.Get("If-None-Match") == "" {
+			return origin200res, nil
+		}
+		return &http.Response{
+			StatusCode: http.StatusNotModified,
+			Header: http.Header{
+				"Etag": []string{`"b"`},
+			},
+		}, nil
+	}
 	tests := []struct {
 		name          string
 		req           *http.Request
@@ -403,13 +495,14 @@ func TestShared_Handle(t *testing.T) {
 			&http.Response{
 				StatusCode: http.StatusOK,
 				Header: http.Header{
+					"Age":           []string{"0"},
 					"Last-Modified": []string{fresh.Format(http.TimeFormat)},
 					"Date":          []string{fresh.Format(http.TimeFormat)},
 				},
 			},
 		},
 		{
-			"Use stale cached response",
+			"Use flesh cached response (Age: 10, recorded 20s ago)",
 			&http.Request{
 				URL:    endpoint,
 				Method: http.MethodGet,
@@ -421,8 +514,11 @@ func TestShared_Handle(t *testing.T) {
 			&http.Response{
 				StatusCode: http.StatusOK,
 				Header: http.Header{
-					"Last-Modified": []string{stale.Format(http.TimeFormat)},
-					"Date":          []string{stale.Format(http.TimeFormat)},
+					"Cache-Control":              []string{"max-age=60"},
+					"Age":                        []string{"10"},
+					"Date":                       []string{recorded.Format(http.TimeFormat)},
+					httpcache.HeaderRequestTime:  []string{recorded.Format(time.RFC3339Nano)},
+					httpcache.HeaderResponseTime: []string{recorded.Format(time.RFC3339Nano)},
 				},
 			},
 			do200,
@@ -430,18 +526,43 @@ func TestShared_Handle(t *testing.T) {
 			&http.Response{
 				StatusCode: http.StatusOK,
 				Header: http.Header{
-					"Last-Modified": []string{stale.Format(http.TimeFormat)},
-					"Date":          []string{stale.Format(http.TimeFormat)},
+					"Cache-Control": []string{"max-age=60"},
+					"Age":           []string{"30"},
+					"Date":          []string{recorded.Format(http.TimeFormat)},
 				},
 			},
 		},
 		{
-			"Use origin response (Cache-Control: max-stale=10)",
+			"Validate and use origin response (Age: 50, recorded 20s ago)",
+			&http.Request{
+				URL:    endpoint,
+				Method: http.MethodGet,
+			},
 			&http.Request{
 				URL:    endpoint,
 				Method: http.MethodGet,
+			},
+			&http.Response{
+				StatusCode: http.StatusOK,
 				Header: http.Header{
-					"Cache-Control": []string{"max-stale=10"},
+					"Cache-Control":              []string{"max-age=60, must-revalidate"},
+					"Age":                        []string{"50"},
+					"Date":                       []string{recorded.Format(http.TimeFormat)},
+					httpcache.HeaderRequestTime:  []string{recorded.Format(time.RFC3339Nano)},
+					httpcache.HeaderResponseTime: []string{recorded.Format(time.RFC3339Nano)},
+				},
+			},
+			do200,
+			false,
+			origin200res,
+		},
+		{
+			"Validate and use origin response (request Cache-Control: no-cache)",
+			&http.Request{
+				URL:    endpoint,
+				Method: http.MethodGet,
+				Header: http.Header{
+					"Cache-Control": []string{"no-cache"},
 				},
 			},
 			&http.Request{
@@ -451,7 +572,7 @@ func TestShared_Handle(t *testing.T) {
 			&http.Response{
 				StatusCode: http.StatusOK,
 				Header: http.Header{
-					"Last-Modified": []string{stale.Format(http.TimeFormat)},
+					"Cache-Control": []string{"max-age=60"},
 					"Date":          []string{stale.Format(http.TimeFormat)},
 				},
 			},
@@ -460,12 +581,12 @@ func TestShared_Handle(t *testing.T) {
 			origin200res,
 		},
 		{
-			"Use stale cached response (Cache-Control: max-stale=20)",
+			"Validate and use cached response (request Cache-Control: no-cache)",
 			&http.Request{
 				URL:    endpoint,
 				Method: http.MethodGet,
 				Header: http.Header{
-					"Cache-Control": []string{"max-stale=20"},
+					"Cache-Control": []string{"no-cache"},
 				},
 			},
 			&http.Request{
@@ -475,44 +596,63 @@ func TestShared_Handle(t *testing.T) {
 			&http.Response{
 				StatusCode: http.StatusOK,
 				Header: http.Header{
-					"Last-Modified": []string{stale.Format(http.TimeFormat)},
+					"Cache-Control": []string{"max-age=60"},
 					"Date":          []string{stale.Format(http.TimeFormat)},
 				},
 			},
-			do200,
+			do304,
 			true,
 			&http.Response{
 				StatusCode: http.StatusOK,
 				Header: http.Header{
-					"Last-Modified": []string{stale.Format(http.TimeFormat)},
+					"Age":           []string{"15"},
+					"Cache-Control": []string{"max-age=60"},
 					"Date":          []string{stale.Format(http.TimeFormat)},
 				},
 			},
 		},
 		{
-			"Use flesh cached response (Vary: Content-Type, User-Agent)",
+			"Validate and use origin response (request Cache-Control: max-age=10)",
 			&http.Request{
 				URL:    endpoint,
 				Method: http.MethodGet,
 				Header: http.Header{
-					"User-Agent":   []string{"test"},
-					"Content-Type": []string{"application/json"},
+					"Cache-Control": []string{"max-age=10"},
 				},
 			},
 			&http.Request{
 				URL:    endpoint,
 				Method: http.MethodGet,
+			},
+			&http.Response{
+				StatusCode: http.StatusOK,
 				Header: http.Header{
-					"Content-Type": []string{"application/json"},
-					"User-Agent":   []string{"test"},
+					"Cache-Control": []string{"max-age=60"},
+					"Date":          []string{stale.Format(http.TimeFormat)},
+				},
+			},
+			do200,
+			false,
+			origin200res,
+		},
+		{
+			"Use flesh cached response (request Cache-Control: max-age=20)",
+			&http.Request{
+				URL:    endpoint,
+				Method: http.MethodGet,
+				Header: http.Header{
+					"Cache-Control": []string{"max-age=20"},
 				},
 			},
+			&http.Request{
+				URL:    endpoint,
+				Method: http.MethodGet,
+			},
 			&http.Response{
 				StatusCode: http.StatusOK,
 				Header: http.Header{
-					"Last-Modified": []string{fresh.Format(http.TimeFormat)},
-					"Date":          []string{fresh.Format(http.TimeFormat)},
-					"Vary":          []string{"content-type, user-agent"},
+					"Cache-Control": []string{"max-age=60"},
+					"Date":          []string{stale.Format(http.TimeFormat)},
 				},
 			},
 			do200,
@@ -520,35 +660,30 @@ func TestShared_Handle(t *testing.T) {
 			&http.Response{
 				StatusCode: http.StatusOK,
 				Header: http.Header{
-					"Last-Modified": []string{fresh.Format(http.TimeFormat)},
-					"Date":          []string{fresh.Format(http.TimeFormat)},
-					"Vary":          []string{"content-type, user-agent"},
+					"Age":           []string{"15"},
+					"Cache-Control": []string{"max-age=60"},
+					"Date":          []string{stale.Format(http.TimeFormat)},
 				},
 			},
 		},
 		{
-			"Use origin response (Vary: Content-Type, User-Agent)",
+			"Validate and use origin response (request Cache-Control: min-fresh=50)",
 			&http.Request{
 				URL:    endpoint,
 				Method: http.MethodGet,
 				Header: http.Header{
-					"User-Agent":   []string{"test2"},
-					"Content-Type": []string{"application/json"},
+					"Cache-Control": []string{"min-fresh=50"},
 				},
 			},
 			&http.Request{
 				URL:    endpoint,
 				Method: http.MethodGet,
-				Header: http.Header{
-					"Content-Type": []string{"application/json"},
-					"User-Agent":   []string{"test"},
-				},
 			},
 			&http.Response{
 				StatusCode: http.StatusOK,
 				Header: http.Header{
-					"Last-Modified": []string{fresh.Format(http.TimeFormat)},
-					"Vary":          []string{"content-type, user-agent"},
+					"Cache-Control": []string{"max-age=60"},
+					"Date":          []string{stale.Format(http.TimeFormat)},
 				},
 			},
 			do200,
@@ -556,155 +691,677 @@ func TestShared_Handle(t *testing.T) {
 			origin200res,
 		},
 		{
-			"Use origin response (Cache-Control: no-cache)",
+			"Use flesh cached response (request Cache-Control: min-fresh=40)",
 			&http.Request{
 				URL:    endpoint,
 				Method: http.MethodGet,
-				Header: http.Header{},
+				Header: http.Header{
+					"Cache-Control": []string{"min-fresh=40"},
+				},
 			},
 			&http.Request{
 				URL:    endpoint,
 				Method: http.MethodGet,
-				Header: http.Header{},
 			},
 			&http.Response{
 				StatusCode: http.StatusOK,
 				Header: http.Header{
-					"Last-Modified": []string{fresh.Format(http.TimeFormat)},
-					"Cache-Control": []string{"no-cache"},
+					"Cache-Control": []string{"max-age=60"},
+					"Date":          []string{stale.Format(http.TimeFormat)},
 				},
 			},
 			do200,
-			false,
-			origin200res,
+			true,
+			&http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Age":           []string{"15"},
+					"Cache-Control": []string{"max-age=60"},
+					"Date":          []string{stale.Format(http.TimeFormat)},
+				},
+			},
 		},
 		{
-			"Validate and use origin response",
+			"Use stale cached response (request Cache-Control: max-age=20, max-stale)",
 			&http.Request{
 				URL:    endpoint,
 				Method: http.MethodGet,
-				Header: http.Header{},
+				Header: http.Header{
+					"Cache-Control": []string{"max-age=20, max-stale"},
+				},
 			},
 			&http.Request{
 				URL:    endpoint,
 				Method: http.MethodGet,
-				Header: http.Header{},
 			},
 			&http.Response{
 				StatusCode: http.StatusOK,
 				Header: http.Header{
-					"Last-Modified": []string{stale.Format(http.TimeFormat)},
+					"Cache-Control": []string{"max-age=10"},
 					"Date":          []string{stale.Format(http.TimeFormat)},
-					"Cache-Control": []string{"must-revalidate"},
 				},
 			},
 			do200,
-			false,
-			origin200res,
+			true,
+			&http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Age":           []string{"15"},
+					"Cache-Control": []string{"max-age=10"},
+					"Date":          []string{stale.Format(http.TimeFormat)},
+				},
+			},
 		},
 		{
-			"Validate and use cached response",
+			"Use flesh cached response (request Cache-Control: only-if-cached)",
 			&http.Request{
 				URL:    endpoint,
 				Method: http.MethodGet,
-				Header: http.Header{},
+				Header: http.Header{
+					"Cache-Control": []string{"only-if-cached"},
+				},
 			},
 			&http.Request{
 				URL:    endpoint,
 				Method: http.MethodGet,
-				Header: http.Header{},
 			},
 			&http.Response{
 				StatusCode: http.StatusOK,
 				Header: http.Header{
-					"Last-Modified": []string{stale.Format(http.TimeFormat)},
+					"Cache-Control": []string{"max-age=60"},
 					"Date":          []string{stale.Format(http.TimeFormat)},
-					"Cache-Control": []string{"must-revalidate"},
 				},
 			},
-			do304,
+			do200,
 			true,
 			&http.Response{
 				StatusCode: http.StatusOK,
 				Header: http.Header{
-					"Last-Modified": []string{stale.Format(http.TimeFormat)},
+					"Age":           []string{"15"},
+					"Cache-Control": []string{"max-age=60"},
 					"Date":          []string{stale.Format(http.TimeFormat)},
-					"Cache-Control": []string{"must-revalidate"},
 				},
 			},
 		},
-	}
-	for _, tt := range tests {
-		tt := tt
-		t.Run(tt.name, func(t *testing.T) {
-			//t.Parallel()
-			s, err := NewShared()
-			if err != nil {
-				t.Errorf("Shared.Handle() error = %v", err)
-				return
-			}
-			gotCacheUsed, gotRes, err := s.Handle(tt.req, tt.cachedReq, tt.cachedRes, tt.do, now)
-			if err != nil {
-				t.Errorf("Shared.Handle() error = %v", err)
-				return
-			}
-			if gotCacheUsed != tt.wantCacheUsed {
-				t.Errorf("Shared.Handle() gotCacheUsed = %v, want %v", gotCacheUsed, tt.wantCacheUsed)
-			}
-			if diff := cmp.Diff(gotRes, tt.wantRes); diff != "" {
-				t.Errorf("Shared.Handle() gotRes != tt.wantRes:\n%s", diff)
-			}
-		})
-	}
-}
-
-func TestShared_SharedOption(t *testing.T) {
-	now := time.Date(2024, 12, 13, 14, 15, 16, 00, time.UTC)
-
-	tests := []struct {
-		name        string
-		opts        []SharedOption
-		req         *http.Request
-		res         *http.Response
-		wantOK      bool
-		wantExpires time.Time
-	}{
 		{
-			"GET 200 Last-Modified: 2024-12-13 14:15:06 -> +1s",
-			[]SharedOption{},
+			"Use origin response (stale without max-stale)",
+			&http.Request{
+				URL:    endpoint,
+				Method: http.MethodGet,
+				Header: http.Header{},
+			},
 			&http.Request{
+				URL:    endpoint,
 				Method: http.MethodGet,
 			},
 			&http.Response{
 				StatusCode: http.StatusOK,
 				Header: http.Header{
-					"Last-Modified": []string{"Mon, 13 Dec 2024 14:15:06 GMT"},
+					"Last-Modified": []string{stale.Format(http.TimeFormat)},
+					"Date":          []string{stale.Format(http.TimeFormat)},
 				},
 			},
-			true,
-			time.Date(2024, 12, 13, 14, 15, 17, 00, time.UTC),
+			do200,
+			false,
+			origin200res,
 		},
 		{
-			"HeuristicExpirationRatio 0.5 GET 200 Last-Modified: 2024-12-13 14:15:06 -> +5s",
-			[]SharedOption{
-				HeuristicExpirationRatio(0.5),
+			"Use stale cached response (Cache-Control: max-stale)",
+			&http.Request{
+				URL:    endpoint,
+				Method: http.MethodGet,
+				Header: http.Header{
+					"Cache-Control": []string{"max-stale"},
+				},
 			},
 			&http.Request{
+				URL:    endpoint,
 				Method: http.MethodGet,
 			},
 			&http.Response{
 				StatusCode: http.StatusOK,
 				Header: http.Header{
-					"Last-Modified": []string{"Mon, 13 Dec 2024 14:15:06 GMT"},
+					"Last-Modified": []string{stale.Format(http.TimeFormat)},
+					"Date":          []string{stale.Format(http.TimeFormat)},
 				},
 			},
+			do200,
 			true,
-			time.Date(2024, 12, 13, 14, 15, 21, 00, time.UTC),
+			&http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Age":           []string{"15"},
+					"Last-Modified": []string{stale.Format(http.TimeFormat)},
+					"Date":          []string{stale.Format(http.TimeFormat)},
+				},
+			},
 		},
-	}
-	for _, tt := range tests {
-		tt := tt
-		t.Run(tt.name, func(t *testing.T) {
+		{
+			"Use origin response (Cache-Control: max-stale=10)",
+			&http.Request{
+				URL:    endpoint,
+				Method: http.MethodGet,
+				Header: http.Header{
+					"Cache-Control": []string{"max-stale=10"},
+				},
+			},
+			&http.Request{
+				URL:    endpoint,
+				Method: http.MethodGet,
+			},
+			&http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Last-Modified": []string{stale.Format(http.TimeFormat)},
+					"Date":          []string{stale.Format(http.TimeFormat)},
+				},
+			},
+			do200,
+			false,
+			origin200res,
+		},
+		{
+			"Use stale cached response (Cache-Control: max-stale=20)",
+			&http.Request{
+				URL:    endpoint,
+				Method: http.MethodGet,
+				Header: http.Header{
+					"Cache-Control": []string{"max-stale=20"},
+				},
+			},
+			&http.Request{
+				URL:    endpoint,
+				Method: http.MethodGet,
+			},
+			&http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Last-Modified": []string{stale.Format(http.TimeFormat)},
+					"Date":          []string{stale.Format(http.TimeFormat)},
+				},
+			},
+			do200,
+			true,
+			&http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Age":           []string{"15"},
+					"Last-Modified": []string{stale.Format(http.TimeFormat)},
+					"Date":          []string{stale.Format(http.TimeFormat)},
+				},
+			},
+		},
+		{
+			"Use flesh cached response (Vary: Content-Type, User-Agent)",
+			&http.Request{
+				URL:    endpoint,
+				Method: http.MethodGet,
+				Header: http.Header{
+					"User-Agent":   []string{"test"},
+					"Content-Type": []string{"application/json"},
+				},
+			},
+			&http.Request{
+				URL:    endpoint,
+				Method: http.MethodGet,
+				Header: http.Header{
+					"Content-Type": []string{"application/json"},
+					"User-Agent":   []string{"test"},
+				},
+			},
+			&http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Last-Modified": []string{fresh.Format(http.TimeFormat)},
+					"Date":          []string{fresh.Format(http.TimeFormat)},
+					"Vary":          []string{"content-type, user-agent"},
+				},
+			},
+			do200,
+			true,
+			&http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Age":           []string{"0"},
+					"Last-Modified": []string{fresh.Format(http.TimeFormat)},
+					"Date":          []string{fresh.Format(http.TimeFormat)},
+					"Vary":          []string{"content-type, user-agent"},
+				},
+			},
+		},
+		{
+			"Use origin response (Vary: Content-Type, User-Agent)",
+			&http.Request{
+				URL:    endpoint,
+				Method: http.MethodGet,
+				Header: http.Header{
+					"User-Agent":   []string{"test2"},
+					"Content-Type": []string{"application/json"},
+				},
+			},
+			&http.Request{
+				URL:    endpoint,
+				Method: http.MethodGet,
+				Header: http.Header{
+					"Content-Type": []string{"application/json"},
+					"User-Agent":   []string{"test"},
+				},
+			},
+			&http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Last-Modified": []string{fresh.Format(http.TimeFormat)},
+					"Vary":          []string{"content-type, user-agent"},
+				},
+			},
+			do200,
+			false,
+			origin200res,
+		},
+		{
+			"Use origin response (Cache-Control: no-cache)",
+			&http.Request{
+				URL:    endpoint,
+				Method: http.MethodGet,
+				Header: http.Header{},
+			},
+			&http.Request{
+				URL:    endpoint,
+				Method: http.MethodGet,
+				Header: http.Header{},
+			},
+			&http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Last-Modified": []string{fresh.Format(http.TimeFormat)},
+					"Cache-Control": []string{"no-cache"},
+				},
+			},
+			do200,
+			false,
+			origin200res,
+		},
+		{
+			"Validate and use origin response",
+			&http.Request{
+				URL:    endpoint,
+				Method: http.MethodGet,
+				Header: http.Header{},
+			},
+			&http.Request{
+				URL:    endpoint,
+				Method: http.MethodGet,
+				Header: http.Header{},
+			},
+			&http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Last-Modified": []string{stale.Format(http.TimeFormat)},
+					"Date":          []string{stale.Format(http.TimeFormat)},
+					"Cache-Control": []string{"must-revalidate"},
+				},
+			},
+			do200,
+			false,
+			origin200res,
+		},
+		{
+			"Validate and use cached response",
+			&http.Request{
+				URL:    endpoint,
+				Method: http.MethodGet,
+				Header: http.Header{},
+			},
+			&http.Request{
+				URL:    endpoint,
+				Method: http.MethodGet,
+				Header: http.Header{},
+			},
+			&http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Last-Modified": []string{stale.Format(http.TimeFormat)},
+					"Date":          []string{stale.Format(http.TimeFormat)},
+					"Cache-Control": []string{"must-revalidate"},
+				},
+			},
+			do304,
+			true,
+			&http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Age":           []string{"15"},
+					"Last-Modified": []string{stale.Format(http.TimeFormat)},
+					"Date":          []string{stale.Format(http.TimeFormat)},
+					"Cache-Control": []string{"must-revalidate"},
+				},
+			},
+		},
+		{
+			"Validate and use freshened cached response",
+			&http.Request{
+				URL:    endpoint,
+				Method: http.MethodGet,
+				Header: http.Header{},
+			},
+			&http.Request{
+				URL:    endpoint,
+				Method: http.MethodGet,
+				Header: http.Header{},
+			},
+			&http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Date":           []string{stale.Format(http.TimeFormat)},
+					"Etag":           []string{`"a"`},
+					"Cache-Control":  []string{"max-age=10, must-revalidate"},
+					"Content-Length": []string{"5"},
+				},
+			},
+			do304Freshen,
+			true,
+			&http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Age":            []string{"0"},
+					"Date":           []string{now.Format(http.TimeFormat)},
+					"Etag":           []string{`"a"`},
+					"Cache-Control":  []string{"max-age=60"},
+					"Content-Length": []string{"5"},
+				},
+			},
+		},
+		{
+			"Validate and use origin response (304 with other ETag)",
+			&http.Request{
+				URL:    endpoint,
+				Method: http.MethodGet,
+				Header: http.Header{},
+			},
+			&http.Request{
+				URL:    endpoint,
+				Method: http.MethodGet,
+				Header: http.Header{},
+			},
+			&http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Date":          []string{stale.Format(http.TimeFormat)},
+					"Etag":          []string{`"a"`},
+					"Cache-Control": []string{"max-age=10, must-revalidate"},
+				},
+			},
+			do304OtherETag,
+			false,
+			origin200res,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			//t.Parallel()
+			s, err := NewShared()
+			if err != nil {
+				t.Errorf("Shared.Handle() error = %v", err)
+				return
+			}
+			gotCacheUsed, gotRes, err := s.Handle(tt.req, tt.cachedReq, tt.cachedRes, tt.do, now)
+			if err != nil {
+				t.Errorf("Shared.Handle() error = %v", err)
+				return
+			}
+			if gotCacheUsed != tt.wantCacheUsed {
+				t.Errorf("Shared.Handle() gotCacheUsed = %v, want %v", gotCacheUsed, tt.wantCacheUsed)
+			}
+			if diff := cmp.Diff(gotRes, tt.wantRes); diff != "" {
+				t.Errorf("Shared.Handle() gotRes != tt.wantRes:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestShared_HandleOnlyIfCached(t *testing.T) {
+	now := time.Date(2024, 12, 13, 14, 15, 16, 00, time.UTC)
+	stale := now.Add(-15 * time.Second)
+	endpoint, err := url.Parse("https://example.com/api/v1/path/to/resource")
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := func(req *http.Request) (*http.Response, error) {
+		t.Error("do should not be called")
+		return nil, nil
+	}
+
+	tests := []struct {
+		name      string
+		cachedRes *http.Response
+	}{
+		{
+			"No cached response",
+			nil,
+		},
+		{
+			"Stale cached response (Cache-Control: must-revalidate)",
+			&http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Cache-Control": []string{"max-age=10, must-revalidate"},
+					"Date":          []string{stale.Format(http.TimeFormat)},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewShared()
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := &http.Request{
+				URL:    endpoint,
+				Method: http.MethodGet,
+				Header: http.Header{
+					"Cache-Control": []string{"only-if-cached"},
+				},
+			}
+			var cachedReq *http.Request
+			if tt.cachedRes != nil {
+				cachedReq = &http.Request{URL: endpoint, Method: http.MethodGet}
+			}
+			gotCacheUsed, gotRes, err := s.Handle(req, cachedReq, tt.cachedRes, do, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotCacheUsed {
+				t.Error("Shared.Handle() gotCacheUsed = true, want false")
+			}
+			if gotRes.StatusCode != http.StatusGatewayTimeout {
+				t.Errorf("Shared.Handle() got status %d, want %d", gotRes.StatusCode, http.StatusGatewayTimeout)
+			}
+		})
+	}
+}
+
+func TestShared_SharedOption(t *testing.T) {
+	now := time.Date(2024, 12, 13, 14, 15, 16, 00, time.UTC)
+
+	tests := []struct {
+		name        string
+		opts        []SharedOption
+		req         *http.Request
+		res         *http.Response
+		wantOK      bool
+		wantExpires time.Time
+	}{
+		{
+			"GET 200 Last-Modified: 2024-12-13 14:15:06 -> +1s",
+			[]SharedOption{},
+			&http.Request{
+				Method: http.MethodGet,
+			},
+			&http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Last-Modified": []string{"Mon, 13 Dec 2024 14:15:06 GMT"},
+				},
+			},
+			true,
+			time.Date(2024, 12, 13, 14, 15, 17, 00, time.UTC),
+		},
+		{
+			"HeuristicExpirationRatio 0.5 GET 200 Last-Modified: 2024-12-13 14:15:06 -> +5s",
+			[]SharedOption{
+				HeuristicExpirationRatio(0.5),
+			},
+			&http.Request{
+				Method: http.MethodGet,
+			},
+			&http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Last-Modified": []string{"Mon, 13 Dec 2024 14:15:06 GMT"},
+				},
+			},
+			true,
+			time.Date(2024, 12, 13, 14, 15, 21, 00, time.UTC),
+		},
+		{
+			"MaxHeuristicLifetime 1m GET 200 Last-Modified: 2024-12-12 14:15:16 -> +1m",
+			[]SharedOption{
+				MaxHeuristicLifetime(time.Minute),
+			},
+			&http.Request{
+				Method: http.MethodGet,
+			},
+			&http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Last-Modified": []string{"Mon, 12 Dec 2024 14:15:16 GMT"},
+				},
+			},
+			true,
+			time.Date(2024, 12, 13, 14, 16, 16, 00, time.UTC),
+		},
+		{
+			"MinHeuristicLifetime 30s GET 200 -> +30s",
+			[]SharedOption{
+				MinHeuristicLifetime(30 * time.Second),
+			},
+			&http.Request{
+				Method: http.MethodGet,
+			},
+			&http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{},
+			},
+			true,
+			time.Date(2024, 12, 13, 14, 15, 46, 00, time.UTC),
+		},
+		{
+			"MinHeuristicLifetime 30s GET 200 Cache-Control: max-age=10 -> +10s",
+			[]SharedOption{
+				MinHeuristicLifetime(30 * time.Second),
+			},
+			&http.Request{
+				Method: http.MethodGet,
+			},
+			&http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Cache-Control": []string{"max-age=10"},
+				},
+			},
+			true,
+			time.Date(2024, 12, 13, 14, 15, 26, 00, time.UTC),
+		},
+		{
+			"HeuristicLifetime 404 30s GET 404 Last-Modified: 2024-12-13 14:15:06 -> +30s",
+			[]SharedOption{
+				HeuristicLifetime(http.StatusNotFound, 30*time.Second),
+			},
+			&http.Request{
+				Method: http.MethodGet,
+			},
+			&http.Response{
+				StatusCode: http.StatusNotFound,
+				Header: http.Header{
+					"Last-Modified": []string{"Mon, 13 Dec 2024 14:15:06 GMT"},
+				},
+			},
+			true,
+			time.Date(2024, 12, 13, 14, 15, 46, 00, time.UTC),
+		},
+		{
+			"HeuristicLifetime 500 5s GET 500 -> +5s",
+			[]SharedOption{
+				HeuristicLifetime(http.StatusInternalServerError, 5*time.Second),
+			},
+			&http.Request{
+				Method: http.MethodGet,
+			},
+			&http.Response{
+				StatusCode: http.StatusInternalServerError,
+				Header:     http.Header{},
+			},
+			true,
+			time.Date(2024, 12, 13, 14, 15, 21, 00, time.UTC),
+		},
+		{
+			"HeuristicallyCacheableStatusCodes 200 GET 404 Last-Modified: 2024-12-13 14:15:06 -> No Store",
+			[]SharedOption{
+				HeuristicallyCacheableStatusCodes(http.StatusOK),
+			},
+			&http.Request{
+				Method: http.MethodGet,
+			},
+			&http.Response{
+				StatusCode: http.StatusNotFound,
+				Header: http.Header{
+					"Last-Modified": []string{"Mon, 13 Dec 2024 14:15:06 GMT"},
+				},
+			},
+			false,
+			time.Time{},
+		},
+		{
+			"UnderstoodMethods GET POST 200 Cache-Control: max-age=10 -> No Store",
+			[]SharedOption{
+				UnderstoodMethods(http.MethodGet),
+			},
+			&http.Request{
+				Method: http.MethodPost,
+			},
+			&http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Cache-Control": []string{"max-age=10"},
+				},
+			},
+			false,
+			time.Time{},
+		},
+		{
+			"UnderstoodStatusCodes 200 GET 404 Cache-Control: max-age=10, must-understand -> No Store",
+			[]SharedOption{
+				UnderstoodStatusCodes(http.StatusOK),
+			},
+			&http.Request{
+				Method: http.MethodGet,
+			},
+			&http.Response{
+				StatusCode: http.StatusNotFound,
+				Header: http.Header{
+					"Cache-Control": []string{"max-age=10, must-understand"},
+				},
+			},
+			false,
+			time.Time{},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
 			s, err := NewShared(tt.opts...)
 			if err != nil {
@@ -721,3 +1378,113 @@ func TestShared_SharedOption(t *testing.T) {
 		})
 	}
 }
+
+func TestShared_SharedOptionError(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []SharedOption
+	}{
+		{"MaxHeuristicLifetime", []SharedOption{MaxHeuristicLifetime(-time.Second)}},
+		{"MinHeuristicLifetime", []SharedOption{MinHeuristicLifetime(-time.Second)}},
+		{"HeuristicLifetime", []SharedOption{HeuristicLifetime(http.StatusNotFound, -time.Second)}},
+		{"MinHeuristicLifetime greater than MaxHeuristicLifetime", []SharedOption{MinHeuristicLifetime(time.Hour), MaxHeuristicLifetime(time.Minute)}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if _, err := NewShared(tt.opts...); err == nil {
+				t.Error("NewShared() want error")
+			}
+		})
+	}
+}
+
+func TestShared_HandleStaleExtensions(t *testing.T) {
+	now := time.Date(2024, 12, 13, 14, 15, 16, 00, time.UTC)
+	stale := now.Add(-15 * time.Second)
+	endpoint, err := url.Parse("https://example.com/api/v1/path/to/resource")
+	if err != nil {
+		t.Fatal(err)
+	}
+	origin200res := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+	}
+	origin503res := &http.Response{
+		StatusCode: http.StatusServiceUnavailable,
+		Header:     http.Header{},
+	}
+	errOrigin := errors.New("connection refused")
+
+	tests := []struct {
+		name           string
+		reqcc          string
+		rescc          string
+		res            *http.Response
+		err            error
+		wantCacheUsed  bool
+		wantStatus     int
+		wantErr        bool
+		wantBackground bool
+	}{
+		{"stale-while-revalidate: in window", "", "max-age=10, stale-while-revalidate=10", origin200res, nil, true, http.StatusOK, false, true},
+		{"stale-while-revalidate: out of window", "", "max-age=10, stale-while-revalidate=1", origin200res, nil, false, http.StatusOK, false, false},
+		{"stale-while-revalidate: must-revalidate", "", "max-age=10, stale-while-revalidate=10, must-revalidate", origin200res, nil, false, http.StatusOK, false, false},
+		{"stale-while-revalidate: request no-cache", "no-cache", "max-age=10, stale-while-revalidate=10", origin200res, nil, false, http.StatusOK, false, false},
+		{"stale-while-revalidate: s-maxage", "", "s-maxage=10, stale-while-revalidate=10", origin200res, nil, true, http.StatusOK, false, true},
+		{"stale-if-error: 503 in window", "", "s-maxage=10, stale-if-error=10", origin503res, nil, true, http.StatusOK, false, false},
+		{"stale-if-error: error in window", "", "s-maxage=10, stale-if-error=10", nil, errOrigin, true, http.StatusOK, false, false},
+		{"stale-if-error: 503 out of window", "", "s-maxage=10, stale-if-error=1", origin503res, nil, false, http.StatusServiceUnavailable, false, false},
+		{"stale-if-error: error out of window", "", "s-maxage=10, stale-if-error=1", nil, errOrigin, false, 0, true, false},
+		{"stale-if-error: request directive", "stale-if-error=10", "s-maxage=10, stale-if-error=1", origin503res, nil, true, http.StatusOK, false, false},
+		{"stale-if-error: must-revalidate", "", "max-age=10, stale-if-error=10, must-revalidate", origin503res, nil, false, http.StatusServiceUnavailable, false, false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			s, err := NewShared()
+			if err != nil {
+				t.Fatal(err)
+			}
+			background := make(chan struct{}, 1)
+			do := func(req *http.Request) (*http.Response, error) {
+				if httpcache.IsBackground(req.Context()) {
+					background <- struct{}{}
+					return origin200res, nil
+				}
+				return tt.res, tt.err
+			}
+			req := &http.Request{URL: endpoint, Method: http.MethodGet, Header: http.Header{}}
+			if tt.reqcc != "" {
+				req.Header.Set("Cache-Control", tt.reqcc)
+			}
+			cachedReq := &http.Request{URL: endpoint, Method: http.MethodGet, Header: http.Header{}}
+			cachedRes := &http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Cache-Control": []string{tt.rescc},
+					"Date":          []string{stale.Format(http.TimeFormat)},
+				},
+			}
+			gotCacheUsed, gotRes, err := s.Handle(req, cachedReq, cachedRes, do, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Shared.Handle() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if gotCacheUsed != tt.wantCacheUsed {
+				t.Errorf("Shared.Handle() gotCacheUsed = %v, want %v", gotCacheUsed, tt.wantCacheUsed)
+			}
+			if gotRes != nil && gotRes.StatusCode != tt.wantStatus {
+				t.Errorf("Shared.Handle() got status %d, want %d", gotRes.StatusCode, tt.wantStatus)
+			}
+			if tt.wantBackground {
+				select {
+				case <-background:
+				case <-time.After(time.Second):
+					t.Error("Shared.Handle() did not refresh in the background")
+				}
+			}
+		})
+	}
+}
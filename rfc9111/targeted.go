@@ -0,0 +1,98 @@
+package rfc9111
+
+import (
+	"errors"
+	"math"
+	"net/http"
+)
+
+var errEmptyTargetedField = errors.New("empty targeted cache control field")
+
+// TargetedFields sets the targeted cache control fields (https://www.rfc-editor.org/rfc/rfc9213) that the cache honors, in order of precedence.
+// For example, a CDN sets "CDN-Cache-Control".
+func TargetedFields(fields ...string) SharedOption {
+	return func(s *Shared) error {
+		s.targetedFields = canonicalHeaderKeys(fields)
+		return nil
+	}
+}
+
+// ParseTargetedCacheControlHeader parses a targeted cache control field such as CDN-Cache-Control (https://www.rfc-editor.org/rfc/rfc9213#section-2.1).
+// It returns an error if the field value is not a valid, non-empty Structured Fields Dictionary.
+func ParseTargetedCacheControlHeader(headers []string) (*ResponseDirectives, error) {
+	members, err := parseSFDictionary(headers)
+	if err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		return nil, errEmptyTargetedField
+	}
+	d := &ResponseDirectives{}
+	for _, m := range members {
+		// Parameters on the members of the field are ignored (https://www.rfc-editor.org/rfc/rfc9213#section-2.1).
+		switch v := m.value.(type) {
+		case bool:
+			// A directive without an argument has a Boolean true value.
+			if !v {
+				continue
+			}
+			switch m.key {
+			case "must-revalidate":
+				d.MustRevalidate = true
+			case "must-understand":
+				d.MustUnderstand = true
+			case "no-cache":
+				d.NoCache = true
+			case "no-store":
+				d.NoStore = true
+			case "no-transform":
+				d.NoTransform = true
+			case "private":
+				d.Private = true
+			case "proxy-revalidate":
+				d.ProxyRevalidate = true
+			case "public":
+				d.Public = true
+			}
+		case int64:
+			// A directive with delta-seconds has an Integer value.
+			if v < 0 {
+				continue
+			}
+			u32 := uint32(min(v, math.MaxUint32))
+			switch m.key {
+			case "max-age":
+				d.MaxAge = &u32
+			case "s-maxage":
+				d.SMaxAge = &u32
+			case "stale-while-revalidate":
+				d.StaleWhileRevalidate = &u32
+			case "stale-if-error":
+				d.StaleIfError = &u32
+			}
+		default:
+			// A cache MUST ignore unrecognized cache directives. (https://www.rfc-editor.org/rfc/rfc9111#section-5.2.3)
+		}
+	}
+	return d, nil
+}
+
+// responseDirectives returns the directives that determine the caching policy of the response, and the header fields to use with them.
+// A cache MUST select the first (in target list order) field with a valid, non-empty value and use its value to determine the caching policy for the response,
+// and it MUST ignore the Cache-Control and Expires header fields in that response, unless no valid, non-empty value is available from the listed header fields (https://www.rfc-editor.org/rfc/rfc9213#section-2.2).
+func (c *cache) responseDirectives(header http.Header) (*ResponseDirectives, http.Header) {
+	for _, f := range c.targetedFields {
+		values := header.Values(f)
+		if len(values) == 0 {
+			continue
+		}
+		d, err := ParseTargetedCacheControlHeader(values)
+		if err != nil {
+			continue
+		}
+		h := header.Clone()
+		h.Del("Expires")
+		return d, h
+	}
+	return ParseResponseCacheControlHeader(header.Values("Cache-Control")), header
+}
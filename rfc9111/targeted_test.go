@@ -0,0 +1,140 @@
+package rfc9111
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseTargetedCacheControlHeader(t *testing.T) {
+	u32 := func(v uint32) *uint32 { return &v }
+	tests := []struct {
+		name    string
+		headers []string
+		want    *ResponseDirectives
+		wantErr bool
+	}{
+		{"max-age", []string{"max-age=60"}, &ResponseDirectives{MaxAge: u32(60)}, false},
+		{"flags and integers", []string{"no-store, s-maxage=10, must-revalidate"}, &ResponseDirectives{NoStore: true, SMaxAge: u32(10), MustRevalidate: true}, false},
+		{"multiple lines", []string{"max-age=60", "public"}, &ResponseDirectives{MaxAge: u32(60), Public: true}, false},
+		{"parameters are ignored", []string{"max-age=60;foo=bar, private;a"}, &ResponseDirectives{MaxAge: u32(60), Private: true}, false},
+		{"the last value is used", []string{"max-age=60, max-age=10"}, &ResponseDirectives{MaxAge: u32(10)}, false},
+		{"false flag", []string{"no-store=?0"}, &ResponseDirectives{}, false},
+		{"unknown directives", []string{`foo="bar", baz=(a b), max-age=1.5`}, &ResponseDirectives{}, false},
+		{"negative integer", []string{"max-age=-1"}, &ResponseDirectives{}, false},
+		{"not a Structured Field", []string{"max-age=60,,"}, nil, true},
+		{"uppercase key", []string{"Max-Age=60"}, nil, true},
+		{"quoted string with a comma", []string{`no-cache="a, b", max-age=60`}, &ResponseDirectives{MaxAge: u32(60)}, false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParseTargetedCacheControlHeader(tt.headers)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseTargetedCacheControlHeader() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestShared_TargetedFields(t *testing.T) {
+	now := time.Date(2024, 12, 13, 14, 15, 16, 00, time.UTC)
+	tests := []struct {
+		name        string
+		opts        []SharedOption
+		header      http.Header
+		wantOK      bool
+		wantExpires time.Time
+	}{
+		{
+			"CDN-Cache-Control takes precedence over Cache-Control",
+			[]SharedOption{TargetedFields("CDN-Cache-Control")},
+			http.Header{
+				"Cache-Control":     []string{"no-store"},
+				"Cdn-Cache-Control": []string{"max-age=60"},
+			},
+			true,
+			now.Add(60 * time.Second),
+		},
+		{
+			"Expires is ignored",
+			[]SharedOption{TargetedFields("CDN-Cache-Control")},
+			http.Header{
+				"Expires":           []string{"Fri, 13 Dec 2024 15:15:16 GMT"},
+				"Cdn-Cache-Control": []string{"public"},
+			},
+			false,
+			time.Time{},
+		},
+		{
+			"the first field in the target list is used",
+			[]SharedOption{TargetedFields("Example-Cache-Control", "CDN-Cache-Control")},
+			http.Header{
+				"Cdn-Cache-Control":     []string{"max-age=60"},
+				"Example-Cache-Control": []string{"max-age=10"},
+			},
+			true,
+			now.Add(10 * time.Second),
+		},
+		{
+			"invalid targeted field falls back",
+			[]SharedOption{TargetedFields("Example-Cache-Control", "CDN-Cache-Control")},
+			http.Header{
+				"Cache-Control":         []string{"max-age=30"},
+				"Cdn-Cache-Control":     []string{"no-store"},
+				"Example-Cache-Control": []string{"max-age=10,"},
+			},
+			false,
+			time.Time{},
+		},
+		{
+			"invalid targeted fields fall back to Cache-Control",
+			[]SharedOption{TargetedFields("CDN-Cache-Control")},
+			http.Header{
+				"Cache-Control":     []string{"max-age=30"},
+				"Cdn-Cache-Control": []string{`max-age="10`},
+			},
+			true,
+			now.Add(30 * time.Second),
+		},
+		{
+			"not targeted",
+			nil,
+			http.Header{
+				"Cache-Control":     []string{"no-store"},
+				"Cdn-Cache-Control": []string{"max-age=60"},
+			},
+			false,
+			time.Time{},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			s, err := NewShared(tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := &http.Request{Method: http.MethodGet, Header: http.Header{}}
+			res := &http.Response{StatusCode: http.StatusNotFound, Header: tt.header}
+			gotOK, gotExpires := s.Storable(req, res, now)
+			if gotOK != tt.wantOK {
+				t.Errorf("Shared.Storable() gotOK = %v, want %v", gotOK, tt.wantOK)
+			}
+			if !gotExpires.Equal(tt.wantExpires) {
+				t.Errorf("Shared.Storable() gotExpires = %v, want %v", gotExpires, tt.wantExpires)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package httpcache
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// ErrCacheMiss is returned by Storage.Get when no entry is stored for the key.
+var ErrCacheMiss = errors.New("cache miss")
+
+// Storage is the interface for storing requests and responses by cache key.
+// Implementations must be safe for concurrent use by multiple goroutines.
+type Storage interface { //nostyle:ifacenames
+	// Get returns the stored request and response for the key.
+	// If no entry is stored, Get returns ErrCacheMiss.
+	// The body of the returned response must be readable independently of other calls to Get.
+	Get(key string) (*http.Request, *http.Response, error)
+	// Set stores the request and response for the key, replacing any existing entry.
+	// expires is the value returned by Handler.Storable.
+	// Set reads the body of res to EOF, and must not store the entry if reading it fails.
+	Set(key string, req *http.Request, res *http.Response, expires time.Time) error
+	// Delete deletes the entry for the key. Deleting a key that is not stored is not an error.
+	Delete(key string) error
+	// Range calls fn sequentially for each stored key and its expires. If fn returns false, Range stops the iteration.
+	// fn may call Delete.
+	Range(fn func(key string, expires time.Time) bool) error
+}
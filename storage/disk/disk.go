@@ -0,0 +1,269 @@
+// Package disk provides a filesystem-backed httpcache.Storage.
+package disk
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/k1LoW/httpcache"
+)
+
+var _ httpcache.Storage = (*Storage)(nil)
+
+const (
+	entryExt  = ".cache"
+	tmpPrefix = ".tmp-"
+	// tmpAge is the age after which Purge deletes a temporary file. It is long enough that a concurrent Set is not still writing the file.
+	tmpAge = 24 * time.Hour
+)
+
+// Storage is a storage that persists entries as files under a directory.
+// Each entry is written to a temporary file and atomically renamed, so entries survive restarts and are never partially written.
+type Storage struct {
+	dir string
+}
+
+// meta is the metadata of an entry. It is written as the first line of the entry file, followed by the response body.
+type meta struct {
+	Key     string      `json:"key"`
+	Expires time.Time   `json:"expires"`
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Host    string      `json:"host,omitempty"`
+	ReqH    http.Header `json:"request_header"`
+	Status  string      `json:"status"`
+	Code    int         `json:"status_code"`
+	Proto   string      `json:"proto"`
+	Major   int         `json:"proto_major"`
+	Minor   int         `json:"proto_minor"`
+	ResH    http.Header `json:"response_header"`
+}
+
+// New returns a new Storage that stores entries under dir. The directory is created if it does not exist.
+func New(dir string) (*Storage, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &Storage{dir: dir}, nil
+}
+
+// Get returns the stored request and response for the key.
+// The body of the returned response is read from the file, so it must be closed.
+// A corrupt entry is deleted, and Get returns ErrCacheMiss for it.
+func (s *Storage) Get(key string) (*http.Request, *http.Response, error) {
+	f, err := os.Open(s.path(key))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil, httpcache.ErrCacheMiss
+		}
+		return nil, nil, err
+	}
+	br := bufio.NewReader(f)
+	m, n, err := readMeta(br)
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, s.deleteCorrupt(key)
+	}
+	// The body follows the metadata line, so its length is known from the size of the file.
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, err
+	}
+	if m.Key != key {
+		// Hash collision.
+		_ = f.Close()
+		return nil, nil, httpcache.ErrCacheMiss
+	}
+	u, err := url.Parse(m.URL)
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, s.deleteCorrupt(key)
+	}
+	req := &http.Request{
+		Method: m.Method,
+		URL:    u,
+		Host:   m.Host,
+		Header: m.ReqH,
+	}
+	res := &http.Response{
+		Status:        m.Status,
+		StatusCode:    m.Code,
+		Proto:         m.Proto,
+		ProtoMajor:    m.Major,
+		ProtoMinor:    m.Minor,
+		Header:        m.ResH,
+		Body:          &body{Reader: br, f: f},
+		ContentLength: info.Size() - n,
+		Request:       req,
+	}
+	return req, res, nil
+}
+
+// Set stores the request and response for the key.
+func (s *Storage) Set(key string, req *http.Request, res *http.Response, expires time.Time) (err error) {
+	tmp, err := os.CreateTemp(s.dir, tmpPrefix)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+	m := &meta{
+		Key:     key,
+		Expires: expires,
+		Method:  req.Method,
+		URL:     req.URL.String(),
+		Host:    req.Host,
+		ReqH:    req.Header,
+		Status:  res.Status,
+		Code:    res.StatusCode,
+		Proto:   res.Proto,
+		Major:   res.ProtoMajor,
+		Minor:   res.ProtoMinor,
+		ResH:    res.Header,
+	}
+	bw := bufio.NewWriter(tmp)
+	if err := json.NewEncoder(bw).Encode(m); err != nil {
+		return err
+	}
+	if _, err := io.Copy(bw, res.Body); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(key))
+}
+
+// Delete deletes the entry for the key.
+func (s *Storage) Delete(key string) error {
+	if err := os.Remove(s.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Range calls fn for each stored key. Entries that cannot be read are skipped.
+func (s *Storage) Range(fn func(key string, expires time.Time) bool) error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), entryExt) {
+			continue
+		}
+		m, err := s.readMetaFile(filepath.Join(s.dir, e.Name()))
+		if err != nil {
+			// Deleted during the iteration, or corrupt. A corrupt entry is deleted when it is got.
+			continue
+		}
+		if !fn(m.Key, m.Expires) {
+			break
+		}
+	}
+	return nil
+}
+
+// Purge deletes entries that expired before t, and temporary files left by interrupted writes.
+// Since stale entries can still be served or validated, t is usually earlier than the current time.
+// Temporary files are deleted only if they are older than a day regardless of t, so that concurrent writes are not broken.
+func (s *Storage) Purge(t time.Time) error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	tmpBefore := time.Now().Add(-tmpAge)
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), tmpPrefix) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if info.ModTime().Before(tmpBefore) {
+			_ = os.Remove(filepath.Join(s.dir, e.Name()))
+		}
+	}
+	var errs []error
+	if err := s.Range(func(key string, expires time.Time) bool {
+		if expires.Before(t) {
+			if err := s.Delete(key); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return true
+	}); err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
+
+// deleteCorrupt deletes the corrupt entry for the key, and returns ErrCacheMiss.
+func (s *Storage) deleteCorrupt(key string) error {
+	if err := s.Delete(key); err != nil {
+		return err
+	}
+	return httpcache.ErrCacheMiss
+}
+
+func (s *Storage) path(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(h[:])+entryExt)
+}
+
+func (s *Storage) readMetaFile(p string) (*meta, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m, _, err := readMeta(bufio.NewReader(f))
+	return m, err
+}
+
+// readMeta reads the metadata line, and returns the metadata and the length of the line.
+func readMeta(br *bufio.Reader) (*meta, int64, error) {
+	line, err := br.ReadBytes('\n')
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid cache entry: %w", err)
+	}
+	m := &meta{}
+	if err := json.Unmarshal(line, m); err != nil {
+		return nil, 0, fmt.Errorf("invalid cache entry: %w", err)
+	}
+	return m, int64(len(line)), nil
+}
+
+// body is the body of a stored response read from the entry file.
+type body struct {
+	io.Reader
+	f *os.File
+}
+
+func (b *body) Close() error {
+	return b.f.Close()
+}
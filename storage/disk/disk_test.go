@@ -0,0 +1,233 @@
+package disk
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/k1LoW/httpcache"
+)
+
+func newEntry(t *testing.T, path string, body io.Reader) (*http.Request, *http.Response) {
+	t.Helper()
+	u, err := url.Parse("https://example.com" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    u,
+		Header: http.Header{"Accept": []string{"text/plain"}},
+	}
+	res := &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Cache-Control": []string{"max-age=60"}},
+		Body:       io.NopCloser(body),
+	}
+	return req, res
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+func TestStorage(t *testing.T) {
+	dir := t.TempDir()
+	expires := time.Date(2024, 12, 13, 14, 15, 16, 00, time.UTC)
+	s, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Get("a"); !errors.Is(err, httpcache.ErrCacheMiss) {
+		t.Errorf("Storage.Get() error = %v, want %v", err, httpcache.ErrCacheMiss)
+	}
+	req, res := newEntry(t, "/a", strings.NewReader("hello\nworld"))
+	if err := s.Set("a", req, res, expires); err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries survive restarts.
+	s2, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotReq, gotRes, err := s2.Get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotReq.URL.String() != "https://example.com/a" {
+		t.Errorf("got URL %s", gotReq.URL.String())
+	}
+	if gotReq.Header.Get("Accept") != "text/plain" {
+		t.Errorf("got Accept %s", gotReq.Header.Get("Accept"))
+	}
+	if gotRes.StatusCode != http.StatusOK {
+		t.Errorf("got status %d", gotRes.StatusCode)
+	}
+	if gotRes.Header.Get("Cache-Control") != "max-age=60" {
+		t.Errorf("got Cache-Control %s", gotRes.Header.Get("Cache-Control"))
+	}
+	if gotRes.ContentLength != int64(len("hello\nworld")) {
+		t.Errorf("got ContentLength %d, want %d", gotRes.ContentLength, len("hello\nworld"))
+	}
+	b, err := io.ReadAll(gotRes.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gotRes.Body.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello\nworld" {
+		t.Errorf("got body %q", string(b))
+	}
+
+	var keys []string
+	if err := s2.Range(func(key string, e time.Time) bool {
+		keys = append(keys, key)
+		if !e.Equal(expires) {
+			t.Errorf("got expires %v, want %v", e, expires)
+		}
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "a" {
+		t.Errorf("got keys %v", keys)
+	}
+
+	if err := s2.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s2.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s2.Get("a"); !errors.Is(err, httpcache.ErrCacheMiss) {
+		t.Errorf("Storage.Get() error = %v, want %v", err, httpcache.ErrCacheMiss)
+	}
+}
+
+func TestStorage_SetIncompleteBody(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, res := newEntry(t, "/a", io.MultiReader(strings.NewReader("hel"), errReader{}))
+	if err := s.Set("a", req, res, time.Time{}); err == nil {
+		t.Error("Storage.Set() want error")
+	}
+	if _, _, err := s.Get("a"); !errors.Is(err, httpcache.ErrCacheMiss) {
+		t.Errorf("Storage.Get() error = %v, want %v", err, httpcache.ErrCacheMiss)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("got %d files, want 0", len(entries))
+	}
+}
+
+func TestStorage_Purge(t *testing.T) {
+	now := time.Date(2024, 12, 13, 14, 15, 16, 00, time.UTC)
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, expires := range map[string]time.Time{
+		"expired": now.Add(-time.Second),
+		"fresh":   now.Add(time.Second),
+	} {
+		req, res := newEntry(t, "/"+k, strings.NewReader(k))
+		if err := s.Set(k, req, res, expires); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Purge(now); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Get("expired"); !errors.Is(err, httpcache.ErrCacheMiss) {
+		t.Errorf("Storage.Get() error = %v, want %v", err, httpcache.ErrCacheMiss)
+	}
+	_, res, err := s.Get("fresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+}
+
+func TestStorage_Corrupt(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, res := newEntry(t, "/a", strings.NewReader("a"))
+	if err := s.Set("a", req, res, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(s.path("corrupt"), []byte(`{"key":"corr`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	if err := s.Range(func(key string, _ time.Time) bool {
+		keys = append(keys, key)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "a" {
+		t.Errorf("got keys %v", keys)
+	}
+	if err := s.Purge(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := s.Get("corrupt"); !errors.Is(err, httpcache.ErrCacheMiss) {
+		t.Errorf("Storage.Get() error = %v, want %v", err, httpcache.ErrCacheMiss)
+	}
+	if _, err := os.Stat(s.path("corrupt")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the corrupt entry is not deleted: %v", err)
+	}
+}
+
+func TestStorage_PurgeTemporaryFiles(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := filepath.Join(dir, tmpPrefix+"old")
+	writing := filepath.Join(dir, tmpPrefix+"writing")
+	for _, p := range []string{old, writing} {
+		if err := os.WriteFile(p, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mtime := time.Now().Add(-2 * tmpAge)
+	if err := os.Chtimes(old, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	// A temporary file being written by a concurrent Set is not deleted even if t is in the future.
+	if err := s.Purge(time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(old); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the old temporary file is not deleted: %v", err)
+	}
+	if _, err := os.Stat(writing); err != nil {
+		t.Errorf("the temporary file being written is deleted: %v", err)
+	}
+}
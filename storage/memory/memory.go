@@ -0,0 +1,255 @@
+// Package memory provides an in-memory httpcache.Storage with LRU eviction.
+package memory
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/k1LoW/httpcache"
+)
+
+var _ httpcache.Storage = (*Storage)(nil)
+
+// Storage is an in-memory storage that evicts the least recently used entries
+// when the number of entries or the total byte size exceeds the limits.
+// The stored variants of a response are evicted together with the entry for their primary key (see httpcache.PrimaryKey),
+// which lists them so that they are invalidated together.
+type Storage struct {
+	maxEntries int
+	maxBytes   int64
+	size       int64
+	ll         *list.List
+	entries    map[string]*list.Element
+	// variants maps the primary keys to the keys of their stored variants.
+	variants map[string]map[string]struct{}
+	mu       sync.Mutex
+}
+
+type entry struct {
+	key     string
+	method  string
+	url     *url.URL
+	host    string
+	reqh    http.Header
+	status  string
+	code    int
+	proto   string
+	major   int
+	minor   int
+	resh    http.Header
+	body    []byte
+	expires time.Time
+	size    int64
+}
+
+// Option is an option for Storage.
+type Option func(*Storage) error
+
+// MaxEntries sets the maximum number of entries. 0 means no limit.
+func MaxEntries(n int) Option {
+	return func(s *Storage) error {
+		if n < 0 {
+			return errors.New("max entries must not be negative")
+		}
+		s.maxEntries = n
+		return nil
+	}
+}
+
+// MaxBytes sets the maximum total byte size of entries. 0 means no limit.
+func MaxBytes(n int64) Option {
+	return func(s *Storage) error {
+		if n < 0 {
+			return errors.New("max bytes must not be negative")
+		}
+		s.maxBytes = n
+		return nil
+	}
+}
+
+// New returns a new in-memory Storage.
+func New(opts ...Option) (*Storage, error) {
+	s := &Storage{
+		ll:       list.New(),
+		entries:  map[string]*list.Element{},
+		variants: map[string]map[string]struct{}{},
+	}
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Get returns the stored request and response for the key.
+func (s *Storage) Get(key string) (*http.Request, *http.Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.entries[key]
+	if !ok {
+		return nil, nil, httpcache.ErrCacheMiss
+	}
+	s.ll.MoveToFront(el)
+	e := value(el)
+	u := *e.url
+	req := &http.Request{
+		Method: e.method,
+		URL:    &u,
+		Host:   e.host,
+		Header: e.reqh.Clone(),
+	}
+	res := &http.Response{
+		Status:        e.status,
+		StatusCode:    e.code,
+		Proto:         e.proto,
+		ProtoMajor:    e.major,
+		ProtoMinor:    e.minor,
+		Header:        e.resh.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(e.body)),
+		ContentLength: int64(len(e.body)),
+		Request:       req,
+	}
+	return req, res, nil
+}
+
+// Set stores the request and response for the key.
+// A body larger than the limit set by MaxBytes is not read to the end, and the entry is not stored.
+func (s *Storage) Set(key string, req *http.Request, res *http.Response, expires time.Time) error {
+	var body io.Reader = res.Body
+	if s.maxBytes > 0 {
+		body = io.LimitReader(res.Body, s.maxBytes+1)
+	}
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if s.maxBytes > 0 && int64(len(b)) > s.maxBytes {
+		// The entry can never fit. The existing entry is replaced, so it is deleted.
+		return s.Delete(key)
+	}
+	u := *req.URL
+	e := &entry{
+		key:     key,
+		method:  req.Method,
+		url:     &u,
+		host:    req.Host,
+		reqh:    req.Header.Clone(),
+		status:  res.Status,
+		code:    res.StatusCode,
+		proto:   res.Proto,
+		major:   res.ProtoMajor,
+		minor:   res.ProtoMinor,
+		resh:    res.Header.Clone(),
+		body:    b,
+		expires: expires,
+	}
+	e.size = int64(len(key)+len(u.String())+len(b)) + headerSize(e.reqh) + headerSize(e.resh)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[key]; ok {
+		s.remove(el)
+	}
+	if s.maxBytes > 0 && e.size > s.maxBytes {
+		// The entry can never fit.
+		return nil
+	}
+	s.entries[key] = s.ll.PushFront(e)
+	s.size += e.size
+	if primary, ok := httpcache.PrimaryKey(key); ok {
+		if s.variants[primary] == nil {
+			s.variants[primary] = map[string]struct{}{}
+		}
+		s.variants[primary][key] = struct{}{}
+	}
+	for (s.maxEntries > 0 && s.ll.Len() > s.maxEntries) || (s.maxBytes > 0 && s.size > s.maxBytes) {
+		s.evict(s.ll.Back())
+	}
+	return nil
+}
+
+// Delete deletes the entry for the key.
+func (s *Storage) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[key]; ok {
+		s.remove(el)
+	}
+	return nil
+}
+
+// Range calls fn for each stored key in most recently used order.
+func (s *Storage) Range(fn func(key string, expires time.Time) bool) error {
+	s.mu.Lock()
+	entries := make([]*entry, 0, s.ll.Len())
+	for el := s.ll.Front(); el != nil; el = el.Next() {
+		entries = append(entries, value(el))
+	}
+	s.mu.Unlock()
+	for _, e := range entries {
+		if !fn(e.key, e.expires) {
+			break
+		}
+	}
+	return nil
+}
+
+// Len returns the number of stored entries.
+func (s *Storage) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ll.Len()
+}
+
+// Size returns the total byte size of stored entries.
+func (s *Storage) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+func (s *Storage) remove(el *list.Element) {
+	e := value(el)
+	s.ll.Remove(el)
+	delete(s.entries, e.key)
+	s.size -= e.size
+	if primary, ok := httpcache.PrimaryKey(e.key); ok {
+		delete(s.variants[primary], e.key)
+		if len(s.variants[primary]) == 0 {
+			delete(s.variants, primary)
+		}
+	}
+}
+
+// evict removes the entry and the stored variants for its key, which would no longer be invalidated without the entry listing them.
+func (s *Storage) evict(el *list.Element) {
+	key := value(el).key
+	s.remove(el)
+	for vk := range s.variants[key] {
+		if el, ok := s.entries[vk]; ok {
+			s.remove(el)
+		}
+	}
+}
+
+func value(el *list.Element) *entry {
+	e, _ := el.Value.(*entry)
+	return e
+}
+
+func headerSize(h http.Header) int64 {
+	var n int64
+	for k, vv := range h {
+		for _, v := range vv {
+			n += int64(len(k) + len(v))
+		}
+	}
+	return n
+}
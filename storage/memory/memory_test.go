@@ -0,0 +1,202 @@
+package memory
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/k1LoW/httpcache"
+)
+
+func newEntry(t *testing.T, path, body string) (*http.Request, *http.Response) {
+	t.Helper()
+	u, err := url.Parse("https://example.com" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    u,
+		Header: http.Header{"Accept": []string{"text/plain"}},
+	}
+	res := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Cache-Control": []string{"max-age=60"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+	return req, res
+}
+
+func TestStorage(t *testing.T) {
+	expires := time.Date(2024, 12, 13, 14, 15, 16, 00, time.UTC)
+	s, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Get("a"); !errors.Is(err, httpcache.ErrCacheMiss) {
+		t.Errorf("Storage.Get() error = %v, want %v", err, httpcache.ErrCacheMiss)
+	}
+	req, res := newEntry(t, "/a", "hello")
+	if err := s.Set("a", req, res, expires); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		gotReq, gotRes, err := s.Get("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gotReq.URL.String() != "https://example.com/a" {
+			t.Errorf("got URL %s", gotReq.URL.String())
+		}
+		if gotReq.Header.Get("Accept") != "text/plain" {
+			t.Errorf("got Accept %s", gotReq.Header.Get("Accept"))
+		}
+		if gotRes.Header.Get("Cache-Control") != "max-age=60" {
+			t.Errorf("got Cache-Control %s", gotRes.Header.Get("Cache-Control"))
+		}
+		b, err := io.ReadAll(gotRes.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != "hello" {
+			t.Errorf("got body %q, want %q", string(b), "hello")
+		}
+	}
+	var keys []string
+	if err := s.Range(func(key string, e time.Time) bool {
+		keys = append(keys, key)
+		if !e.Equal(expires) {
+			t.Errorf("got expires %v, want %v", e, expires)
+		}
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "a" {
+		t.Errorf("got keys %v", keys)
+	}
+	if err := s.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Get("a"); !errors.Is(err, httpcache.ErrCacheMiss) {
+		t.Errorf("Storage.Get() error = %v, want %v", err, httpcache.ErrCacheMiss)
+	}
+	if s.Len() != 0 || s.Size() != 0 {
+		t.Errorf("got len %d size %d, want empty", s.Len(), s.Size())
+	}
+}
+
+func TestStorage_Eviction(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		wantKeys []string
+	}{
+		{"no limit", nil, []string{"d", "a", "c", "b"}},
+		{"MaxEntries(2)", []Option{MaxEntries(2)}, []string{"d", "c"}},
+		{"MaxEntries(3)", []Option{MaxEntries(3)}, []string{"d", "a", "c"}},
+		{"MaxBytes(250)", []Option{MaxBytes(250)}, []string{"d", "c"}},
+		{"MaxBytes(10)", []Option{MaxBytes(10)}, nil},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			s, err := New(tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, k := range []string{"a", "b", "c"} {
+				req, res := newEntry(t, "/"+k, strings.Repeat(k, 50))
+				if err := s.Set(k, req, res, time.Time{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Use "a" so that "b" becomes the least recently used entry.
+			_, _, _ = s.Get("a")
+			req, res := newEntry(t, "/d", strings.Repeat("d", 50))
+			if err := s.Set("d", req, res, time.Time{}); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			_ = s.Range(func(key string, _ time.Time) bool {
+				got = append(got, key)
+				return true
+			})
+			if fmt.Sprint(got) != fmt.Sprint(tt.wantKeys) {
+				t.Errorf("got keys %v, want %v", got, tt.wantKeys)
+			}
+		})
+	}
+}
+
+func TestStorage_Concurrent(t *testing.T) {
+	s, err := New(MaxEntries(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			k := fmt.Sprintf("%d", i%20)
+			req, res := newEntry(t, "/"+k, k)
+			_ = s.Set(k, req, res, time.Time{})
+			_, _, _ = s.Get(k)
+			_ = s.Range(func(key string, _ time.Time) bool {
+				return s.Delete(key) == nil
+			})
+		}(i)
+	}
+	wg.Wait()
+	if s.Len() > 10 {
+		t.Errorf("got len %d, want <= 10", s.Len())
+	}
+}
+
+// countingReader is an endless body that counts the bytes read from it.
+type countingReader struct {
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'a'
+	}
+	r.n += int64(len(p))
+	return len(p), nil
+}
+
+func TestStorage_OversizeBody(t *testing.T) {
+	s, err := New(MaxBytes(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, res := newEntry(t, "/a", "hello")
+	if err := s.Set("a", req, res, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	body := &countingReader{}
+	req, res = newEntry(t, "/a", "")
+	res.Body = io.NopCloser(body)
+	if err := s.Set("a", req, res, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	// The body is read only until it exceeds the limit, and replaces the stored entry without being stored.
+	if body.n > 1001 {
+		t.Errorf("got %d bytes read, want at most %d", body.n, 1001)
+	}
+	if _, _, err := s.Get("a"); !errors.Is(err, httpcache.ErrCacheMiss) {
+		t.Errorf("Storage.Get() error = %v, want %v", err, httpcache.ErrCacheMiss)
+	}
+	if s.Len() != 0 || s.Size() != 0 {
+		t.Errorf("got len %d size %d, want empty", s.Len(), s.Size())
+	}
+}
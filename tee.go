@@ -0,0 +1,122 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// storeBody is the body of a response that is stored while it is read by the caller.
+// The stored entry is committed only when the body is read to EOF and its length matches Content-Length.
+// Otherwise, Storage reads an error from the body passed to Set and discards the entry.
+type storeBody struct {
+	rc     io.ReadCloser
+	pw     *io.PipeWriter
+	length int64
+	n      int64
+	done   chan struct{}
+	once   sync.Once
+}
+
+// newStoreBody starts storing the response for the key, and returns the body that streams the response body to the caller while teeing it into the storage.
+// length is the expected length of the body, or -1 if it is unknown.
+// If combine is not nil, the response combined with it is stored instead.
+func newStoreBody(s Storage, key string, req *http.Request, stored *http.Response, expires time.Time, length int64, combine func(*http.Response) *http.Response) *storeBody {
+	pr, pw := io.Pipe()
+	b := &storeBody{rc: stored.Body, pw: pw, length: length, done: make(chan struct{})}
+	res := *stored
+	res.Body = pr
+	go func(res *http.Response) {
+		defer close(b.done)
+		if combine != nil {
+			res = combine(res)
+		}
+		// If Set returns without reading the body to EOF, the following writes to the pipe fail, and the body is no longer teed.
+		_ = pr.CloseWithError(s.Set(key, req, res, expires))
+		_ = res.Body.Close()
+	}(&res)
+	return b
+}
+
+// Read implements io.Reader.
+func (b *storeBody) Read(p []byte) (int, error) {
+	n, err := b.rc.Read(p)
+	b.n += int64(n)
+	if n > 0 && b.pw != nil {
+		if _, err := b.pw.Write(p[:n]); err != nil {
+			b.pw = nil
+		}
+	}
+	switch {
+	case err == io.EOF && b.length >= 0 && b.n != b.length:
+		// Truncated or overlong bodies are not stored.
+		b.finish(io.ErrUnexpectedEOF)
+	case err == io.EOF:
+		b.finish(nil)
+	case err != nil:
+		b.finish(err)
+	}
+	return n, err
+}
+
+// Close implements io.Closer. Closing the body before EOF discards the stored entry.
+func (b *storeBody) Close() error {
+	b.finish(io.ErrUnexpectedEOF)
+	return b.rc.Close()
+}
+
+// finish commits the stored entry if err is nil, or discards it, and waits for Storage to finish.
+func (b *storeBody) finish(err error) {
+	b.once.Do(func() {
+		if b.pw != nil {
+			_ = b.pw.CloseWithError(err)
+		}
+		<-b.done
+	})
+}
+
+// bodyLength returns the expected length of the response body, or -1 if it is unknown.
+func bodyLength(req *http.Request, res *http.Response) int64 {
+	if req.Method == http.MethodHead || res.StatusCode == http.StatusNoContent || res.StatusCode == http.StatusNotModified {
+		return -1
+	}
+	return res.ContentLength
+}
+
+// drain reads the body of the response to a background request so that it is stored, and replaces the body with an empty one.
+func drain(res *http.Response) (*http.Response, error) {
+	if _, err := io.Copy(io.Discard, res.Body); err != nil {
+		_ = res.Body.Close()
+		return nil, err
+	}
+	if err := res.Body.Close(); err != nil {
+		return nil, err
+	}
+	res.Body = http.NoBody
+	return res, nil
+}
+
+// combineFunc returns the function that combines the response to be stored with the stored partial response if the Handler is a Combiner, or nil.
+// combineFunc takes over the body of the stored response, and closes it unless the combined response reads it.
+func combineFunc(h HandlerV2, cachedRes *http.Response) func(*http.Response) *http.Response {
+	c, ok := unwrap(h).(Combiner)
+	if !ok || cachedRes == nil {
+		closeBody(cachedRes)
+		return nil
+	}
+	return func(res *http.Response) *http.Response {
+		if r, ok := c.Combine(cachedRes, res); ok {
+			return r
+		}
+		closeBody(cachedRes)
+		return res
+	}
+}
+
+// closeBody closes the body of the stored response that is not used, so that Storage can release it.
+func closeBody(res *http.Response) {
+	if res != nil && res.Body != nil {
+		_ = res.Body.Close()
+	}
+}
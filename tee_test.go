@@ -0,0 +1,207 @@
+package httpcache_test
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/k1LoW/httpcache"
+	"github.com/k1LoW/httpcache/rfc9111"
+	"github.com/k1LoW/httpcache/storage/memory"
+)
+
+func TestTransport_StreamingBody(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte("hello\n"))
+		w.(http.Flusher).Flush()
+		<-release
+		_, _ = w.Write([]byte("world\n"))
+	}))
+	t.Cleanup(ts.Close)
+
+	s, err := rfc9111.NewShared()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := memory.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: httpcache.NewTransport(s, store, nil)}
+	res, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	br := bufio.NewReader(res.Body)
+	// The beginning of the body is read before the origin server finishes the response.
+	line, err := br.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "hello\n" {
+		t.Errorf("got %q, want %q", line, "hello\n")
+	}
+	if store.Len() != 0 {
+		t.Error("the response is stored before it is read to EOF")
+	}
+	close(release)
+	rest, err := io.ReadAll(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rest) != "world\n" {
+		t.Errorf("got %q, want %q", string(rest), "world\n")
+	}
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, cachedRes, err := store.Get(httpcache.DefaultKey(req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(cachedRes.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello\nworld\n" {
+		t.Errorf("got stored body %q", string(b))
+	}
+}
+
+func TestTransport_IncompleteBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		read    func(io.Reader) error
+	}{
+		{
+			"truncated by the origin server",
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Cache-Control", "max-age=60")
+				w.Header().Set("Content-Length", "10")
+				_, _ = w.Write([]byte("hello"))
+				w.(http.Flusher).Flush()
+				conn, _, err := w.(http.Hijacker).Hijack()
+				if err == nil {
+					_ = conn.Close()
+				}
+			},
+			func(r io.Reader) error {
+				_, err := io.ReadAll(r)
+				return err
+			},
+		},
+		{
+			"closed by the client",
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Cache-Control", "max-age=60")
+				_, _ = w.Write([]byte(strings.Repeat("a", 1<<16)))
+			},
+			func(r io.Reader) error {
+				_, err := r.Read(make([]byte, 10))
+				return err
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ts := httptest.NewServer(tt.handler)
+			t.Cleanup(ts.Close)
+			s, err := rfc9111.NewShared()
+			if err != nil {
+				t.Fatal(err)
+			}
+			store, err := memory.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &http.Client{Transport: httpcache.NewTransport(s, store, nil)}
+			res, err := client.Get(ts.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = tt.read(res.Body)
+			if err := res.Body.Close(); err != nil {
+				t.Fatal(err)
+			}
+			req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := store.Get(httpcache.DefaultKey(req)); !errors.Is(err, httpcache.ErrCacheMiss) {
+				t.Errorf("Storage.Get() error = %v, want %v", err, httpcache.ErrCacheMiss)
+			}
+		})
+	}
+}
+
+func TestTransport_CombinePartialContent(t *testing.T) {
+	hits := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("0123456789"))
+	}))
+	t.Cleanup(ts.Close)
+
+	s, err := rfc9111.NewShared()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := memory.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: httpcache.NewTransport(s, store, nil)}
+	tests := []struct {
+		rangeValue     string
+		wantStatusCode int
+		wantBody       string
+		wantHits       int
+	}{
+		{"bytes=0-4", http.StatusPartialContent, "01234", 1},
+		{"bytes=1-3", http.StatusPartialContent, "123", 1},
+		{"bytes=5-9", http.StatusPartialContent, "56789", 2},
+		{"", http.StatusOK, "0123456789", 2},
+		{"bytes=3-6", http.StatusPartialContent, "3456", 2},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.rangeValue != "" {
+			req.Header.Set("Range", tt.rangeValue)
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != tt.wantStatusCode {
+			t.Errorf("Range: %q: got status code %d, want %d", tt.rangeValue, res.StatusCode, tt.wantStatusCode)
+		}
+		if string(b) != tt.wantBody {
+			t.Errorf("Range: %q: got body %q, want %q", tt.rangeValue, string(b), tt.wantBody)
+		}
+		if hits != tt.wantHits {
+			t.Errorf("Range: %q: got %d hits, want %d", tt.rangeValue, hits, tt.wantHits)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package httpcache
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+var _ http.RoundTripper = (*Transport)(nil)
+
+// Transport is an http.RoundTripper that caches responses using HandlerV2 and Storage.
+type Transport struct {
+	handler HandlerV2
+	storage Storage
+	base    http.RoundTripper
+	key     KeyFunc
+	// flights is nil if CollapsedForwarding is not set.
+	flights         *flightGroup
+	collapseTimeout time.Duration
+	refreshes       refreshGroup
+}
+
+// NewTransport returns a new Transport.
+// If base is nil, http.DefaultTransport is used.
+func NewTransport(h Handler, store Storage, base http.RoundTripper, opts ...Option) *Transport {
+	return NewTransportV2(AdaptHandler(h), store, base, opts...)
+}
+
+// NewTransportV2 returns a new Transport using HandlerV2.
+// If base is nil, http.DefaultTransport is used.
+func NewTransportV2(h HandlerV2, store Storage, base http.RoundTripper, opts ...Option) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	c := newConfig(opts)
+	t := &Transport{
+		handler: h,
+		storage: store,
+		base:    base,
+		key:     c.key,
+	}
+	if c.collapse {
+		t.flights = &flightGroup{maxBodySize: c.collapseMaxBodySize}
+		t.collapseTimeout = c.collapseTimeout
+	}
+	return t
+}
+
+// RoundTrip implements http.RoundTripper.
+// If the storage is not available, the request is forwarded without the cache.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := t.key(req)
+	cachedReq, cachedRes, varyMiss, err := lookup(t.storage, key, req)
+	if err != nil {
+		// Bypass the cache when the storage is not available.
+		return t.base.RoundTrip(req)
+	}
+
+	var (
+		reqTime, resTime time.Time
+		// sent is set when the request is sent by the base http.RoundTripper, which closes its body.
+		sent bool
+	)
+	do := func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		req = req.WithContext(ctx)
+		if IsBackground(ctx) {
+			return refresh(t.handler, t.storage, &t.refreshes, t.key, key, req, func(req *http.Request) (*http.Response, error) {
+				return t.forward(key, req)
+			})
+		}
+		reqTime = time.Now()
+		res, err := forward(t.flights, t.collapseTimeout, t.handler, key, req, func(req *http.Request) (*http.Response, error) {
+			sent = true
+			return t.base.RoundTrip(req)
+		})
+		resTime = time.Now()
+		return res, err
+	}
+
+	// HandlerV2 may set conditional headers on the request, but RoundTrip must not modify it.
+	ctx := ContextWithCacheKey(req.Context(), key)
+	if varyMiss {
+		ctx = ContextWithVaryMiss(ctx)
+	}
+	d, err := t.handler.Handle(ctx, req.Clone(ctx), cachedReq, cachedRes, do, time.Now())
+	if !sent {
+		// RoundTrip must close the request body even if the request is not sent, such as when the stored response is used.
+		closeRequestBody(req)
+	}
+	if err != nil {
+		closeBody(cachedRes)
+		return nil, err
+	}
+	if d.Response != nil && d.Response.Header != nil {
+		delInternalHeader(d.Response.Header)
+	}
+	return applyDecision(t.handler, t.storage, t.key, key, req.WithContext(ctx), d, cachedReq, cachedRes, reqTime, resTime), nil
+}
+
+// applyDecision updates the storage with the decision made by HandlerV2 for the request, and returns the response to be served.
+// It invalidates the stored responses, and freshens the stored response or stores the response.
+// If the response is stored, its body is replaced so that it is stored while the caller reads it to EOF.
+// Unless the stored response is used, its body is closed, or passed to freshen or storeResponse to be read.
+// The request has been forwarded, so a storage error only means that the storage is not updated.
+func applyDecision(h HandlerV2, s Storage, keyFn KeyFunc, key string, req *http.Request, d *Decision, cachedReq *http.Request, cachedRes *http.Response, reqTime, resTime time.Time) *http.Response {
+	if d.Outcome != OutcomeMiss {
+		cachedRes = nil
+	}
+	res := d.Response
+	_ = invalidate(s, keyFn, req, d.Invalidations)
+	// If the stored response is used after validation, it has been freshened with the 304 (Not Modified) response
+	// and is stored again (https://www.rfc-editor.org/rfc/rfc9111#section-4.3.4).
+	if d.Outcome != OutcomeMiss && d.Outcome != OutcomeRevalidated {
+		return res
+	}
+	if resTime.IsZero() {
+		// The response was not obtained through do.
+		reqTime, resTime = time.Now(), time.Now()
+	}
+	if d.Outcome == OutcomeMiss {
+		if affected, _ := freshen(h, s, key, req, res, cachedReq, cachedRes, reqTime, resTime); affected {
+			return res
+		}
+	}
+	if d.Expires.IsZero() || !storedAgain(d) {
+		closeBody(cachedRes)
+		return res
+	}
+	// A stored partial response may be combined with the new one, because it has not been used.
+	storeResponse(h, s, keyFn, key, req, res, cachedRes, d.Expires, reqTime, resTime)
+	return res
+}
+
+// forward forwards the request, collapsing it with the concurrent requests for the same key if CollapsedForwarding is set.
+func (t *Transport) forward(key string, req *http.Request) (*http.Response, error) {
+	return forward(t.flights, t.collapseTimeout, t.handler, key, req, t.base.RoundTrip)
+}
+
+// closeRequestBody closes the body of the request that is not sent.
+func closeRequestBody(req *http.Request) {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+}
+
+// storedAgain returns false if the response is a range response generated from the stored response after validation.
+// It does not have the whole content, so the stored response is left as it is until it is validated again.
+func storedAgain(d *Decision) bool {
+	if d.Outcome != OutcomeRevalidated {
+		return true
+	}
+	return d.Response.StatusCode != http.StatusPartialContent && d.Response.StatusCode != http.StatusRequestedRangeNotSatisfiable
+}
+
+// storeResponse stores a copy of the response while the caller reads the body.
+// The body of res is replaced so that it is teed into the storage, and the response is stored only if it is read to EOF.
+// If cachedRes is not nil, it is the stored response that has not been used, and may be combined with res. Its body is closed after it is read.
+// The key is recorded for the target URI of the request, so that the response is invalidated with the target URI.
+func storeResponse(h HandlerV2, s Storage, keyFn KeyFunc, key string, req *http.Request, res, cachedRes *http.Response, expires, reqTime, resTime time.Time) {
+	k, err := entryKey(s, key, req, res, expires)
+	if err == nil {
+		err = recordKey(s, keyFn, key, req, expires)
+	}
+	if err != nil {
+		// A storage error only means that the response is not cached.
+		closeBody(cachedRes)
+		return
+	}
+	stored := *res
+	stored.Header = storedHeader(h, req, res, reqTime, resTime)
+	res.Body = newStoreBody(s, k, req, &stored, expires, bodyLength(req, res), combineFunc(h, cachedRes))
+}
+
+// storedHeader returns the header fields of the response to be stored, sanitized if the Handler is a Sanitizer.
+func storedHeader(h HandlerV2, req *http.Request, res *http.Response, reqTime, resTime time.Time) http.Header {
+	var header http.Header
+	if s, ok := unwrap(h).(Sanitizer); ok {
+		header = s.Sanitize(req, res)
+	} else {
+		header = res.Header.Clone()
+	}
+	// Only the index of the stored variants has HeaderVariants, so the origin cannot make a response an index.
+	header.Del(HeaderVariants)
+	header.Del(HeaderKeys)
+	setTimes(header, reqTime, resTime)
+	return header
+}
+
+// setTimes records request_time and response_time in the header of the stored response.
+func setTimes(h http.Header, reqTime, resTime time.Time) {
+	h.Set(HeaderRequestTime, reqTime.Format(time.RFC3339Nano))
+	h.Set(HeaderResponseTime, resTime.Format(time.RFC3339Nano))
+}
+
+// delInternalHeader removes the header fields used internally by Transport and NewMiddleware,
+// so that they are not returned even if the Handler does not remove them.
+func delInternalHeader(h http.Header) {
+	h.Del(HeaderCollapsed)
+	h.Del(HeaderRequestTime)
+	h.Del(HeaderResponseTime)
+	h.Del(HeaderVariants)
+	h.Del(HeaderKeys)
+}
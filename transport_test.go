@@ -0,0 +1,618 @@
+package httpcache_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/k1LoW/httpcache"
+	"github.com/k1LoW/httpcache/rfc9111"
+	"github.com/k1LoW/httpcache/storage/disk"
+	"github.com/k1LoW/httpcache/storage/memory"
+)
+
+func TestTransport(t *testing.T) {
+	tests := []struct {
+		name         string
+		cacheControl string
+		wantHits     int
+	}{
+		{"Cache-Control: max-age=60 -> cached", "max-age=60", 1},
+		{"Cache-Control: no-store -> not cached", "no-store", 3},
+		{"Cache-Control: private -> not cached", "private", 3},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var mu sync.Mutex
+			hits := 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				hits++
+				mu.Unlock()
+				w.Header().Set("Cache-Control", tt.cacheControl)
+				_, _ = w.Write([]byte("hello"))
+			}))
+			t.Cleanup(ts.Close)
+
+			s, err := rfc9111.NewShared()
+			if err != nil {
+				t.Fatal(err)
+			}
+			store, err := memory.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &http.Client{Transport: httpcache.NewTransport(s, store, nil)}
+			for i := 0; i < 3; i++ {
+				res, err := client.Get(ts.URL)
+				if err != nil {
+					t.Fatal(err)
+				}
+				b, err := io.ReadAll(res.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := res.Body.Close(); err != nil {
+					t.Fatal(err)
+				}
+				if string(b) != "hello" {
+					t.Errorf("got body %q, want %q", string(b), "hello")
+				}
+				if got := res.Header.Get("Age") != ""; got != (i > 0 && tt.wantHits == 1) {
+					t.Errorf("got Age %q", res.Header.Get("Age"))
+				}
+				if res.Header.Get(httpcache.HeaderResponseTime) != "" {
+					t.Errorf("got %s %q", httpcache.HeaderResponseTime, res.Header.Get(httpcache.HeaderResponseTime))
+				}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if hits != tt.wantHits {
+				t.Errorf("got %d hits, want %d", hits, tt.wantHits)
+			}
+		})
+	}
+}
+
+func TestTransport_Freshen(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		got = append(got, r.Header.Get("If-None-Match"))
+		mu.Unlock()
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", `"a"`)
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	t.Cleanup(ts.Close)
+
+	s, err := rfc9111.NewShared()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := memory.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := time.Now().Add(-time.Minute)
+	if err := store.Set(httpcache.DefaultKey(req), req, &http.Response{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Cache-Control": []string{"max-age=10, must-revalidate"},
+			"Date":          []string{stale.Format(http.TimeFormat)},
+			"Etag":          []string{`"a"`},
+		},
+		Body: io.NopCloser(strings.NewReader("hello")),
+	}, stale.Add(10*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{Transport: httpcache.NewTransport(s, store, nil)}
+	for i := 0; i < 3; i++ {
+		res, err := client.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := res.Body.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("got status %d, want %d", res.StatusCode, http.StatusOK)
+		}
+		if string(b) != "hello" {
+			t.Errorf("got body %q, want %q", string(b), "hello")
+		}
+		if res.Header.Get("Cache-Control") != "max-age=60" {
+			t.Errorf("got Cache-Control %q, want %q", res.Header.Get("Cache-Control"), "max-age=60")
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 1 || got[0] != `"a"` {
+		t.Errorf("got conditional requests %v, want one validation", got)
+	}
+}
+
+func TestTransport_Invalidate(t *testing.T) {
+	var mu sync.Mutex
+	hits := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			mu.Lock()
+			hits++
+			mu.Unlock()
+			w.Header().Set("Cache-Control", "max-age=60")
+		}
+		_, _ = w.Write([]byte("hello"))
+	}))
+	t.Cleanup(ts.Close)
+
+	s, err := rfc9111.NewShared()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := memory.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: httpcache.NewTransport(s, store, nil)}
+	for _, method := range []string{http.MethodGet, http.MethodGet, http.MethodPost, http.MethodGet} {
+		req, err := http.NewRequest(method, ts.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(io.Discard, res.Body)
+		_ = res.Body.Close()
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if hits != 2 {
+		t.Errorf("got %d hits, want %d", hits, 2)
+	}
+}
+
+// deleteErrorStorage is a Storage that fails to delete entries.
+type deleteErrorStorage struct {
+	*memory.Storage
+}
+
+func (s *deleteErrorStorage) Delete(key string) error {
+	return errors.New("delete error")
+}
+
+func TestTransport_InvalidateError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	}))
+	t.Cleanup(ts.Close)
+
+	s, err := rfc9111.NewShared()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := memory.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: httpcache.NewTransport(s, &deleteErrorStorage{Storage: store}, nil)}
+	res, err := client.Post(ts.URL, "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := res.Body.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello" {
+		t.Errorf("got body %q, want %q", string(b), "hello")
+	}
+}
+
+func TestTransport_Post(t *testing.T) {
+	var mu sync.Mutex
+	hits := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits++
+		mu.Unlock()
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte("hello"))
+	}))
+	t.Cleanup(ts.Close)
+
+	s, err := rfc9111.NewShared()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := memory.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: httpcache.NewTransport(s, store, nil)}
+	for i := 0; i < 3; i++ {
+		res, err := client.Post(ts.URL, "text/plain", strings.NewReader("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(io.Discard, res.Body)
+		_ = res.Body.Close()
+		if res.Header.Get("Age") != "" {
+			t.Errorf("got Age %q", res.Header.Get("Age"))
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if hits != 3 {
+		t.Errorf("got %d hits, want %d", hits, 3)
+	}
+}
+
+func TestTransport_StaleWhileRevalidate(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		hits int
+		once sync.Once
+	)
+	release := make(chan struct{})
+	refreshed := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer once.Do(func() { close(refreshed) })
+		mu.Lock()
+		hits++
+		mu.Unlock()
+		<-release
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte("new"))
+	}))
+	t.Cleanup(ts.Close)
+
+	s, err := rfc9111.NewShared()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := memory.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := time.Now().Add(-time.Minute)
+	if err := store.Set(httpcache.DefaultKey(req), req, &http.Response{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Cache-Control": []string{"max-age=10, stale-while-revalidate=600"},
+			"Date":          []string{stale.Format(http.TimeFormat)},
+		},
+		Body: io.NopCloser(strings.NewReader("old")),
+	}, stale.Add(10*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{Transport: httpcache.NewTransport(s, store, nil)}
+	get := func() string {
+		res, err := client.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	// The stale response is refreshed by one background request while it is served to the concurrent requests.
+	for i := 0; i < 3; i++ {
+		if got := get(); got != "old" {
+			t.Errorf("got body %q, want %q", got, "old")
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	<-refreshed
+	for i := 0; i < 100; i++ {
+		_, res, err := store.Get(httpcache.DefaultKey(req))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Header.Get("Cache-Control") == "max-age=60" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := get(); got != "new" {
+		t.Errorf("got body %q, want %q", got, "new")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if hits != 1 {
+		t.Errorf("got %d hits, want %d", hits, 1)
+	}
+}
+
+// closeCountingStorage counts the bodies of stored responses returned by Get and closed.
+type closeCountingStorage struct {
+	*disk.Storage
+	mu     sync.Mutex
+	opened int
+	closed int
+}
+
+func (s *closeCountingStorage) Get(key string) (*http.Request, *http.Response, error) {
+	req, res, err := s.Storage.Get(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.mu.Lock()
+	s.opened++
+	s.mu.Unlock()
+	res.Body = &countingBody{ReadCloser: res.Body, s: s}
+	return req, res, nil
+}
+
+type countingBody struct {
+	io.ReadCloser
+	s    *closeCountingStorage
+	once sync.Once
+}
+
+func (b *countingBody) Close() error {
+	b.once.Do(func() {
+		b.s.mu.Lock()
+		b.s.closed++
+		b.s.mu.Unlock()
+	})
+	return b.ReadCloser.Close()
+}
+
+func TestTransport_CloseStoredBody(t *testing.T) {
+	tests := []struct {
+		name         string
+		cacheControl string
+		vary         string
+		reqHeaders   []http.Header
+	}{
+		{"hit", "max-age=60", "", []http.Header{{}, {}, {}}},
+		{"no-cache request", "max-age=60", "", []http.Header{{}, {"Cache-Control": []string{"no-cache"}}, {"Cache-Control": []string{"no-cache"}}}},
+		{"no-cache response", "max-age=60, no-cache", "", []http.Header{{}, {}, {}}},
+		{"vary mismatch", "max-age=60", "X-Test", []http.Header{{"X-Test": []string{"a"}}, {"X-Test": []string{"b"}}, {"X-Test": []string{"c"}}}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Cache-Control", tt.cacheControl)
+				if tt.vary != "" {
+					w.Header().Set("Vary", tt.vary)
+				}
+				_, _ = w.Write([]byte("hello"))
+			})
+			s, err := rfc9111.NewShared()
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := disk.New(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			store := &closeCountingStorage{Storage: d}
+			ts := httptest.NewServer(h)
+			t.Cleanup(ts.Close)
+			mts := httptest.NewServer(httpcache.NewMiddleware(s, store)(h))
+			t.Cleanup(mts.Close)
+
+			clients := []struct {
+				client *http.Client
+				url    string
+			}{
+				{&http.Client{Transport: httpcache.NewTransport(s, store, nil)}, ts.URL},
+				{http.DefaultClient, mts.URL},
+			}
+			for _, c := range clients {
+				for _, header := range tt.reqHeaders {
+					req, err := http.NewRequest(http.MethodGet, c.url, nil)
+					if err != nil {
+						t.Fatal(err)
+					}
+					req.Header = header.Clone()
+					res, err := c.client.Do(req)
+					if err != nil {
+						t.Fatal(err)
+					}
+					_, _ = io.Copy(io.Discard, res.Body)
+					_ = res.Body.Close()
+				}
+			}
+			store.mu.Lock()
+			defer store.mu.Unlock()
+			if store.opened == 0 {
+				t.Error("no stored response is returned")
+			}
+			if store.closed != store.opened {
+				t.Errorf("got %d closed bodies, want %d", store.closed, store.opened)
+			}
+		})
+	}
+}
+
+func TestTransport_RangeAfterValidation(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", `"a"`)
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	t.Cleanup(ts.Close)
+
+	s, err := rfc9111.NewShared()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := memory.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := time.Now().Add(-time.Minute)
+	if err := store.Set(httpcache.DefaultKey(req), req, &http.Response{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Cache-Control":  []string{"max-age=10"},
+			"Content-Length": []string{"10"},
+			"Date":           []string{stale.Format(http.TimeFormat)},
+			"Etag":           []string{`"a"`},
+		},
+		Body: io.NopCloser(strings.NewReader("0123456789")),
+	}, stale.Add(10*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{Transport: httpcache.NewTransport(s, store, nil)}
+	rreq, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rreq.Header.Set("Range", "bytes=2-5")
+	res, err := client.Do(rreq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusPartialContent || string(b) != "2345" {
+		t.Errorf("got status %d and body %q, want %d and %q", res.StatusCode, string(b), http.StatusPartialContent, "2345")
+	}
+
+	// The range response is not stored in place of the complete response.
+	_, cachedRes, err := store.Get(httpcache.DefaultKey(req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = io.ReadAll(cachedRes.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cachedRes.StatusCode != http.StatusOK || string(b) != "0123456789" {
+		t.Errorf("got stored status %d and body %q, want %d and %q", cachedRes.StatusCode, string(b), http.StatusOK, "0123456789")
+	}
+}
+
+// getErrorStorage is a Storage that fails to get entries.
+type getErrorStorage struct {
+	*memory.Storage
+}
+
+func (s *getErrorStorage) Get(key string) (*http.Request, *http.Response, error) {
+	return nil, nil, errors.New("get error")
+}
+
+func TestTransport_GetError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte("hello"))
+	}))
+	t.Cleanup(ts.Close)
+
+	s, err := rfc9111.NewShared()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := memory.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: httpcache.NewTransport(s, &getErrorStorage{Storage: store}, nil)}
+	// The request is forwarded without the cache when the storage is not available.
+	res, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := res.Body.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello" {
+		t.Errorf("got body %q, want %q", string(b), "hello")
+	}
+}
+
+// closedBody is a request body that records whether it is closed.
+type closedBody struct {
+	io.Reader
+	closed bool
+}
+
+func (b *closedBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestTransport_CloseRequestBody(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte("hello"))
+	}))
+	t.Cleanup(ts.Close)
+
+	s, err := rfc9111.NewShared()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := memory.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := httpcache.NewTransport(s, store, nil)
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := &closedBody{Reader: strings.NewReader("")}
+		req.Body = body
+		res, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(io.Discard, res.Body)
+		_ = res.Body.Close()
+		// The second request is answered from the cache without being sent.
+		if !body.closed {
+			t.Errorf("request %d: the request body is not closed", i)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package httpcache
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxVariants is the maximum number of variants stored for a primary cache key.
+// When a new variant is stored for a primary key with MaxVariants variants, the least recently stored variant is deleted,
+// so that the index, which is read and rewritten for every new variant, does not grow without bound with Vary header fields such as User-Agent.
+const MaxVariants = 32
+
+// variantSeparator separates the primary cache key (the target URI) from the secondary key of a stored variant.
+const variantSeparator = "\x00vary\x00"
+
+// caseInsensitiveFields are the request header fields whose values are defined to be case-insensitive,
+// so that they are case-normalized before they are compared (https://www.rfc-editor.org/rfc/rfc9111#section-4.1).
+var caseInsensitiveFields = []string{
+	"Accept",
+	"Accept-Charset",
+	"Accept-Encoding",
+	"Accept-Language",
+}
+
+// VaryKey returns the secondary cache key of a request, which consists of the normalized values of the request header fields nominated by the Vary header field values.
+// Two requests match the stored response if their secondary keys are equal (https://www.rfc-editor.org/rfc/rfc9111#section-4.1).
+// It returns false if the Vary header field contains "*", which always fails to match.
+func VaryKey(vary []string, header http.Header) (string, bool) {
+	var names []string
+	for _, v := range vary {
+		for _, n := range strings.Split(v, ",") {
+			n = http.CanonicalHeaderKey(strings.TrimSpace(n))
+			if n == "" {
+				continue
+			}
+			if n == "*" {
+				return "", false
+			}
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for i, n := range names {
+		if i > 0 && names[i-1] == n {
+			continue
+		}
+		b.WriteString(n)
+		values, ok := header[n]
+		if !ok {
+			// If a header field is absent from a request, it can only match another request if it is also absent there.
+			b.WriteByte('\n')
+			continue
+		}
+		b.WriteByte(':')
+		b.WriteString(normalizeFieldValue(n, values))
+		b.WriteByte('\n')
+	}
+	return b.String(), true
+}
+
+// normalizeFieldValue combines multiple field lines and removes whitespace around list elements.
+func normalizeFieldValue(name string, values []string) string {
+	var elems []string
+	for _, v := range values {
+		for _, e := range strings.Split(v, ",") {
+			e = strings.Join(strings.Fields(e), " ")
+			if e == "" {
+				continue
+			}
+			elems = append(elems, e)
+		}
+	}
+	v := strings.Join(elems, ",")
+	for _, f := range caseInsensitiveFields {
+		if name == f {
+			return strings.ToLower(v)
+		}
+	}
+	return v
+}
+
+// variantKey returns the cache key of the stored variant of the response for the request.
+// It returns false if the response does not vary or always fails to match.
+func variantKey(key string, req *http.Request, res *http.Response) (string, bool) {
+	vary := res.Header.Values("Vary")
+	if len(vary) == 0 {
+		return "", false
+	}
+	vk, ok := VaryKey(vary, req.Header)
+	if !ok {
+		return "", false
+	}
+	return key + variantSeparator + vk, true
+}
+
+// PrimaryKey returns the primary cache key of the key of a stored variant, and false if the key is not the key of a variant.
+// Storage implementations that evict entries can use it to evict the variants together with the index stored for their primary key.
+func PrimaryKey(key string) (string, bool) {
+	primary, _, ok := strings.Cut(key, variantSeparator)
+	return primary, ok
+}
+
+// indexedVariants returns the cache keys of the stored variants listed in the index, and false if the response is not an index stored for the primary key.
+// Only storeIndex sets HeaderVariants on stored responses, because it is removed from the responses to be stored,
+// and only the keys of the variants of the primary key are listed, so that an index never refers to the entries for other keys.
+func indexedVariants(key string, res *http.Response) ([]string, bool) {
+	values := res.Header.Values(HeaderVariants)
+	if len(values) == 0 {
+		return nil, false
+	}
+	variants := make([]string, 0, len(values))
+	for _, v := range values {
+		vk, err := strconv.Unquote(v)
+		if err != nil || !strings.HasPrefix(vk, key+variantSeparator) {
+			return nil, false
+		}
+		variants = append(variants, vk)
+	}
+	return variants, true
+}
+
+// indexLocks serializes the updates of the stored variant index for each primary key, which is read, modified, and written.
+var indexLocks keyMutex
+
+// keyMutex is a set of mutexes for keys, so that the updates for different keys do not wait for each other.
+type keyMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	mu sync.Mutex
+	n  int
+}
+
+// lock locks the mutex for the key, and returns the function to unlock it.
+func (m *keyMutex) lock(key string) func() {
+	m.mu.Lock()
+	if m.locks == nil {
+		m.locks = map[string]*keyLock{}
+	}
+	l, ok := m.locks[key]
+	if !ok {
+		l = &keyLock{}
+		m.locks[key] = l
+	}
+	l.n++
+	m.mu.Unlock()
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		l.n--
+		if l.n == 0 {
+			delete(m.locks, key)
+		}
+	}
+}
+
+// lookup returns the stored request and response selected for the request.
+// If the responses for the primary key vary, the entry for the primary key is the index of the stored variants,
+// and its Vary header field locates the stored variant that matches the request.
+// varyMiss is true if variants are stored for the primary key, but none of them matches the request.
+func lookup(s Storage, key string, req *http.Request) (cachedReq *http.Request, cachedRes *http.Response, varyMiss bool, err error) {
+	cachedReq, cachedRes, err = s.Get(key)
+	if err != nil {
+		if errors.Is(err, ErrCacheMiss) {
+			return nil, nil, false, nil
+		}
+		return nil, nil, false, err
+	}
+	if _, ok := indexedVariants(key, cachedRes); !ok {
+		return cachedReq, cachedRes, false, nil
+	}
+	_ = cachedRes.Body.Close()
+	vk, ok := variantKey(key, req, cachedRes)
+	if !ok {
+		return nil, nil, true, nil
+	}
+	vReq, vRes, err := s.Get(vk)
+	if err != nil {
+		if errors.Is(err, ErrCacheMiss) {
+			return nil, nil, true, nil
+		}
+		return nil, nil, false, err
+	}
+	return vReq, vRes, false, nil
+}
+
+// entryKey returns the key to store the response for: the key of its variant if the response varies, or the primary key.
+// The index of the variants stored for the primary key is updated, so that the entry for the key is found and deleted with the primary key.
+// If it returns an error, the response must not be stored.
+func entryKey(s Storage, key string, req *http.Request, res *http.Response, expires time.Time) (string, error) {
+	vk, ok := variantKey(key, req, res)
+	if !ok {
+		// The response replaces the index, so the variants would no longer be found.
+		return key, deleteVariants(s, key)
+	}
+	return vk, storeIndex(s, key, vk, req, res, expires)
+}
+
+// storeIndex adds the key of the stored variant to the index stored for the primary key.
+// The index lists the variants in the order in which they are stored, most recent first, and the variants beyond MaxVariants are deleted.
+// The index has the Vary header field of the most recently stored variant, and expires when the last of the variants expires.
+// A response stored for the primary key that is not an index is replaced, and its expiration time is not inherited.
+func storeIndex(s Storage, key, vk string, req *http.Request, res *http.Response, expires time.Time) error {
+	defer indexLocks.lock(key)()
+	variants := []string{strconv.Quote(vk)}
+	_, idx, err := s.Get(key)
+	switch {
+	case err == nil:
+		_ = idx.Body.Close()
+		stored, ok := indexedVariants(key, idx)
+		if !ok {
+			break
+		}
+		for _, v := range stored {
+			if v == vk {
+				continue
+			}
+			if len(variants) >= MaxVariants {
+				if err := s.Delete(v); err != nil {
+					return err
+				}
+				continue
+			}
+			variants = append(variants, strconv.Quote(v))
+		}
+		if e, err := http.ParseTime(idx.Header.Get("Expires")); err == nil && e.After(expires) {
+			expires = e
+		}
+	case !errors.Is(err, ErrCacheMiss):
+		return err
+	}
+	// The expiration time is rounded up to the resolution of HTTP-date.
+	expires = expires.Truncate(time.Second).Add(time.Second)
+	return s.Set(key, &http.Request{
+		Method: req.Method,
+		URL:    req.URL,
+		Host:   req.Host,
+		Header: http.Header{},
+	}, &http.Response{
+		Status:     res.Status,
+		StatusCode: res.StatusCode,
+		Proto:      res.Proto,
+		ProtoMajor: res.ProtoMajor,
+		ProtoMinor: res.ProtoMinor,
+		Header: http.Header{
+			"Vary":         res.Header.Values("Vary"),
+			"Expires":      []string{expires.UTC().Format(http.TimeFormat)},
+			HeaderVariants: variants,
+		},
+		Body: http.NoBody,
+	}, expires)
+}
+
+// deleteVariants deletes the variants listed in the index stored for the primary key, if any.
+// Keys that are not the keys of the variants of the primary key are never deleted.
+func deleteVariants(s Storage, key string) error {
+	defer indexLocks.lock(key)()
+	_, idx, err := s.Get(key)
+	if err != nil {
+		if errors.Is(err, ErrCacheMiss) {
+			return nil
+		}
+		return err
+	}
+	_ = idx.Body.Close()
+	variants, _ := indexedVariants(key, idx)
+	for _, vk := range variants {
+		if err := s.Delete(vk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteEntry deletes the stored response for the primary key and all of its stored variants.
+func deleteEntry(s Storage, key string) error {
+	if err := deleteVariants(s, key); err != nil {
+		return err
+	}
+	return s.Delete(key)
+}
@@ -0,0 +1,494 @@
+package httpcache_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/k1LoW/httpcache"
+	"github.com/k1LoW/httpcache/rfc9111"
+	"github.com/k1LoW/httpcache/storage/memory"
+)
+
+func TestVaryKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		vary      []string
+		a         http.Header
+		b         http.Header
+		wantOK    bool
+		wantMatch bool
+	}{
+		{"no Vary", nil, http.Header{"Accept": []string{"a"}}, http.Header{"Accept": []string{"b"}}, true, true},
+		{"same value", []string{"Accept-Encoding"}, http.Header{"Accept-Encoding": []string{"gzip"}}, http.Header{"Accept-Encoding": []string{"gzip"}}, true, true},
+		{"different value", []string{"Accept-Encoding"}, http.Header{"Accept-Encoding": []string{"gzip"}}, http.Header{"Accept-Encoding": []string{"br"}}, true, false},
+		{"whitespace", []string{"accept-encoding"}, http.Header{"Accept-Encoding": []string{"gzip,  br"}}, http.Header{"Accept-Encoding": []string{" gzip , br "}}, true, true},
+		{"multiple field lines", []string{"Accept-Language"}, http.Header{"Accept-Language": []string{"en", "ja"}}, http.Header{"Accept-Language": []string{"en, ja"}}, true, true},
+		{"case-insensitive value", []string{"Accept-Language"}, http.Header{"Accept-Language": []string{"EN-US"}}, http.Header{"Accept-Language": []string{"en-us"}}, true, true},
+		{"case-sensitive value", []string{"X-User"}, http.Header{"X-User": []string{"Alice"}}, http.Header{"X-User": []string{"alice"}}, true, false},
+		{"multiple Vary field lines", []string{"Accept", "X-User, accept"}, http.Header{"Accept": []string{"a"}, "X-User": []string{"u"}}, http.Header{"X-User": []string{"u"}, "Accept": []string{"a"}}, true, true},
+		{"absent and empty", []string{"X-User"}, http.Header{}, http.Header{"X-User": []string{""}}, true, false},
+		{"*", []string{"Accept, *"}, http.Header{}, http.Header{}, false, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			a, ok := httpcache.VaryKey(tt.vary, tt.a)
+			if ok != tt.wantOK {
+				t.Errorf("VaryKey() got ok %v, want %v", ok, tt.wantOK)
+			}
+			b, _ := httpcache.VaryKey(tt.vary, tt.b)
+			if got := a == b; got != tt.wantMatch {
+				t.Errorf("VaryKey() got %q and %q", a, b)
+			}
+		})
+	}
+}
+
+func TestTransport_Vary(t *testing.T) {
+	var mu sync.Mutex
+	hits := map[string]int{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lang := r.Header.Get("Accept-Language")
+		mu.Lock()
+		hits[r.Method+" "+lang]++
+		mu.Unlock()
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		_, _ = w.Write([]byte(lang))
+	}))
+	t.Cleanup(ts.Close)
+
+	s, err := rfc9111.NewShared()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := memory.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: httpcache.NewTransport(s, store, nil)}
+	do := func(method, lang string) {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept-Language", lang)
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if method == http.MethodGet && !strings.EqualFold(string(b), lang) {
+			t.Errorf("got body %q, want %q", string(b), lang)
+		}
+	}
+	do(http.MethodGet, "en")
+	do(http.MethodGet, "ja")
+	do(http.MethodGet, "EN")
+	do(http.MethodGet, "ja")
+	// Unsafe methods invalidate all the variants.
+	do(http.MethodPost, "en")
+	do(http.MethodGet, "ja")
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := map[string]int{"GET en": 1, "GET ja": 2, "POST en": 1}
+	for k, v := range want {
+		if hits[k] != v {
+			t.Errorf("got %d hits for %s, want %d", hits[k], k, v)
+		}
+	}
+}
+
+// noRangeStorage is a Storage that fails the test if the stored keys are scanned.
+type noRangeStorage struct {
+	*memory.Storage
+	t *testing.T
+}
+
+func (s *noRangeStorage) Range(fn func(key string, expires time.Time) bool) error {
+	s.t.Error("the stored keys are scanned")
+	return s.Storage.Range(fn)
+}
+
+func TestTransport_VaryIndex(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "Accept-Language")
+		}
+		_, _ = w.Write([]byte(r.Header.Get("Accept-Language")))
+	}))
+	t.Cleanup(ts.Close)
+
+	s, err := rfc9111.NewShared()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := memory.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &noRangeStorage{Storage: m, t: t}
+	client := &http.Client{Transport: httpcache.NewTransport(s, store, nil)}
+	do := func(method, lang string) {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept-Language", lang)
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(io.Discard, res.Body)
+		_ = res.Body.Close()
+	}
+	keys := func() int {
+		n := 0
+		_ = m.Range(func(string, time.Time) bool {
+			n++
+			return true
+		})
+		return n
+	}
+	do(http.MethodGet, "en")
+	do(http.MethodGet, "ja")
+
+	// The entry for the primary key is the index of the variants, which has no content.
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, idx, err := m.Get(httpcache.DefaultKey(req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(idx.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 0 {
+		t.Errorf("got index body %q", string(b))
+	}
+	if got := len(idx.Header.Values(httpcache.HeaderVariants)); got != 2 {
+		t.Errorf("got %d variants, want %d", got, 2)
+	}
+	if got := keys(); got != 3 {
+		t.Errorf("got %d stored keys, want %d", got, 3)
+	}
+
+	// Unsafe methods invalidate the index and all the variants listed in it.
+	do(http.MethodPost, "en")
+	if got := keys(); got != 0 {
+		t.Errorf("got %d stored keys, want %d", got, 0)
+	}
+}
+
+func TestTransport_VaryIndexExpires(t *testing.T) {
+	var mu sync.Mutex
+	vary := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if vary {
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "Accept-Language")
+		} else {
+			w.Header().Set("Expires", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+		}
+		_, _ = w.Write([]byte("hello"))
+	}))
+	t.Cleanup(ts.Close)
+
+	s, err := rfc9111.NewShared()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := memory.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: httpcache.NewTransport(s, store, nil)}
+	do := func(header http.Header) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header = header
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(io.Discard, res.Body)
+		_ = res.Body.Close()
+	}
+	do(http.Header{})
+	mu.Lock()
+	vary = true
+	mu.Unlock()
+	// The varying response replaces the response stored for the primary key with the index, which does not inherit its expiration time.
+	do(http.Header{"Cache-Control": []string{"no-cache"}})
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, idx, err := store.Get(httpcache.DefaultKey(req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = idx.Body.Close()
+	if len(idx.Header.Values(httpcache.HeaderVariants)) != 1 {
+		t.Errorf("got %s %v", httpcache.HeaderVariants, idx.Header.Values(httpcache.HeaderVariants))
+	}
+	expires, err := http.ParseTime(idx.Header.Get("Expires"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expires.After(time.Now().Add(2 * time.Minute)) {
+		t.Errorf("got Expires %v, want the expiration time of the variant", expires)
+	}
+}
+
+func TestTransport_VariantsFromOrigin(t *testing.T) {
+	var mu sync.Mutex
+	hits := map[string]int{}
+	var victim string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.Method+" "+r.URL.Path]++
+		mu.Unlock()
+		w.Header().Set("Cache-Control", "max-age=60")
+		if r.URL.Path == "/evil" {
+			// The origin tries to make its stored response an index listing the key of another stored response.
+			w.Header().Set(httpcache.HeaderVariants, strconv.Quote(victim))
+		}
+		_, _ = w.Write([]byte(r.URL.Path))
+	}))
+	t.Cleanup(ts.Close)
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/victim", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	victim = httpcache.DefaultKey(req)
+
+	s, err := rfc9111.NewShared()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := memory.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: httpcache.NewTransport(s, store, nil)}
+	do := func(method, path string) {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if _, err := io.ReadAll(res.Body); err != nil {
+			t.Fatal(err)
+		}
+		if v := res.Header.Get(httpcache.HeaderVariants); v != "" {
+			t.Errorf("got %s %s", httpcache.HeaderVariants, v)
+		}
+	}
+	do(http.MethodGet, "/victim")
+	do(http.MethodGet, "/evil")
+	do(http.MethodGet, "/evil")
+	// Invalidating the response of the origin does not delete the other stored response.
+	do(http.MethodPost, "/evil")
+	do(http.MethodGet, "/victim")
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := map[string]int{"GET /victim": 1, "GET /evil": 1, "POST /evil": 1}
+	for k, v := range want {
+		if hits[k] != v {
+			t.Errorf("got %d hits for %s, want %d", hits[k], k, v)
+		}
+	}
+}
+
+func TestTransport_VaryIndexEvicted(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		if r.URL.Path == "/vary" {
+			w.Header().Set("Vary", "Accept-Language")
+		}
+		_, _ = w.Write([]byte(r.URL.Path))
+	}))
+	t.Cleanup(ts.Close)
+
+	s, err := rfc9111.NewShared()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := memory.New(memory.MaxEntries(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: httpcache.NewTransport(s, store, nil)}
+	for _, path := range []string{"/vary", "/a", "/b"} {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept-Language", "en")
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(io.Discard, res.Body)
+		_ = res.Body.Close()
+	}
+	// The variant is evicted together with the index, which is the least recently used entry, so that it is never left without being invalidated.
+	var keys []string
+	_ = store.Range(func(key string, _ time.Time) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if len(keys) != 2 {
+		t.Errorf("got keys %q, want the keys of /a and /b", keys)
+	}
+}
+
+func TestVaryMissCacheStatus(t *testing.T) {
+	for _, middleware := range []bool{false, true} {
+		middleware := middleware
+		name := "Transport"
+		if middleware {
+			name = "middleware"
+		}
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Cache-Control", "max-age=60")
+				w.Header().Set("Vary", "Accept-Language")
+				_, _ = w.Write([]byte(r.Header.Get("Accept-Language")))
+			})
+			s, err := rfc9111.NewShared(rfc9111.CacheStatus("test"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			store, err := memory.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var (
+				ts     *httptest.Server
+				client *http.Client
+			)
+			if middleware {
+				ts = httptest.NewServer(httpcache.NewMiddleware(s, store)(h))
+				client = http.DefaultClient
+			} else {
+				ts = httptest.NewServer(h)
+				client = &http.Client{Transport: httpcache.NewTransport(s, store, nil)}
+			}
+			t.Cleanup(ts.Close)
+			do := func(lang string) string {
+				t.Helper()
+				req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				req.Header.Set("Accept-Language", lang)
+				res, err := client.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, _ = io.Copy(io.Discard, res.Body)
+				_ = res.Body.Close()
+				return res.Header.Get("Cache-Status")
+			}
+			if got := do("en"); !strings.Contains(got, "fwd=uri-miss") {
+				t.Errorf("got Cache-Status %q, want fwd=uri-miss", got)
+			}
+			// A variant is stored for the target URI, but it does not match the request.
+			if got := do("ja"); !strings.Contains(got, "fwd=vary-miss") {
+				t.Errorf("got Cache-Status %q, want fwd=vary-miss", got)
+			}
+			if got := do("ja"); !strings.Contains(got, "hit") {
+				t.Errorf("got Cache-Status %q, want hit", got)
+			}
+		})
+	}
+}
+
+func TestTransport_MaxVariants(t *testing.T) {
+	var mu sync.Mutex
+	hits := map[string]int{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.Header.Get("X-User")]++
+		mu.Unlock()
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "X-User")
+		_, _ = w.Write([]byte(r.Header.Get("X-User")))
+	}))
+	t.Cleanup(ts.Close)
+
+	s, err := rfc9111.NewShared()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := memory.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: httpcache.NewTransport(s, store, nil)}
+	do := func(user string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-User", user)
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(io.Discard, res.Body)
+		_ = res.Body.Close()
+	}
+	for i := 0; i <= httpcache.MaxVariants; i++ {
+		do(strconv.Itoa(i))
+	}
+	if got := store.Len(); got != httpcache.MaxVariants+1 {
+		t.Errorf("got %d stored entries, want the index and %d variants", got, httpcache.MaxVariants)
+	}
+	// The least recently stored variant is deleted, and the others are still stored.
+	do("0")
+	do(strconv.Itoa(httpcache.MaxVariants))
+
+	mu.Lock()
+	defer mu.Unlock()
+	if hits["0"] != 2 {
+		t.Errorf("got %d hits for the least recently stored variant, want %d", hits["0"], 2)
+	}
+	if got := hits[strconv.Itoa(httpcache.MaxVariants)]; got != 1 {
+		t.Errorf("got %d hits for the most recently stored variant, want %d", got, 1)
+	}
+}